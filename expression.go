@@ -1 +1,457 @@
+// Package expression compiles and evaluates expressions over JSON-like data.
+// See the README for the expression language.
 package expression
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+type (
+	// Path is a path into the data an expression is evaluated against. Each
+	// element is either a string object key or an int array index, which
+	// counts back from the end of the array if it's negative. A path relative
+	// to the element of an array function, like @.price, starts with an
+	// Element. A path with a Wildcard, Slice, Filter or Descendants segment
+	// fans out to the array of every value it selects, which the PathParsers
+	// in this package return from GetValue and GetArray. A path that only fans out through
+	// Filters can also be looked up as a single value, the first it selects.
+	Path = internal.Path
+
+	// Element is the first segment of a path relative to the element of an
+	// array function. It's the name of the lambda parameter the path refers
+	// to, or "" for the innermost element.
+	Element = internal.Element
+
+	// Wildcard is a path segment written [*], which selects every element of
+	// an array.
+	Wildcard = internal.Wildcard
+
+	// Slice is a path segment written [start:end], which selects the
+	// elements of an array from Start up to End, or the end of the array if
+	// HasEnd isn't set. Negative bounds count back from the end of the array.
+	Slice = internal.Slice
+
+	// Filter is a path segment written [?(pred)], which selects the elements
+	// of an array for which the boolean expression pred is true, with the
+	// element as @.
+	Filter = internal.Filter
+
+	// Descendants is a path segment written .., which selects the value it's
+	// applied to and every value nested in it, each before the values nested
+	// in it. Object keys are visited in document order by NewRawJSONParser
+	// and NewStructParser, and in sorted order otherwise.
+	Descendants = internal.Descendants
+
+	// PathParser looks up the values that paths in an expression refer to.
+	// Each method returns false if the path doesn't exist or the value found
+	// isn't of the requested type. GetValue returns nil and true for a value
+	// that exists but is null, which the other methods don't find.
+	PathParser = internal.PathParser
+
+	// Kind is the type of value an expression evaluates to.
+	Kind = internal.Kind
+
+	// Type describes the shape of the data a program will be evaluated
+	// against, for type checking with the WithSchema option.
+	Type = internal.Type
+
+	// Position is a location in an expression's source.
+	Position = internal.Position
+
+	// Span is a range of an expression's source.
+	Span = internal.Span
+
+	// Error is the type of error returned by Compile. Its Snippet method
+	// renders the line of source the error is on with a caret under the
+	// problem.
+	Error = internal.Error
+
+	// MissingPathError is returned by a Strict program when a path without a
+	// default value isn't found.
+	MissingPathError = internal.MissingPathError
+
+	// TypeMismatchError is returned by a Strict program when a path without a
+	// default value is found but isn't of the type it's used as.
+	TypeMismatchError = internal.TypeMismatchError
+
+	// NullValueError is returned by a Strict program when a path without a
+	// default value is found but is null, where a number, boolean, string or
+	// array is needed.
+	NullValueError = internal.NullValueError
+
+	// Program is a compiled expression.
+	Program struct {
+		expr    internal.Expression
+		strict  bool
+		decimal *internal.DecimalMode
+	}
+
+	// Option configures Compile.
+	Option func(*config)
+
+	config struct {
+		noReduce bool
+		schema   *Type
+		strict   bool
+		decimal  *internal.DecimalMode
+	}
+)
+
+var (
+	// ErrDivisionByZero is returned by a Strict program when a number is
+	// divided by zero, including with % and //, or zero is raised to a
+	// negative power.
+	ErrDivisionByZero = internal.ErrDivisionByZero
+	// ErrNaN is returned by a Strict program when a number expression
+	// evaluates to NaN.
+	ErrNaN = internal.ErrNaN
+	// ErrEmptyArray is returned by a Strict program when the minimum,
+	// maximum or average of an empty array is taken.
+	ErrEmptyArray = internal.ErrEmptyArray
+	// ErrDomain is returned by a Strict program when a math function or
+	// operator is applied to a number it isn't defined for, like the square
+	// root of a negative number.
+	ErrDomain = internal.ErrDomain
+)
+
+// DefaultMaxDepth is how many levels below the value it's applied to a
+// Descendants path segment descends, unless the PathParser is wrapped by
+// LimitDepth.
+const DefaultMaxDepth = internal.DefaultMaxDepth
+
+const (
+	// AnyKind is the kind of an expression that is a bare path, whose type
+	// isn't known until it's evaluated.
+	AnyKind     = internal.AnyKind
+	NumberKind  = internal.NumberKind
+	BooleanKind = internal.BooleanKind
+	StringKind  = internal.StringKind
+	ArrayKind   = internal.ArrayKind
+	// ObjectKind is only used in a Type.
+	ObjectKind = internal.ObjectKind
+)
+
+// NoReduce disables constant folding, so the program keeps the exact shape of
+// the source expression.
+func NoReduce() Option {
+	return func(c *config) {
+		c.noReduce = true
+	}
+}
+
+// WithSchema type checks the expression against schema, the type of the data
+// the program will be evaluated against. Compile fails if the expression uses
+// paths that aren't in the schema or uses a path as the wrong type.
+func WithSchema(schema *Type) Option {
+	return func(c *config) {
+		c.schema = schema
+	}
+}
+
+// Strict makes the program return errors instead of default values. By
+// default a path without a default value that isn't found or is null evaluates
+// to the zero value of its type, and dividing by zero or applying a math
+// function to a number it isn't defined for results in an infinity or NaN. A
+// Strict program returns a *MissingPathError, a *NullValueError if the path is
+// null, a *TypeMismatchError if the path is found with the wrong type,
+// ErrDivisionByZero, ErrDomain, ErrEmptyArray or ErrNaN instead.
+func Strict() Option {
+	return func(c *config) {
+		c.strict = true
+	}
+}
+
+// Decimal makes the program evaluate numbers exactly, as decimals, instead of
+// as float64s, so that 0.1 + 0.2 == 0.3. Quotients and averages, powers with
+// negative exponents and the results of sqrt, log, exp and powers that aren't
+// integers are rounded to scale digits after the decimal point, or to a power
+// of ten if scale is negative, as rounding says, which is also how round
+// rounds. Literals, and numbers in raw JSON or decoded as json.Numbers, are
+// read as the decimal they're written as, at any precision. Other path values
+// are taken to be the shortest decimal that rounds to their float64 value. A
+// number used by anything but arithmetic, like a comparison, is the float64
+// closest to its exact value.
+// There's no decimal infinity, so dividing by zero results in NaN.
+//
+// A Decimal program isn't reduced, since constant folding is done with
+// float64s. Use EvalDecimal for its exact result.
+func Decimal(scale int, rounding big.RoundingMode) Option {
+	return func(c *config) {
+		c.decimal = &internal.DecimalMode{Scale: scale, Rounding: rounding}
+	}
+}
+
+// Compile lexes, parses and reduces src into a Program. Any error it returns
+// is an *Error.
+func Compile(src string, opts ...Option) (*Program, error) {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	expr, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return compile(src, expr, c)
+}
+
+// CompileJSON is like Compile but takes the JSON form of an expression written
+// by Program.MarshalJSON instead of its source. Errors that aren't in the JSON
+// syntax itself are *Errors without a position.
+func CompileJSON(data []byte, opts ...Option) (*Program, error) {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	expr, err := internal.UnmarshalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return compile("", expr, c)
+}
+
+func compile(src string, expr internal.Expression, c config) (*Program, error) {
+	if c.schema != nil {
+		if errs := check(src, expr, c.schema); len(errs) > 0 {
+			return nil, errs[0]
+		}
+	}
+	if !c.noReduce && c.decimal == nil {
+		expr = expr.Reduce()
+	}
+	return &Program{expr: expr, strict: c.strict, decimal: c.decimal}, nil
+}
+
+// NewJSONParser returns a PathParser for v, a value decoded by encoding/json
+// into an interface{}. Numbers can be float64, json.Number (when the decoder's
+// UseNumber is set) or any other Go int, uint or float type, and are all
+// returned as float64. A path isn't found if it indexes an array with a key, an
+// object with an index or a primitive with either, or if an array index is out
+// of range.
+func NewJSONParser(v interface{}) PathParser {
+	return internal.NewJSONParser(v)
+}
+
+// NewRawJSONParser returns a PathParser for the JSON document in data that
+// doesn't decode the whole document. Each lookup scans straight to the value at
+// the path, skipping everything else, and only decodes that value. Offsets of
+// paths that have been seen are cached, so create a parser for each document
+// that is evaluated. A malformed document behaves as if paths through the
+// malformed part don't exist, and for duplicate object keys the first is used.
+func NewRawJSONParser(data []byte) PathParser {
+	return internal.NewRawJSONParser(data)
+}
+
+// NewStructParser returns a PathParser for v, a Go value made of structs,
+// maps, slices, arrays, pointers and primitives. Object keys select exported
+// struct fields by their json tag name, or their Go name if they don't have
+// one, and fields of embedded structs are promoted like they are by
+// encoding/json. Maps with string keys can be indexed by key. The fields of
+// each struct type are worked out once and cached.
+func NewStructParser(v interface{}) PathParser {
+	return internal.NewStructParser(v)
+}
+
+// LimitDepth returns a PathParser that looks up values in data, where
+// Descendants path segments descend at most depth levels below the value
+// they're applied to, to bound the work done on deeply nested input. Deeper
+// values are skipped.
+func LimitDepth(data PathParser, depth int) PathParser {
+	return internal.LimitDepth(data, depth)
+}
+
+// ParseJSTN parses a JSTN type, i.e.
+//
+//	{name: string; age: number?; tags: [string]}
+//
+// for use as a schema with WithSchema and NewJSTNDocument. Any error it
+// returns is an *Error.
+func ParseJSTN(src string) (*Type, error) {
+	return internal.ParseJSTN(src)
+}
+
+// NewJSTNDocument returns a PathParser for the JSON document data, which has
+// type schema. Values are only found for paths that are declared in the schema
+// and that match their declared type.
+func NewJSTNDocument(schema *Type, data []byte) (PathParser, error) {
+	return internal.NewJSTNDocument(schema, data)
+}
+
+// Check parses src and type checks it against schema, returning every error
+// it finds. It's useful for showing all the problems in an expression at once,
+// while Compile only returns the first.
+func Check(src string, schema *Type) []*Error {
+	expr, err := parse(src)
+	if err != nil {
+		return []*Error{err.(*Error)}
+	}
+	return check(src, expr, schema)
+}
+
+func parse(src string) (internal.Expression, error) {
+	tokens, err := internal.Lex(src)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := internal.Parse(tokens)
+	if err != nil {
+		err.(*Error).Source = src
+		return nil, err
+	}
+	return expr, nil
+}
+
+func check(src string, expr internal.Expression, schema *Type) []*Error {
+	errs := internal.Check(expr, schema)
+	for _, err := range errs {
+		err.Source = src
+	}
+	return errs
+}
+
+// MustCompile is like Compile but panics if the expression can't be compiled.
+func MustCompile(src string, opts ...Option) *Program {
+	p, err := Compile(src, opts...)
+	if err != nil {
+		panic(fmt.Sprintf("expression: Compile(%q): %s", src, err))
+	}
+	return p
+}
+
+// Kind returns the kind of value the program evaluates to.
+func (p *Program) Kind() Kind {
+	return internal.KindOf(p.expr)
+}
+
+// String returns the program's expression as source, after it has been
+// reduced unless the NoReduce or Decimal option was given. The source compiles
+// to an equivalent program, with parentheses only where precedence needs them.
+func (p *Program) String() string {
+	return internal.Print(p.expr)
+}
+
+// MarshalJSON encodes the program's expression in a versioned JSON form that
+// CompileJSON and UnmarshalJSON decode, so programs can be stored or sent to
+// other clients without being parsed again. Every node is an object with a
+// "kind" tag, paths are arrays of keys and indexes with their type, and
+// literals keep their type, i.e.
+//
+//	{"version": 1, "expr": {"kind": "greaterThan",
+//		"left": {"kind": "path", "type": "number", "path": ["items", 0, "price"]},
+//		"right": {"kind": "number", "value": 100}}}
+//
+// See internal/marshal.go for every node kind. Options like Strict and
+// Decimal aren't part of the encoding.
+func (p *Program) MarshalJSON() ([]byte, error) {
+	return internal.MarshalJSON(p.expr)
+}
+
+// UnmarshalJSON decodes a program encoded by MarshalJSON, without any options.
+// Use CompileJSON to decode with options.
+func (p *Program) UnmarshalJSON(data []byte) error {
+	expr, err := internal.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	*p = Program{expr: expr}
+	return nil
+}
+
+// Eval evaluates the program against data. It can only return an error if the
+// program is Strict.
+func (p *Program) Eval(data PathParser) (interface{}, error) {
+	if p.decimal != nil {
+		data = internal.WithDecimal(data, *p.decimal)
+	}
+	if p.strict {
+		return internal.EvalStrict(p.expr, data)
+	}
+	return p.expr.Value(data), nil
+}
+
+// EvalNumber evaluates a number program against data. A program that is a bare
+// path evaluates to 0 if the path is null, or isn't found unless it's Strict.
+func (p *Program) EvalNumber(data PathParser) (float64, error) {
+	value, err := p.eval(data, NumberKind)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	return value.(float64), nil
+}
+
+// EvalDecimal is like EvalNumber, but returns the exact result of a Decimal
+// program instead of the float64 closest to it, or nil if it isn't a finite
+// number. The result of a program that isn't Decimal, or is a bare path, is
+// the shortest decimal that rounds to its float64 value.
+func (p *Program) EvalDecimal(data PathParser) (*big.Rat, error) {
+	if p.decimal == nil || p.Kind() != NumberKind {
+		n, err := p.EvalNumber(data)
+		if err != nil {
+			return nil, err
+		}
+		return internal.DecimalOf(n), nil
+	}
+	data = internal.WithDecimal(data, *p.decimal)
+	if p.strict {
+		return internal.EvalDecimalStrict(p.expr, data)
+	}
+	return internal.DecimalValue(p.expr, data), nil
+}
+
+// EvalBool evaluates a boolean program against data. A program that is a bare
+// path evaluates to false if the path is null, or isn't found unless it's
+// Strict.
+func (p *Program) EvalBool(data PathParser) (bool, error) {
+	value, err := p.eval(data, BooleanKind)
+	if err != nil || value == nil {
+		return false, err
+	}
+	return value.(bool), nil
+}
+
+// EvalString evaluates a string program against data. A program that is a bare
+// path evaluates to the empty string if the path is null, or isn't found
+// unless it's Strict.
+func (p *Program) EvalString(data PathParser) (string, error) {
+	value, err := p.eval(data, StringKind)
+	if err != nil || value == nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+// eval evaluates the program, checking that it produces a value of kind want.
+// It returns a nil value if the program is a bare path that is null or isn't
+// found.
+func (p *Program) eval(data PathParser, want Kind) (interface{}, error) {
+	if kind := p.Kind(); kind != AnyKind && kind != want {
+		return nil, fmt.Errorf("expression is a %s expression, not %s", kind, want)
+	}
+	value, err := p.Eval(data)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	if kindOfValue(value) != want {
+		return nil, fmt.Errorf("expression evaluated to %T, not %s", value, want)
+	}
+	return value, nil
+}
+
+func kindOfValue(value interface{}) Kind {
+	switch value.(type) {
+	case float64:
+		return NumberKind
+	case bool:
+		return BooleanKind
+	case string:
+		return StringKind
+	case []interface{}:
+		return ArrayKind
+	}
+	return AnyKind
+}
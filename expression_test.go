@@ -0,0 +1,431 @@
+package expression_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/yoyowazzap/expression"
+)
+
+func Test_Compile(t *testing.T) {
+	data := expression.NewJSONParser(map[string]interface{}{"num": float64(3), "name": "dan", "yes": true})
+
+	tests := []struct {
+		name       string
+		expression string
+		kind       expression.Kind
+		errMsg     string
+	}{
+		{name: "number", expression: "$.num * 2", kind: expression.NumberKind},
+		{name: "boolean", expression: "$.num > 2", kind: expression.BooleanKind},
+		{name: "string", expression: "$.missing ? 'x'", kind: expression.StringKind},
+		{name: "bare path", expression: "$.name", kind: expression.AnyKind},
+		{name: "lex error", expression: "1 & 2", errMsg: "unexpected token ' ' after '&'"},
+		{name: "parse error", expression: "1 +", errMsg: "unexpected end of input"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := expression.Compile(test.expression)
+			if test.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+					t.Fatalf("expected error containing %s, got %v", test.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			if p.Kind() != test.kind {
+				t.Errorf("got kind %s, want %s", p.Kind(), test.kind)
+			}
+			if _, err := p.Eval(data); err != nil {
+				t.Errorf("got unexpected eval error: %s", err)
+			}
+		})
+	}
+}
+
+func Test_Program_EvalTyped(t *testing.T) {
+	data := expression.NewJSONParser(map[string]interface{}{"num": float64(3), "name": "dan", "yes": true})
+
+	n, err := expression.MustCompile("sum($.num, 2) / 2").EvalNumber(data)
+	if err != nil || n != 2.5 {
+		t.Errorf("EvalNumber got %v, %v", n, err)
+	}
+
+	b, err := expression.MustCompile("$.yes && ($.num < 4)").EvalBool(data)
+	if err != nil || !b {
+		t.Errorf("EvalBool got %v, %v", b, err)
+	}
+
+	s, err := expression.MustCompile("$.name").EvalString(data)
+	if err != nil || s != "dan" {
+		t.Errorf("EvalString got %v, %v", s, err)
+	}
+
+	s, err = expression.MustCompile("$.missing").EvalString(data)
+	if err != nil || s != "" {
+		t.Errorf("EvalString of missing path got %v, %v", s, err)
+	}
+
+	if _, err := expression.MustCompile("$.num + 1").EvalBool(data); err == nil ||
+		err.Error() != "expression is a number expression, not boolean" {
+		t.Errorf("EvalBool of number expression got error %v", err)
+	}
+
+	if _, err := expression.MustCompile("$.name").EvalNumber(data); err == nil ||
+		err.Error() != "expression evaluated to string, not number" {
+		t.Errorf("EvalNumber of string path got error %v", err)
+	}
+}
+
+func Test_Compile_Error(t *testing.T) {
+	_, err := expression.Compile("($.a > 1) && ($.b + 'c')")
+	e, ok := err.(*expression.Error)
+	if !ok {
+		t.Fatalf("expected *expression.Error, got %v", err)
+	}
+	if e.Error() != "1:21: expected number expression, got string" {
+		t.Errorf("got error %q", e.Error())
+	}
+	want := "($.a > 1) && ($.b + 'c')\n                    ^^^"
+	if e.Snippet() != want {
+		t.Errorf("got snippet\n%s\nwant\n%s", e.Snippet(), want)
+	}
+}
+
+func Test_Match(t *testing.T) {
+	p := expression.MustCompile(`$.email =~ '^[^@]+@corp\\.com$' && matches($.sku, 'AB-[0-9]{4}')`)
+	data := expression.NewJSONParser(map[string]interface{}{"email": "dan@corp.com", "sku": "AB-1234"})
+	if b, err := p.EvalBool(data); err != nil || !b {
+		t.Errorf("got %v, %v", b, err)
+	}
+	data = expression.NewJSONParser(map[string]interface{}{"email": "dan@corpxcom", "sku": "AB-1234"})
+	if b, err := p.EvalBool(data); err != nil || b {
+		t.Errorf("escaped dot matched any character, got %v, %v", b, err)
+	}
+
+	_, err := expression.Compile("$.sku =~ 'AB-[0-9'")
+	if err == nil || err.Error() != "1:10: invalid pattern: error parsing regexp: missing closing ]: `[0-9`" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func Test_Compile_WithSchema(t *testing.T) {
+	schema := &expression.Type{Kind: expression.ObjectKind, Fields: map[string]*expression.Type{
+		"name": {Kind: expression.StringKind},
+		"age":  {Kind: expression.NumberKind},
+	}}
+
+	if _, err := expression.Compile("($.age > 18) || ($.name == 'dan')", expression.WithSchema(schema)); err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	_, err := expression.Compile("$.name + 1", expression.WithSchema(schema))
+	if err == nil || err.Error() != "1:1: $.name is string, expected number" {
+		t.Errorf("got error %v", err)
+	}
+
+	errs := expression.Check("sum($.name, $.nope)", schema)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if want := "sum($.name, $.nope)\n            ^^^^^^"; errs[1].Snippet() != want {
+		t.Errorf("got snippet\n%s\nwant\n%s", errs[1].Snippet(), want)
+	}
+}
+
+func Test_JSTN(t *testing.T) {
+	schema, err := expression.ParseJSTN("{order: {total: number; items: [{sku: string}]}; coupon: string?}")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	doc, err := expression.NewJSTNDocument(schema, []byte(`{"order": {"total": 120, "items": [{"sku": "a1"}]}}`))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	p, err := expression.Compile(
+		"(($.order.total > 100) && ($.order.items[0].sku == 'a1')) && (($.coupon ? '') == '')",
+		expression.WithSchema(schema),
+	)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if b, err := p.EvalBool(doc); err != nil || !b {
+		t.Errorf("EvalBool got %v, %v", b, err)
+	}
+
+	if _, err := expression.Compile("$.order.items[0].sku > 1", expression.WithSchema(schema)); err == nil {
+		t.Errorf("expected type error")
+	}
+}
+
+func Test_ArrayFunctions(t *testing.T) {
+	schema, err := expression.ParseJSTN(`{"items": [{"price": number; "tags": [string]}]}`)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	p, err := expression.Compile(
+		"any($.items, @.price > 10) && count($.items, @item => any(@item.tags, @ == 'sale')) == 1",
+		expression.WithSchema(schema),
+	)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	data := expression.NewRawJSONParser([]byte(`{"items": [{"price": 5, "tags": ["sale"]}, {"price": 20, "tags": []}]}`))
+	if b, err := p.EvalBool(data); err != nil || !b {
+		t.Errorf("EvalBool got %v, %v", b, err)
+	}
+
+	if _, err := expression.Compile("any($.items, @.tags == 'x')", expression.WithSchema(schema)); err == nil {
+		t.Errorf("expected type error")
+	}
+}
+
+func Test_Aggregates(t *testing.T) {
+	data := expression.NewRawJSONParser([]byte(`{"lineItems": [{"amount": 5}, {"amount": 15}], "scores": []}`))
+	p := expression.MustCompile("sum(map($.lineItems, @.amount)) + max(1, 2) + avg([1, 2, 3])")
+	if n, err := p.EvalNumber(data); err != nil || n != 24 {
+		t.Errorf("EvalNumber got %v, %v", n, err)
+	}
+
+	p = expression.MustCompile("min($.scores)", expression.Strict())
+	if _, err := p.EvalNumber(data); !errors.Is(err, expression.ErrEmptyArray) {
+		t.Errorf("got error %v, want %v", err, expression.ErrEmptyArray)
+	}
+}
+
+func Test_PathSegments(t *testing.T) {
+	schema, err := expression.ParseJSTN(`{"lineItems": [{"amount": number}]}`)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	p, err := expression.Compile("sum($.lineItems[*].amount) - $.lineItems[-1].amount + length($.lineItems[1:])", expression.WithSchema(schema))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	for _, data := range []expression.PathParser{
+		expression.NewRawJSONParser([]byte(`{"lineItems": [{"amount": 5}, {"amount": 15}, {"amount": 1}]}`)),
+		expression.NewStructParser(map[string]interface{}{"lineItems": []map[string]int{{"amount": 5}, {"amount": 15}, {"amount": 1}}}),
+	} {
+		if n, err := p.EvalNumber(data); err != nil || n != 22 {
+			t.Errorf("EvalNumber got %v, %v", n, err)
+		}
+	}
+	path := expression.Path{"a", expression.Wildcard{}, expression.Slice{Start: -2}, -1}
+	if got, want := path.String(), "$.a[*][-2:][-1]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func Test_FilterPaths(t *testing.T) {
+	data := expression.NewRawJSONParser([]byte(`{"answers": [{"questionId": "q1", "value": "no"}, {"questionId": "q7", "value": "yes"}]}`))
+	p := expression.MustCompile("$.answers[?(@.questionId == 'q7')].value == 'yes'", expression.Strict())
+	if b, err := p.EvalBool(data); err != nil || !b {
+		t.Errorf("EvalBool got %v, %v", b, err)
+	}
+
+	p = expression.MustCompile("$.answers[?(@.questionId == 'q9')].value == 'yes'", expression.Strict())
+	_, err := p.EvalBool(data)
+	var missing *expression.MissingPathError
+	if !errors.As(err, &missing) || missing.Path.String() != "$.answers[?(@.questionId == 'q9')].value" {
+		t.Errorf("got error %v, want missing path", err)
+	}
+}
+
+func Test_RecursiveDescent(t *testing.T) {
+	doc := []byte(`{"sections": [{"questionId": "q2", "questions": [{"questionId": "q1"}]}, {"questionId": "q3"}], "questionId": "q0"}`)
+	p := expression.MustCompile("join($..questionId, ',')", expression.Strict())
+	if s, err := p.EvalString(expression.NewRawJSONParser(doc)); err != nil || s != "q0,q2,q1,q3" {
+		t.Errorf("EvalString got %v, %v", s, err)
+	}
+	if s, err := p.EvalString(expression.LimitDepth(expression.NewRawJSONParser(doc), 2)); err != nil || s != "q0,q2,q3" {
+		t.Errorf("EvalString with LimitDepth got %v, %v", s, err)
+	}
+
+	p = expression.MustCompile("length($.nope..questionId)", expression.Strict())
+	_, err := p.EvalNumber(expression.NewRawJSONParser(doc))
+	var missing *expression.MissingPathError
+	if !errors.As(err, &missing) || missing.Path.String() != "$.nope..questionId" {
+		t.Errorf("got error %v, want missing path", err)
+	}
+}
+
+func Test_Null(t *testing.T) {
+	doc := []byte(`{"a": null, "b": 0}`)
+	var decoded interface{}
+	if err := json.Unmarshal(doc, &decoded); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	schema, err := expression.ParseJSTN("{a: number?; b: number; c: number?}")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	jstn, err := expression.NewJSTNDocument(schema, doc)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	type data struct {
+		A *int `json:"a"`
+		B int  `json:"b"`
+	}
+	parsers := map[string]expression.PathParser{
+		"json":   expression.NewJSONParser(decoded),
+		"raw":    expression.NewRawJSONParser(doc),
+		"struct": expression.NewStructParser(data{}),
+		"jstn":   jstn,
+	}
+
+	p := expression.MustCompile("$.a == null && $.b != null && ($.c ? null) == null && !(($.b ? null) == null) && $.b == 0")
+	for name, pp := range parsers {
+		t.Run(name, func(t *testing.T) {
+			if value, ok := pp.GetValue(expression.Path{"a"}); value != nil || !ok {
+				t.Errorf("GetValue of null got %v, %v", value, ok)
+			}
+			if value, ok := pp.GetValue(expression.Path{"c"}); value != nil || ok {
+				t.Errorf("GetValue of missing got %v, %v", value, ok)
+			}
+			if b, err := p.EvalBool(pp); err != nil || !b {
+				t.Errorf("EvalBool got %v, %v", b, err)
+			}
+		})
+	}
+
+	strict := expression.MustCompile("$.a + 1", expression.Strict())
+	_, err = strict.EvalNumber(parsers["json"])
+	var null *expression.NullValueError
+	if !errors.As(err, &null) || null.Path.String() != "$.a" || err.Error() != "$.a is null, not number" {
+		t.Errorf("got error %v, want null value", err)
+	}
+}
+
+func Test_Strict(t *testing.T) {
+	data := expression.NewJSONParser(map[string]interface{}{"num": float64(3), "name": "dan"})
+
+	lenient := expression.MustCompile("$.missing + 1")
+	if n, err := lenient.EvalNumber(data); err != nil || n != 1 {
+		t.Errorf("EvalNumber got %v, %v", n, err)
+	}
+
+	p := expression.MustCompile("$.missing + 1", expression.Strict())
+	_, err := p.EvalNumber(data)
+	var missing *expression.MissingPathError
+	if !errors.As(err, &missing) || missing.Path.String() != "$.missing" {
+		t.Errorf("got error %v, want missing path", err)
+	}
+
+	p = expression.MustCompile("$.name * 2", expression.Strict())
+	_, err = p.EvalNumber(data)
+	var mismatch *expression.TypeMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Want != expression.NumberKind {
+		t.Errorf("got error %v, want type mismatch", err)
+	}
+
+	p = expression.MustCompile("$.num / 0", expression.Strict())
+	if _, err := p.Eval(data); !errors.Is(err, expression.ErrDivisionByZero) {
+		t.Errorf("got error %v, want division by zero", err)
+	}
+
+	p = expression.MustCompile("sqrt($.num - 5)", expression.Strict())
+	if _, err := p.Eval(data); !errors.Is(err, expression.ErrDomain) {
+		t.Errorf("got error %v, want domain error", err)
+	}
+
+	p = expression.MustCompile("($.nope ? 2) / $.num", expression.Strict())
+	if n, err := p.EvalNumber(data); err != nil || n != float64(2)/3 {
+		t.Errorf("EvalNumber got %v, %v", n, err)
+	}
+}
+
+func Test_Decimal(t *testing.T) {
+	data := expression.NewJSONParser(map[string]interface{}{"price": float64(0.1), "fee": float64(0.2), "zero": float64(0)})
+	src := "$.price + $.fee == 0.3"
+
+	if ok, err := expression.MustCompile(src).EvalBool(data); err != nil || ok {
+		t.Errorf("EvalBool got %v, %v", ok, err)
+	}
+	if ok, err := expression.MustCompile(src, expression.Decimal(2, big.ToNearestEven)).EvalBool(data); err != nil || !ok {
+		t.Errorf("decimal EvalBool got %v, %v", ok, err)
+	}
+
+	p := expression.MustCompile("($.price + $.fee) / 8", expression.Decimal(3, big.ToNearestEven))
+	if got := p.String(); got != "($.price + $.fee) / 8" {
+		t.Errorf("String got %s", got)
+	}
+	if r, err := p.EvalDecimal(data); err != nil || r.RatString() != "19/500" {
+		t.Errorf("EvalDecimal got %v, %v", r, err)
+	}
+	if n, err := p.EvalNumber(data); err != nil || n != 0.038 {
+		t.Errorf("EvalNumber got %v, %v", n, err)
+	}
+
+	p = expression.MustCompile("($.price + $.fee) / 8", expression.Decimal(3, big.ToZero))
+	if r, err := p.EvalDecimal(data); err != nil || r.RatString() != "37/1000" {
+		t.Errorf("EvalDecimal rounding toward zero got %v, %v", r, err)
+	}
+
+	p = expression.MustCompile("$.price + $.fee")
+	if r, err := p.EvalDecimal(data); err != nil || r.FloatString(17) != "0.30000000000000004" {
+		t.Errorf("float EvalDecimal got %v, %v", r, err)
+	}
+
+	p = expression.MustCompile("$.price / $.zero", expression.Decimal(2, big.ToNearestEven), expression.Strict())
+	if _, err := p.EvalDecimal(data); !errors.Is(err, expression.ErrDivisionByZero) {
+		t.Errorf("got error %v, want division by zero", err)
+	}
+}
+
+func Test_Program_String(t *testing.T) {
+	p := expression.MustCompile("(($.price * (2 + 3)) > 100) && !($.tags ? false)")
+	if got, want := p.String(), "$.price * 5 > 100 && !$.tags ? false"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	p = expression.MustCompile("(1 + 2) * $.n", expression.NoReduce())
+	if got, want := p.String(), "(1 + 2) * $.n"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// a constant that overflows isn't folded, so the source means the same
+	data := expression.NewJSONParser(map[string]interface{}{"n": float64(1)})
+	p = expression.MustCompile("$.n + 10 ** 400", expression.Strict())
+	recompiled := expression.MustCompile(p.String(), expression.Strict())
+	want, wantErr := p.Eval(data)
+	if got, err := recompiled.Eval(data); got != want || err != wantErr {
+		t.Errorf("%s evaluated to %v, %v, want %v, %v", p, got, err, want, wantErr)
+	}
+}
+
+func Test_Program_JSON(t *testing.T) {
+	type rule struct {
+		Name    string              `json:"name"`
+		Program *expression.Program `json:"program"`
+	}
+	data, err := json.Marshal(rule{Name: "big order", Program: expression.MustCompile("$.total * (1 + 0.2) > 100")})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	var decoded rule
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if got, want := decoded.Program.String(), "$.total * 1.2 > 100"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if b, err := decoded.Program.EvalBool(expression.NewJSONParser(map[string]interface{}{"total": float64(90)})); err != nil || !b {
+		t.Errorf("EvalBool got %v, %v", b, err)
+	}
+
+	program, _ := json.Marshal(decoded.Program)
+	schema := &expression.Type{Kind: expression.ObjectKind, Fields: map[string]*expression.Type{
+		"total": {Kind: expression.StringKind},
+	}}
+	_, err = expression.CompileJSON(program, expression.WithSchema(schema))
+	if err == nil || err.Error() != "$.total is string, expected number" {
+		t.Errorf("got error %v", err)
+	}
+}
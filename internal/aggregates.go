@@ -0,0 +1,192 @@
+package internal
+
+import "math"
+
+type (
+	// aggregateExpression is the minimum, maximum or average of its
+	// arguments, as fn is MIN_WORD, MAX_WORD or AVG_WORD.
+	aggregateExpression struct {
+		node
+		fn             TokenType
+		subExpressions []NumberExpression
+	}
+
+	// arrayAggregateExpression applies fn, an aggregate function or
+	// COUNT_WORD, to the elements of ae.
+	arrayAggregateExpression struct {
+		node
+		fn TokenType
+		ae ArrayExpression
+	}
+
+	// pathAggregateExpression applies fn to the value at an untyped path,
+	// which is only known to be an array or a number once it's evaluated.
+	pathAggregateExpression struct {
+		node
+		fn       TokenType
+		path     []interface{}
+		pathSpan Span
+	}
+)
+
+func (e *aggregateExpression) Value(pp PathParser) float64 {
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, e)
+	}
+	numbers := make([]float64, len(e.subExpressions))
+	for i, subExpression := range e.subExpressions {
+		numbers[i] = subExpression.Value(pp)
+	}
+	return aggregate(pp, e.fn, numbers)
+}
+
+// Reduce doesn't fold an aggregate that isn't finite.
+func (e *aggregateExpression) Reduce() NumberExpression {
+	args := make([]interface{}, len(e.subExpressions))
+	for i, subExpression := range e.subExpressions {
+		e.subExpressions[i] = subExpression.Reduce()
+		args[i] = e.subExpressions[i]
+	}
+	if !allConstant(args...) {
+		return e
+	}
+	if n := e.Value(nil); isFinite(n) {
+		return &number{node: e.node, n: n}
+	}
+	return e
+}
+
+// Value skips elements that aren't numbers, which fail strict evaluation,
+// except when counting them.
+func (e *arrayAggregateExpression) Value(pp PathParser) float64 {
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, e)
+	}
+	values := e.ae.Value(pp)
+	if e.fn == COUNT_WORD {
+		return float64(len(values))
+	}
+	var path Path
+	if ap, ok := e.ae.(*arrayPath); ok {
+		path = ap.path
+	}
+	return aggregate(pp, e.fn, numbersOf(pp, values, path, e.ae.Span()))
+}
+
+// Reduce doesn't fold the minimum, maximum or average of an empty array, so
+// that it still fails strict evaluation, or an aggregate that isn't finite.
+func (e *arrayAggregateExpression) Reduce() NumberExpression {
+	e.ae = e.ae.Reduce()
+	values, ok := constant(e.ae)
+	if !ok || len(values.([]interface{})) == 0 && !emptyAggregate(e.fn) {
+		return e
+	}
+	if n := e.Value(nil); isFinite(n) {
+		return &number{node: e.node, n: n}
+	}
+	return e
+}
+
+// Value aggregates the numbers in an array, or a single number. A path that
+// isn't found is aggregated as 0, like a number path.
+func (e *pathAggregateExpression) Value(pp PathParser) float64 {
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, e)
+	}
+	if a, ok := pp.GetArray(e.path); ok {
+		return aggregate(pp, e.fn, numbersOf(pp, a, e.path, e.pathSpan))
+	}
+	n, ok := pp.GetNumber(e.path)
+	if !ok {
+		pathNotFound(pp, e.path, NumberKind, e.pathSpan)
+	}
+	return aggregate(pp, e.fn, []float64{n})
+}
+
+func (e *pathAggregateExpression) Reduce() NumberExpression {
+	return e
+}
+
+// numbersOf returns the numbers in values, the elements of an array at path,
+// or at no path if path is nil. Other elements are skipped, or fail strict
+// evaluation with the path of the element if it has a single one.
+func numbersOf(pp PathParser, values []interface{}, path Path, span Span) []float64 {
+	numbers := make([]float64, 0, len(values))
+	for i, value := range values {
+		n, ok := toFloat(value)
+		if !ok && value == nil {
+			// nulls are skipped, even in strict mode
+			continue
+		}
+		if !ok {
+			notNumber(pp, path, i, span)
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// notNumber fails strict evaluation because element i of an array at path, or
+// at no path if path is nil, isn't a number. The error has the path of the
+// element, which for a path that fans out is the path of the value it selected.
+func notNumber(pp PathParser, path Path, i int, span Span) {
+	if !strict(pp) {
+		return
+	}
+	switch {
+	case path == nil:
+		fail(pp, errorf(span, "array element %d is not number", i))
+	case !path.fansOut():
+		fail(pp, &TypeMismatchError{Path: append(path[:len(path):len(path)], i), Want: NumberKind, Span: span})
+	default:
+		paths, _, _ := expand(pp, path)
+		if i >= len(paths) {
+			fail(pp, errorf(span, "array element %d is not number", i))
+			return
+		}
+		fail(pp, &TypeMismatchError{Path: paths[i], Want: NumberKind, Span: span})
+	}
+}
+
+// emptyAggregate reports whether fn has a value for no numbers. The minimum,
+// maximum and average of no numbers are NaN, and fail strict evaluation with
+// ErrEmptyArray.
+func emptyAggregate(fn TokenType) bool {
+	return fn == SUM_WORD || fn == PRODUCT_WORD || fn == COUNT_WORD
+}
+
+// aggregate applies fn to numbers.
+func aggregate(pp PathParser, fn TokenType, numbers []float64) float64 {
+	if len(numbers) == 0 && !emptyAggregate(fn) {
+		fail(pp, ErrEmptyArray)
+		return math.NaN()
+	}
+	var result float64
+	switch fn {
+	case SUM_WORD, AVG_WORD:
+		for _, n := range numbers {
+			result += n
+		}
+		if fn == AVG_WORD {
+			result /= float64(len(numbers))
+		}
+	case PRODUCT_WORD:
+		result = 1
+		for _, n := range numbers {
+			result *= n
+		}
+	case MIN_WORD, MAX_WORD:
+		result = numbers[0]
+		for _, n := range numbers[1:] {
+			if fn == MIN_WORD {
+				result = math.Min(result, n)
+			} else {
+				result = math.Max(result, n)
+			}
+		}
+	case COUNT_WORD:
+		result = float64(len(numbers))
+	}
+	return checkNaN(pp, result)
+}
@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"encoding/json"
+	"math/big"
+)
+
+type (
+	// anyExpression reports whether pred is true for any element of ae. Like
+	// the other array functions, it evaluates pred with each element in
+	// scope, named param if pred is the body of a lambda.
+	anyExpression struct {
+		node
+		ae    ArrayExpression
+		param string
+		pred  BooleanExpression
+	}
+
+	allExpression struct {
+		node
+		ae    ArrayExpression
+		param string
+		pred  BooleanExpression
+	}
+
+	noneExpression struct {
+		node
+		ae    ArrayExpression
+		param string
+		pred  BooleanExpression
+	}
+
+	countExpression struct {
+		node
+		ae    ArrayExpression
+		param string
+		pred  BooleanExpression
+	}
+
+	filterExpression struct {
+		node
+		ae    ArrayExpression
+		param string
+		pred  BooleanExpression
+	}
+
+	// mapExpression is the array of the values of body for each element of
+	// ae.
+	mapExpression struct {
+		node
+		ae    ArrayExpression
+		param string
+		body  Expression
+	}
+
+	// lambda is the argument of an array function written as
+	// @param => body. It's only used to pass param to call.
+	lambda struct {
+		node
+		param string
+		body  Expression
+	}
+
+	// scope is a PathParser for evaluating an expression against an element
+	// of an array. Paths relative to the element are resolved against it,
+	// and any other path is passed on to parent, which may be the scope of an
+	// enclosing array function.
+	scope struct {
+		parent PathParser
+		param  string
+		// atPath is set if the array is at a path, in which case elem is the
+		// path of the element in parent. Otherwise it's the element's value.
+		atPath bool
+		elem   interface{}
+	}
+
+	// strictScope is a scope under strict evaluation, which passes failures
+	// on to parent.
+	strictScope struct {
+		*scope
+	}
+)
+
+func (e *anyExpression) Value(pp PathParser) bool {
+	found := false
+	forEach(pp, e.ae, e.param, func(_ interface{}, elem PathParser) bool {
+		found = e.pred.Value(elem)
+		return !found
+	})
+	return found
+}
+
+func (e *anyExpression) Reduce() BooleanExpression {
+	e.ae = e.ae.Reduce()
+	e.pred = e.pred.Reduce()
+	return foldBoolean(e, e.ae, e.pred)
+}
+
+func (e *allExpression) Value(pp PathParser) bool {
+	all := true
+	forEach(pp, e.ae, e.param, func(_ interface{}, elem PathParser) bool {
+		all = e.pred.Value(elem)
+		return all
+	})
+	return all
+}
+
+func (e *allExpression) Reduce() BooleanExpression {
+	e.ae = e.ae.Reduce()
+	e.pred = e.pred.Reduce()
+	return foldBoolean(e, e.ae, e.pred)
+}
+
+func (e *noneExpression) Value(pp PathParser) bool {
+	none := true
+	forEach(pp, e.ae, e.param, func(_ interface{}, elem PathParser) bool {
+		none = !e.pred.Value(elem)
+		return none
+	})
+	return none
+}
+
+func (e *noneExpression) Reduce() BooleanExpression {
+	e.ae = e.ae.Reduce()
+	e.pred = e.pred.Reduce()
+	return foldBoolean(e, e.ae, e.pred)
+}
+
+func (e *countExpression) Value(pp PathParser) float64 {
+	count := 0
+	forEach(pp, e.ae, e.param, func(_ interface{}, elem PathParser) bool {
+		if e.pred.Value(elem) {
+			count++
+		}
+		return true
+	})
+	return float64(count)
+}
+
+func (e *countExpression) Reduce() NumberExpression {
+	e.ae = e.ae.Reduce()
+	e.pred = e.pred.Reduce()
+	if allConstant(e.ae, e.pred) {
+		return &number{node: e.node, n: e.Value(nil)}
+	}
+	return e
+}
+
+func (e *filterExpression) Value(pp PathParser) []interface{} {
+	values := []interface{}{}
+	forEach(pp, e.ae, e.param, func(value interface{}, elem PathParser) bool {
+		if e.pred.Value(elem) {
+			values = append(values, value)
+		}
+		return true
+	})
+	return values
+}
+
+func (e *filterExpression) Reduce() ArrayExpression {
+	e.ae = e.ae.Reduce()
+	e.pred = e.pred.Reduce()
+	if b, ok := e.pred.(*boolean); ok && b.b {
+		return e.ae
+	}
+	return e
+}
+
+func (e *mapExpression) Value(pp PathParser) []interface{} {
+	values := []interface{}{}
+	forEach(pp, e.ae, e.param, func(_ interface{}, elem PathParser) bool {
+		values = append(values, e.body.Value(elem))
+		return true
+	})
+	return values
+}
+
+func (e *mapExpression) Reduce() ArrayExpression {
+	e.ae = e.ae.Reduce()
+	e.body = e.body.Reduce()
+	return e
+}
+
+func (l *lambda) Value(pp PathParser) interface{} {
+	return l.body.Value(pp)
+}
+
+func (l *lambda) Reduce() Expression {
+	l.body = l.body.Reduce()
+	return l
+}
+
+// forEach evaluates ae and calls fn with the value of each element and a
+// PathParser that puts it in scope, until fn returns false. The elements of
+// an array at a path are found through pp, so paths relative to them are
+// resolved by the same PathParser as the array.
+func forEach(pp PathParser, ae ArrayExpression, param string, fn func(value interface{}, elem PathParser) bool) {
+	values := ae.Value(pp)
+	var paths []Path
+	atPath := false
+	switch ae := ae.(type) {
+	case *arrayPath:
+		paths, atPath = elemPaths(pp, ae.path, len(values)), true
+	case *arrayPathWithDefault:
+		if _, atPath = pp.GetArray(ae.path); atPath {
+			paths = elemPaths(pp, ae.path, len(values))
+		}
+	}
+	// a PathParser that doesn't expand paths that fan out can't bind them
+	atPath = atPath && len(paths) == len(values)
+	_, isStrict := pp.(failer)
+	for i, value := range values {
+		s := &scope{parent: pp, param: param, atPath: atPath, elem: value}
+		if atPath {
+			s.elem = paths[i]
+		}
+		var elem PathParser = s
+		if isStrict {
+			elem = strictScope{s}
+		}
+		if !fn(value, elem) {
+			return
+		}
+	}
+}
+
+// elemPaths returns the paths of the n elements of the array at path. The
+// elements of a path that fans out are at the paths of the values it selects.
+func elemPaths(pp PathParser, path Path, n int) []Path {
+	if path.fansOut() {
+		paths, _, _ := expand(pp, path)
+		return paths
+	}
+	paths := make([]Path, n)
+	for i := range paths {
+		paths[i] = append(path[:len(path):len(path)], i)
+	}
+	return paths
+}
+
+// resolve returns the PathParser that finds the value at path, and the path
+// to look up in it. Paths that fan out are expanded by the scope before they're
+// resolved, so that the predicates of their Filters are evaluated in it.
+func (s *scope) resolve(path Path) (PathParser, Path) {
+	elem, ok := path.element()
+	if !ok || elem != "" && string(elem) != s.param {
+		return s.parent, path
+	}
+	if s.atPath {
+		elemPath := s.elem.(Path)
+		return s.parent, append(elemPath[:len(elemPath):len(elemPath)], path[1:]...)
+	}
+	return elemParser(s.elem), path[1:]
+}
+
+func (s *scope) GetValue(path Path) (interface{}, bool) {
+	if path.fansOut() {
+		return collect(s, path)
+	}
+	pp, path := s.resolve(path)
+	return pp.GetValue(path)
+}
+
+func (s *scope) GetNumber(path Path) (float64, bool) {
+	path, ok := scalarPath(s, path)
+	if !ok {
+		return 0, false
+	}
+	pp, path := s.resolve(path)
+	return pp.GetNumber(path)
+}
+
+func (s *scope) GetBoolean(path Path) (bool, bool) {
+	path, ok := scalarPath(s, path)
+	if !ok {
+		return false, false
+	}
+	pp, path := s.resolve(path)
+	return pp.GetBoolean(path)
+}
+
+func (s *scope) GetString(path Path) (string, bool) {
+	path, ok := scalarPath(s, path)
+	if !ok {
+		return "", false
+	}
+	pp, path := s.resolve(path)
+	return pp.GetString(path)
+}
+
+func (s *scope) GetArray(path Path) ([]interface{}, bool) {
+	if path.fansOut() {
+		return collect(s, path)
+	}
+	pp, path := s.resolve(path)
+	return pp.GetArray(path)
+}
+
+func (s *scope) getDecimal(path Path) (*big.Rat, bool) {
+	path, ok := scalarPath(s, path)
+	if !ok {
+		return nil, false
+	}
+	pp, path := s.resolve(path)
+	return decimalAt(pp, path)
+}
+
+func (s *scope) children(path Path) ([]interface{}, bool) {
+	pp, path := s.resolve(path)
+	return childrenOf(pp, path)
+}
+
+func (s *scope) maxDepth() int {
+	return maxDepthOf(s.parent)
+}
+
+func (s *scope) decimalMode() (DecimalMode, bool) {
+	return decimalModeOf(s.parent)
+}
+
+func (s strictScope) fail(err error) {
+	s.parent.(failer).fail(err)
+}
+
+// elemParser returns a PathParser for the value of an element of an array
+// that isn't at a path, which is either decoded JSON or a value found by a
+// PathParser from NewStructParser.
+func elemParser(v interface{}) PathParser {
+	switch v.(type) {
+	case nil, bool, float64, string, json.Number, []interface{}, map[string]interface{}:
+		return NewJSONParser(v)
+	}
+	return NewStructParser(v)
+}
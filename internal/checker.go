@@ -0,0 +1,412 @@
+package internal
+
+import "fmt"
+
+type (
+	checker struct {
+		schema *Type
+		errs   []*Error
+		// scopes are the elements of the array functions being checked,
+		// innermost last.
+		scopes []checkerScope
+	}
+
+	checkerScope struct {
+		param string
+		// elem is the type of the array's elements, or nil if it isn't known.
+		elem *Type
+	}
+)
+
+// Check type checks expr against schema, which describes the data the
+// expression will be evaluated against. It resolves the type of every path in
+// the expression and reports paths that aren't in the schema or whose type
+// doesn't match the way they're used. A nil schema allows any path.
+func Check(expr Expression, schema *Type) []*Error {
+	c := &checker{schema: schema}
+	c.check(expr)
+	return c.errs
+}
+
+func (c *checker) errorf(span Span, format string, args ...interface{}) {
+	c.errs = append(c.errs, errorf(span, format, args...))
+}
+
+// lookup returns the kind of the value at path, or false if the path isn't in
+// the schema.
+func (c *checker) lookup(span Span, path Path) (Kind, bool) {
+	t, ok := c.lookupType(span, path)
+	if !ok {
+		return AnyKind, false
+	}
+	return t.Kind, true
+}
+
+func (c *checker) lookupType(span Span, path Path) (*Type, bool) {
+	c.filters(path)
+	t, err := c.resolve(path)
+	if err != nil {
+		c.errorf(span, "%s", err)
+		return nil, false
+	}
+	return t, true
+}
+
+// resolve returns the type of the value at path, which is relative to the
+// element of an enclosing array function if it starts with an Element.
+func (c *checker) resolve(path Path) (*Type, error) {
+	elem, ok := path.element()
+	if !ok {
+		return c.schema.Lookup(path)
+	}
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if s := c.scopes[i]; elem == "" || string(elem) == s.param {
+			return s.elem.Lookup(path)
+		}
+	}
+	return nil, fmt.Errorf("%s isn't in the scope of an array function", path)
+}
+
+// filters checks the predicates of the Filter segments of path, with the
+// elements they filter in scope.
+func (c *checker) filters(path Path) {
+	for i, segment := range path {
+		f, ok := segment.(Filter)
+		if !ok {
+			continue
+		}
+		var elem *Type
+		if t, err := c.resolve(append(path[:i:i], Wildcard{})); err == nil {
+			elem = t.Elem
+		}
+		c.scopes = append(c.scopes, checkerScope{elem: elem})
+		c.check(f.pred)
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	}
+}
+
+// path checks that the value at path is of kind want. A path that only fans
+// out through Filters can be used as the first value it selects.
+func (c *checker) path(span Span, path Path, want Kind) {
+	t, ok := c.lookupType(span, path)
+	if !ok {
+		return
+	}
+	kind := t.Kind
+	if want != ArrayKind && path.fansOut() && !path.isArray() {
+		kind = AnyKind
+		if t.Elem != nil {
+			kind = t.Elem.Kind
+		}
+	}
+	if kind != AnyKind && kind != want {
+		c.errorf(span, "%s is %s, expected %s", path, kind, want)
+	}
+}
+
+func (c *checker) check(e interface{}) {
+	switch e := e.(type) {
+	case *generic:
+		switch {
+		case e.n != nil:
+			c.check(e.n)
+		case e.b != nil:
+			c.check(e.b)
+		case e.s != nil:
+			c.check(e.s)
+		case e.a != nil:
+			c.check(e.a)
+		}
+	case *genericPath:
+		c.lookup(e.span, e.path)
+	case *genericPathWithDefault:
+		kind, ok := c.lookup(e.span, e.path)
+		if gp, isPath := e.defaultValue.(*genericPath); isPath && ok && kind != AnyKind {
+			c.path(gp.span, gp.path, kind)
+		} else {
+			c.check(e.defaultValue)
+		}
+	case *number, *boolean, *str, *null:
+	case *numberPath:
+		c.path(e.span, e.path, NumberKind)
+	case *numberPathWithDefault:
+		c.path(e.span, e.path, NumberKind)
+		c.check(e.defaultValue)
+	case *inverseExpression:
+		c.check(e.subExpression)
+	case *sumExpression:
+		for _, subExpression := range e.subExpressions {
+			c.check(subExpression)
+		}
+	case *subtractExpression:
+		c.check(e.e1)
+		c.check(e.e2)
+	case *timesExpression:
+		for _, subExpression := range e.subExpressions {
+			c.check(subExpression)
+		}
+	case *divideExpression:
+		c.check(e.e1)
+		c.check(e.e2)
+	case *lengthExpression:
+		c.check(e.ae)
+	case *arithmeticExpression:
+		c.check(e.e1)
+		c.check(e.e2)
+	case *mathExpression:
+		for _, subExpression := range e.subExpressions {
+			c.check(subExpression)
+		}
+	case *aggregateExpression:
+		for _, subExpression := range e.subExpressions {
+			c.check(subExpression)
+		}
+	case *arrayAggregateExpression:
+		elem := c.elemType(e.ae)
+		if e.fn != COUNT_WORD && elem != nil && elem.Kind != AnyKind && elem.Kind != NumberKind {
+			c.errorf(e.ae.Span(), "expected array of number, got array of %s", elem.Kind)
+		}
+	case *pathAggregateExpression:
+		t, ok := c.lookupType(e.pathSpan, e.path)
+		switch {
+		case !ok || t.Kind == AnyKind || t.Kind == NumberKind:
+		case t.Kind == ArrayKind:
+			if t.Elem != nil && t.Elem.Kind != AnyKind && t.Elem.Kind != NumberKind {
+				c.errorf(e.pathSpan, "%s is array of %s, expected number", Path(e.path), t.Elem.Kind)
+			}
+		default:
+			c.errorf(e.pathSpan, "%s is %s, expected number or array", Path(e.path), t.Kind)
+		}
+	case *booleanPath:
+		c.path(e.span, e.path, BooleanKind)
+	case *booleanPathWithDefault:
+		c.path(e.span, e.path, BooleanKind)
+		c.check(e.defaultValue)
+	case *notExpression:
+		c.check(e.subExpression)
+	case *lessThanExpression:
+		c.check(e.e1)
+		c.check(e.e2)
+	case *lessThanOrEqualExpression:
+		c.check(e.e1)
+		c.check(e.e2)
+	case *greaterThanExpression:
+		c.check(e.e1)
+		c.check(e.e2)
+	case *greaterThanOrEqualExpression:
+		c.check(e.e1)
+		c.check(e.e2)
+	case *numberIfExpression:
+		c.check(e.cond)
+		c.check(e.then)
+		c.check(e.els)
+	case *booleanIfExpression:
+		c.check(e.cond)
+		c.check(e.then)
+		c.check(e.els)
+	case *strIfExpression:
+		c.check(e.cond)
+		c.check(e.then)
+		c.check(e.els)
+	case *arrayIfExpression:
+		c.check(e.cond)
+		c.check(e.then)
+		c.check(e.els)
+	case *genericIfExpression:
+		c.ifExpression(e)
+	case *anyExpression:
+		c.predicate(e.ae, e.param, e.pred)
+	case *allExpression:
+		c.predicate(e.ae, e.param, e.pred)
+	case *noneExpression:
+		c.predicate(e.ae, e.param, e.pred)
+	case *countExpression:
+		c.predicate(e.ae, e.param, e.pred)
+	case *filterExpression:
+		c.elemType(e)
+	case *mapExpression:
+		c.predicate(e.ae, e.param, e.body)
+	case *strCompareExpression:
+		c.check(e.e1)
+		c.check(e.e2)
+	case *equalExpression:
+		c.equal(e)
+	case *andExpression:
+		for _, subExpression := range e.subExpressions {
+			c.check(subExpression)
+		}
+	case *orExpression:
+		for _, subExpression := range e.subExpressions {
+			c.check(subExpression)
+		}
+	case *strPath:
+		c.path(e.span, e.path, StringKind)
+	case *strPathWithDefault:
+		c.path(e.span, e.path, StringKind)
+		c.check(e.defaultValue)
+	case *arrayPath:
+		c.path(e.span, e.path, ArrayKind)
+	case *arrayPathWithDefault:
+		c.path(e.span, e.path, ArrayKind)
+		c.check(e.defaultValue)
+	case *array:
+		for _, elem := range e.elems {
+			c.check(elem)
+		}
+	case *inExpression:
+		c.in(e)
+	case *concatExpression:
+		for _, subExpression := range e.subExpressions {
+			c.check(subExpression)
+		}
+	case *upperExpression:
+		c.check(e.se)
+	case *lowerExpression:
+		c.check(e.se)
+	case *trimExpression:
+		c.check(e.se)
+	case *substringExpression:
+		c.check(e.se)
+		c.check(e.start)
+		if e.end != nil {
+			c.check(e.end)
+		}
+	case *startsWithExpression:
+		c.check(e.se)
+		c.check(e.prefix)
+	case *endsWithExpression:
+		c.check(e.se)
+		c.check(e.suffix)
+	case *containsExpression:
+		c.check(e.se)
+		c.check(e.substr)
+	case *replaceExpression:
+		c.check(e.se)
+		c.check(e.old)
+		c.check(e.new)
+	case *splitExpression:
+		c.check(e.se)
+		c.check(e.sep)
+	case *joinExpression:
+		c.check(e.ae)
+		c.check(e.sep)
+	case *matchExpression:
+		c.check(e.se)
+		c.check(e.pattern)
+	case *strLengthExpression:
+		c.check(e.se)
+	case *pathLengthExpression:
+		kind, ok := c.lookup(e.pathSpan, e.path)
+		if ok && kind != AnyKind && kind != ArrayKind && kind != StringKind {
+			c.errorf(e.pathSpan, "%s is %s, expected array or string", Path(e.path), kind)
+		}
+	default:
+		panic(fmt.Sprintf("check: unexpected expression %T", e))
+	}
+}
+
+// predicate checks the predicate or body of an array function, with the
+// elements of ae in scope.
+func (c *checker) predicate(ae ArrayExpression, param string, pred interface{}) *Type {
+	elem := c.elemType(ae)
+	c.scopes = append(c.scopes, checkerScope{param: param, elem: elem})
+	c.check(pred)
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	return elem
+}
+
+// elemType checks ae and returns the type of its elements, or nil if it isn't
+// known.
+func (c *checker) elemType(ae ArrayExpression) *Type {
+	switch ae := ae.(type) {
+	case *arrayPath:
+		t, ok := c.lookupType(ae.span, ae.path)
+		if !ok {
+			return nil
+		}
+		if t.Kind != AnyKind && t.Kind != ArrayKind {
+			c.errorf(ae.span, "%s is %s, expected %s", Path(ae.path), t.Kind, ArrayKind)
+			return nil
+		}
+		return t.Elem
+	case *filterExpression:
+		return c.predicate(ae.ae, ae.param, ae.pred)
+	case *mapExpression:
+		gp, ok := ae.body.(*genericPath)
+		if !ok {
+			c.predicate(ae.ae, ae.param, ae.body)
+			if k := KindOf(ae.body); k != AnyKind {
+				return &Type{Kind: k}
+			}
+			return nil
+		}
+		c.scopes = append(c.scopes, checkerScope{param: ae.param, elem: c.elemType(ae.ae)})
+		t, _ := c.lookupType(gp.span, gp.path)
+		c.scopes = c.scopes[:len(c.scopes)-1]
+		return t
+	}
+	c.check(ae)
+	return nil
+}
+
+// equal checks an equality expression. When both sides are untyped paths the
+// schema decides their types, which then have to match.
+func (c *checker) equal(e *equalExpression) {
+	gp1, ok1 := e.e1.(*genericPath)
+	gp2, ok2 := e.e2.(*genericPath)
+	if !ok1 || !ok2 {
+		c.check(e.e1)
+		c.check(e.e2)
+		return
+	}
+
+	k1, ok1 := c.lookup(gp1.span, gp1.path)
+	k2, ok2 := c.lookup(gp2.span, gp2.path)
+	if ok1 && ok2 && k1 != AnyKind && k2 != AnyKind && k1 != k2 {
+		c.errorf(spanOf(e.e1, e.e2), "cannot compare %s with %s", k1, k2)
+	}
+}
+
+// ifExpression checks an untyped if expression. When both branches are paths
+// the schema decides their types, which have to match.
+func (c *checker) ifExpression(e *genericIfExpression) {
+	c.check(e.cond)
+	gp1, ok1 := e.then.(*genericPath)
+	gp2, ok2 := e.els.(*genericPath)
+	if !ok1 || !ok2 {
+		c.check(e.then)
+		c.check(e.els)
+		return
+	}
+
+	k1, ok1 := c.lookup(gp1.span, gp1.path)
+	k2, ok2 := c.lookup(gp2.span, gp2.path)
+	if ok1 && ok2 && k1 != AnyKind && k2 != AnyKind && k1 != k2 {
+		c.errorf(e.span, "if branches are %s and %s", k1, k2)
+	}
+}
+
+// in checks an 'in' expression. When the array is a path the schema decides
+// the type of its elements, which has to match the other side.
+func (c *checker) in(e *inExpression) {
+	k1 := KindOf(e.e)
+	if gp, ok := e.e.(*genericPath); ok {
+		k1, _ = c.lookup(gp.span, gp.path)
+	} else {
+		c.check(e.e)
+	}
+
+	ap, ok := e.ae.(*arrayPath)
+	if !ok {
+		c.check(e.ae)
+		return
+	}
+	c.path(ap.span, ap.path, ArrayKind)
+	t, err := c.resolve(ap.path)
+	if err != nil || t.Kind != ArrayKind || t.Elem == nil {
+		return
+	}
+	if k2 := t.Elem.Kind; k1 != AnyKind && k2 != AnyKind && k1 != k2 {
+		c.errorf(e.span, "cannot compare %s with %s", k1, k2)
+	}
+}
@@ -0,0 +1,238 @@
+package internal_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func Test_Check(t *testing.T) {
+	schema := &internal.Type{Kind: internal.ObjectKind, Fields: map[string]*internal.Type{
+		"name":  {Kind: internal.StringKind},
+		"age":   {Kind: internal.NumberKind},
+		"admin": {Kind: internal.BooleanKind},
+		"tags":  {Kind: internal.ArrayKind, Elem: &internal.Type{Kind: internal.StringKind}},
+		"extra": {Kind: internal.AnyKind},
+		"orders": {Kind: internal.ArrayKind, Elem: &internal.Type{Kind: internal.ObjectKind, Fields: map[string]*internal.Type{
+			"id":    {Kind: internal.StringKind},
+			"total": {Kind: internal.NumberKind},
+			"items": {Kind: internal.ArrayKind, Elem: &internal.Type{Kind: internal.ObjectKind, Fields: map[string]*internal.Type{
+				"sku": {Kind: internal.StringKind},
+			}}},
+		}}},
+		"address": {Kind: internal.ObjectKind, Fields: map[string]*internal.Type{
+			"zip": {Kind: internal.StringKind},
+		}},
+	}}
+
+	tests := []struct {
+		name       string
+		expression string
+		errs       []string
+	}{
+		{
+			name:       "valid",
+			expression: "((($.age ? 0) + length($.tags)) > 3) && ($.address.zip == $.tags[0])",
+		},
+		{
+			name:       "untyped schema values",
+			expression: "($.extra.a.b + 1) > $.extra[0]",
+		},
+		{
+			name:       "bare path",
+			expression: "$.address",
+		},
+		{
+			name:       "string used as number",
+			expression: "$.name + 1",
+			errs:       []string{"1:1: $.name is string, expected number"},
+		},
+		{
+			name:       "path not in schema",
+			expression: "$.admin || ($.adress.zip == '1')",
+			errs:       []string{"1:13: $.adress not found in schema"},
+		},
+		{
+			name:       "key into non object",
+			expression: "$.name.first",
+			errs:       []string{"1:1: $.name is string, not object"},
+		},
+		{
+			name:       "index into non array",
+			expression: "$.address[0] ? 1",
+			errs:       []string{"1:1: $.address is object, not array"},
+		},
+		{
+			name:       "array used as boolean",
+			expression: "!$.tags",
+			errs:       []string{"1:2: $.tags is array, expected boolean"},
+		},
+		{
+			name:       "mismatched untyped paths",
+			expression: "$.age == $.name",
+			errs:       []string{"1:1: cannot compare number with string"},
+		},
+		{
+			name:       "multiple errors",
+			expression: "sum($.name, $.admin, $.age, $.nope)",
+			errs: []string{
+				"1:5: $.name is string, expected number",
+				"1:13: $.admin is boolean, expected number",
+				"1:29: $.nope not found in schema",
+			},
+		},
+		{
+			name:       "in",
+			expression: "$.name in $.tags && $.age in [1, 2] && 'a' not in ($.tags ? ['b'])",
+		},
+		{
+			name:       "in mismatched element type",
+			expression: "$.age in $.tags",
+			errs:       []string{"1:1: cannot compare number with string"},
+		},
+		{
+			name:       "in array literal",
+			expression: "$.name in [$.age, 1]",
+			errs:       []string{"1:1: $.name is string, expected number"},
+		},
+		{
+			name:       "string functions",
+			expression: "length($.name) + length($.tags) > 1 && contains(join($.tags, ','), upper($.address.zip))",
+		},
+		{
+			name:       "if",
+			expression: "if($.admin, $.age, 0) > 1 && if($.admin, $.name, $.address.zip) != ''",
+		},
+		{
+			name:       "if condition path",
+			expression: "if($.age, 1, 2) > 1",
+			errs:       []string{"1:4: $.age is number, expected boolean"},
+		},
+		{
+			name:       "if branch paths",
+			expression: "if($.admin, $.name, $.age)",
+			errs:       []string{"1:1: if branches are string and number"},
+		},
+		{
+			name:       "if branch paths of the same type",
+			expression: "if($.admin, $.name, $.address.zip)",
+		},
+		{
+			name:       "if branch path of any type",
+			expression: "if($.admin, $.extra, $.age)",
+		},
+		{
+			name:       "array functions",
+			expression: "any($.tags, @ == $.name) && count($.orders, @o => any(@o.items, @.sku == @o.id)) > 1",
+		},
+		{
+			name:       "array function element types",
+			expression: "any($.tags, @ > 1) || all($.orders, @.total == 'x') || any($.name, true) || length(map($.orders, @.nope)) > 0",
+			errs: []string{
+				"1:13: @ is string, expected number",
+				"1:37: @.total is number, expected string",
+				"1:60: $.name is string, expected array",
+				"1:98: @.nope not found in schema",
+			},
+		},
+		{
+			name:       "aggregates",
+			expression: "sum($.age) + max(map($.orders, @.total)) + avg($.extra) + count($.tags) > min($.age, 1)",
+		},
+		{
+			name:       "aggregate types",
+			expression: "sum($.tags) + min($.name) + avg(map($.orders, @.id)) > 1",
+			errs: []string{
+				"1:5: $.tags is array of string, expected number",
+				"1:19: $.name is string, expected number or array",
+				"1:33: expected array of number, got array of string",
+			},
+		},
+		{
+			name:       "math functions",
+			expression: "round(sqrt($.age) ** 2 % 7, 1) + clamp($.extra, 0, 1) > pow(2, $.name)",
+			errs:       []string{"1:64: $.name is string, expected number"},
+		},
+		{
+			name:       "wildcard paths",
+			expression: "sum($.orders[*].total) + length($.orders[1:].items[*].sku) > $.orders[-1].total && any($.orders[*].id, @ == $.name)",
+		},
+		{
+			name:       "wildcard path types",
+			expression: "any($.orders[*].id, @ > 1) || length($.name[*]) > 0 || length($.orders[*].nope) > 0",
+			errs: []string{
+				"1:21: @ is string, expected number",
+				"1:38: $.name is string, not array",
+				"1:63: $.orders[*].nope not found in schema",
+			},
+		},
+		{
+			name:       "filter paths",
+			expression: "$.orders[?(@.id == $.name)].total > 1 && length($.orders[?(any(@.items, @.sku == 'x'))].items) > 0",
+		},
+		{
+			name:       "filter path types",
+			expression: "$.orders[?(@.total == 'x')].id > 1 || $.orders[?(@.nope)].id == ''",
+			errs: []string{
+				"1:12: @.total is number, expected string",
+				"1:1: $.orders[?(@.total == 'x')].id is string, expected number",
+				"1:50: @.nope not found in schema",
+			},
+		},
+		{
+			name:       "null comparison",
+			expression: "$.name == null || null != ($.nope ? null)",
+			errs:       []string{"1:28: $.nope not found in schema"},
+		},
+		{
+			name:       "recursive descent paths",
+			expression: "sum($..total) + length($.orders..sku) > 1 && any($..id, @ == 'x') && length($.nope..id) > 0",
+			errs:       []string{"1:77: $.nope not found in schema"},
+		},
+		{
+			name:       "string comparison",
+			expression: "$.name >= 'a' && $.address.zip != $.name",
+		},
+		{
+			name:       "string comparison with number path",
+			expression: "$.age < 'a'",
+			errs:       []string{"1:1: $.age is number, expected string"},
+		},
+		{
+			name:       "match",
+			expression: "$.name =~ '^d' && matches($.address.zip, $.name)",
+		},
+		{
+			name:       "match number path",
+			expression: "$.age =~ '1'",
+			errs:       []string{"1:1: $.age is number, expected string"},
+		},
+		{
+			name:       "length of number",
+			expression: "length($.age)",
+			errs:       []string{"1:8: $.age is number, expected array or string"},
+		},
+		{
+			name:       "default value",
+			expression: "$.age ? $.name",
+			errs:       []string{"1:9: $.name is string, expected number"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := parse(t, test.expression)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+
+			var errs []string
+			for _, err := range internal.Check(expr, schema) {
+				errs = append(errs, err.Error())
+			}
+			if !reflect.DeepEqual(errs, test.errs) {
+				t.Errorf("got errors %q, want %q", errs, test.errs)
+			}
+		})
+	}
+}
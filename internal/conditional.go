@@ -0,0 +1,142 @@
+package internal
+
+type (
+	// numberIfExpression is then if cond is true, or else els. Only the
+	// chosen branch is evaluated, as for the other conditionals.
+	numberIfExpression struct {
+		node
+		cond BooleanExpression
+		then NumberExpression
+		els  NumberExpression
+	}
+
+	booleanIfExpression struct {
+		node
+		cond BooleanExpression
+		then BooleanExpression
+		els  BooleanExpression
+	}
+
+	strIfExpression struct {
+		node
+		cond BooleanExpression
+		then StringExpression
+		els  StringExpression
+	}
+
+	arrayIfExpression struct {
+		node
+		cond BooleanExpression
+		then ArrayExpression
+		els  ArrayExpression
+	}
+
+	// genericIfExpression is a conditional whose branches are both untyped,
+	// so it takes the type of the slot it's used in, like a path.
+	genericIfExpression struct {
+		node
+		cond BooleanExpression
+		then Expression
+		els  Expression
+	}
+)
+
+func (e *numberIfExpression) Value(pp PathParser) float64 {
+	if e.cond.Value(pp) {
+		return e.then.Value(pp)
+	}
+	return e.els.Value(pp)
+}
+
+func (e *numberIfExpression) Reduce() NumberExpression {
+	e.cond = e.cond.Reduce()
+	e.then = e.then.Reduce()
+	e.els = e.els.Reduce()
+	if b, ok := e.cond.(*boolean); ok {
+		if b.b {
+			return e.then
+		}
+		return e.els
+	}
+	return e
+}
+
+func (e *booleanIfExpression) Value(pp PathParser) bool {
+	if e.cond.Value(pp) {
+		return e.then.Value(pp)
+	}
+	return e.els.Value(pp)
+}
+
+func (e *booleanIfExpression) Reduce() BooleanExpression {
+	e.cond = e.cond.Reduce()
+	e.then = e.then.Reduce()
+	e.els = e.els.Reduce()
+	if b, ok := e.cond.(*boolean); ok {
+		if b.b {
+			return e.then
+		}
+		return e.els
+	}
+	return e
+}
+
+func (e *strIfExpression) Value(pp PathParser) string {
+	if e.cond.Value(pp) {
+		return e.then.Value(pp)
+	}
+	return e.els.Value(pp)
+}
+
+func (e *strIfExpression) Reduce() StringExpression {
+	e.cond = e.cond.Reduce()
+	e.then = e.then.Reduce()
+	e.els = e.els.Reduce()
+	if b, ok := e.cond.(*boolean); ok {
+		if b.b {
+			return e.then
+		}
+		return e.els
+	}
+	return e
+}
+
+func (e *arrayIfExpression) Value(pp PathParser) []interface{} {
+	if e.cond.Value(pp) {
+		return e.then.Value(pp)
+	}
+	return e.els.Value(pp)
+}
+
+func (e *arrayIfExpression) Reduce() ArrayExpression {
+	e.cond = e.cond.Reduce()
+	e.then = e.then.Reduce()
+	e.els = e.els.Reduce()
+	if b, ok := e.cond.(*boolean); ok {
+		if b.b {
+			return e.then
+		}
+		return e.els
+	}
+	return e
+}
+
+func (e *genericIfExpression) Value(pp PathParser) interface{} {
+	if e.cond.Value(pp) {
+		return e.then.Value(pp)
+	}
+	return e.els.Value(pp)
+}
+
+func (e *genericIfExpression) Reduce() Expression {
+	e.cond = e.cond.Reduce()
+	e.then = e.then.Reduce()
+	e.els = e.els.Reduce()
+	if b, ok := e.cond.(*boolean); ok {
+		if b.b {
+			return e.then
+		}
+		return e.els
+	}
+	return e
+}
@@ -0,0 +1,529 @@
+package internal
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// maxExactExponent is the largest integer exponent, and number of digits
+// rounded to, that decimal arithmetic computes exactly. Larger powers are
+// computed with float64s, and rounding to more digits is limited to it.
+const maxExactExponent = 1000
+
+type (
+	// DecimalMode configures decimal evaluation, where number expressions
+	// are evaluated exactly as big.Rats instead of as float64s.
+	DecimalMode struct {
+		// Scale is how many digits after the decimal point results that
+		// can't be computed exactly, like quotients, are rounded to. It
+		// rounds to a power of ten if it's negative.
+		Scale int
+		// Rounding is how those results, and the round function, round.
+		Rounding big.RoundingMode
+	}
+
+	// decimalParser wraps the PathParser an expression is evaluated against
+	// in decimal mode. It resolves paths that fan out itself, so that the
+	// predicates of their Filters are evaluated in decimal mode too.
+	decimalParser struct {
+		PathParser
+		mode DecimalMode
+	}
+
+	// decimaler is implemented by PathParsers that evaluate expressions in
+	// decimal mode, or that pass on the mode of the PathParser they wrap.
+	decimaler interface {
+		decimalMode() (DecimalMode, bool)
+	}
+
+	// decimalGetter is implemented by PathParsers that can find the exact
+	// decimal a number was written as in their document, which may have
+	// more digits than a float64 keeps, or that pass on the PathParser they
+	// wrap. The decimal is nil if the number isn't finite.
+	decimalGetter interface {
+		getDecimal(path Path) (*big.Rat, bool)
+	}
+)
+
+// WithDecimal returns a PathParser that finds values in pp, and that number
+// expressions evaluated against it evaluate exactly, rounding results that
+// can't be exact as mode says. Their Value is the float64 closest to their
+// exact value, or NaN if it isn't a finite number, so what would be an
+// infinity is NaN.
+func WithDecimal(pp PathParser, mode DecimalMode) PathParser {
+	return &decimalParser{PathParser: pp, mode: mode}
+}
+
+// DecimalValue returns the exact value of number expression e evaluated
+// against pp, which WithDecimal has put in decimal mode, or nil if it isn't a
+// finite number.
+func DecimalValue(e Expression, pp PathParser) *big.Rat {
+	m, _ := decimalModeOf(pp)
+	return m.value(pp, e.(*generic).n)
+}
+
+// EvalDecimalStrict is like DecimalValue, but returns an error like
+// EvalStrict instead of a default value, or ErrNaN if the value isn't a
+// finite number.
+func EvalDecimalStrict(e Expression, pp PathParser) (*big.Rat, error) {
+	sp := &strictParser{PathParser: pp}
+	r := DecimalValue(e, sp)
+	if sp.err != nil {
+		return nil, sp.err
+	}
+	if r == nil {
+		return nil, ErrNaN
+	}
+	return r, nil
+}
+
+// DecimalOf returns the shortest decimal that rounds to f, which is what a
+// number that decoded to f was written as if it has no more than 15
+// significant digits, or nil if f isn't finite.
+func DecimalOf(f float64) *big.Rat {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return r
+}
+
+// parseDecimal returns the exact value of s, a number as written in an
+// expression or a JSON document, or DecimalOf(f), where f is s decoded, if s
+// isn't a decimal.
+func parseDecimal(s string, f float64) *big.Rat {
+	if r, ok := new(big.Rat).SetString(s); ok && s != "" {
+		return r
+	}
+	return DecimalOf(f)
+}
+
+// decimal returns the exact value of e, or nil if it isn't a finite number.
+func (e *number) decimal() *big.Rat {
+	return parseDecimal(e.text, e.n)
+}
+
+// exactText returns the text e was written as if it has digits its float64
+// value doesn't keep, or "" if DecimalOf gives its exact value.
+func (e *number) exactText() string {
+	r := e.decimal()
+	if r == nil || r.Cmp(DecimalOf(e.n)) == 0 {
+		return ""
+	}
+	return e.text
+}
+
+// decimalAt returns the exact value of the number at path in pp, which is
+// nil if it isn't a finite number. Numbers that pp can't find the decimal of
+// are converted with DecimalOf.
+func decimalAt(pp PathParser, path Path) (*big.Rat, bool) {
+	if dg, ok := pp.(decimalGetter); ok {
+		return dg.getDecimal(path)
+	}
+	n, ok := pp.GetNumber(path)
+	if !ok {
+		return nil, false
+	}
+	return DecimalOf(n), true
+}
+
+func (p *decimalParser) GetValue(path Path) (interface{}, bool) {
+	if path.fansOut() {
+		return collect(p, path)
+	}
+	return p.PathParser.GetValue(path)
+}
+
+func (p *decimalParser) GetNumber(path Path) (float64, bool) {
+	path, ok := scalarPath(p, path)
+	if !ok {
+		return 0, false
+	}
+	return p.PathParser.GetNumber(path)
+}
+
+func (p *decimalParser) GetBoolean(path Path) (bool, bool) {
+	path, ok := scalarPath(p, path)
+	if !ok {
+		return false, false
+	}
+	return p.PathParser.GetBoolean(path)
+}
+
+func (p *decimalParser) GetString(path Path) (string, bool) {
+	path, ok := scalarPath(p, path)
+	if !ok {
+		return "", false
+	}
+	return p.PathParser.GetString(path)
+}
+
+func (p *decimalParser) GetArray(path Path) ([]interface{}, bool) {
+	if path.fansOut() {
+		return collect(p, path)
+	}
+	return p.PathParser.GetArray(path)
+}
+
+func (p *decimalParser) children(path Path) ([]interface{}, bool) {
+	return childrenOf(p.PathParser, path)
+}
+
+func (p *decimalParser) maxDepth() int {
+	return maxDepthOf(p.PathParser)
+}
+
+func (p *decimalParser) getDecimal(path Path) (*big.Rat, bool) {
+	path, ok := scalarPath(p, path)
+	if !ok {
+		return nil, false
+	}
+	return decimalAt(p.PathParser, path)
+}
+
+func (p *decimalParser) decimalMode() (DecimalMode, bool) {
+	return p.mode, true
+}
+
+// decimalModeOf returns the decimal mode pp is evaluating in, if it is.
+func decimalModeOf(pp PathParser) (DecimalMode, bool) {
+	if d, ok := pp.(decimaler); ok {
+		return d.decimalMode()
+	}
+	return DecimalMode{}, false
+}
+
+// float returns the float64 closest to the exact value of e, or NaN if it
+// isn't a finite number.
+func (m DecimalMode) float(pp PathParser, e NumberExpression) float64 {
+	r := m.value(pp, e)
+	if r == nil {
+		return checkNaN(pp, math.NaN())
+	}
+	f, _ := r.Float64()
+	return f
+}
+
+// value returns the exact value of e, or nil if it isn't a finite number.
+// Literals and paths are read as the decimal they were written as. Other
+// expressions that don't do arithmetic, like string functions, are evaluated
+// as usual and their values converted with DecimalOf.
+func (m DecimalMode) value(pp PathParser, e NumberExpression) *big.Rat {
+	switch e := e.(type) {
+	case *number:
+		return e.decimal()
+	case *numberPath:
+		r, ok := decimalAt(pp, e.path)
+		if !ok {
+			pathNotFound(pp, e.path, NumberKind, e.span)
+			return new(big.Rat)
+		}
+		return finite(pp, r)
+	case *numberPathWithDefault:
+		r, ok := decimalAt(pp, e.path)
+		if !ok {
+			return m.value(pp, e.defaultValue)
+		}
+		return finite(pp, r)
+	case *numberIfExpression:
+		if e.cond.Value(pp) {
+			return m.value(pp, e.then)
+		}
+		return m.value(pp, e.els)
+	case *inverseExpression:
+		x := m.value(pp, e.subExpression)
+		if x == nil {
+			return nil
+		}
+		return new(big.Rat).Neg(x)
+	case *sumExpression:
+		return m.aggregate(pp, SUM_WORD, m.values(pp, e.subExpressions))
+	case *timesExpression:
+		return m.aggregate(pp, PRODUCT_WORD, m.values(pp, e.subExpressions))
+	case *subtractExpression:
+		x, y := m.value(pp, e.e1), m.value(pp, e.e2)
+		if x == nil || y == nil {
+			return nil
+		}
+		return new(big.Rat).Sub(x, y)
+	case *divideExpression:
+		x, y := m.value(pp, e.e1), m.value(pp, e.e2)
+		if y != nil && y.Sign() == 0 {
+			fail(pp, ErrDivisionByZero)
+			return nil
+		}
+		if x == nil || y == nil {
+			return nil
+		}
+		return m.round(new(big.Rat).Quo(x, y))
+	case *arithmeticExpression:
+		return m.arithmetic(pp, e.op, m.value(pp, e.e1), m.value(pp, e.e2))
+	case *mathExpression:
+		return m.mathFunction(pp, e.fn, m.values(pp, e.subExpressions))
+	case *aggregateExpression:
+		return m.aggregate(pp, e.fn, m.values(pp, e.subExpressions))
+	case *arrayAggregateExpression:
+		values := e.ae.Value(pp)
+		if e.fn == COUNT_WORD {
+			return new(big.Rat).SetInt64(int64(len(values)))
+		}
+		var path Path
+		if ap, ok := e.ae.(*arrayPath); ok {
+			path = ap.path
+		}
+		return m.aggregate(pp, e.fn, decimalsOf(pp, values, path, e.ae.Span()))
+	case *pathAggregateExpression:
+		if a, ok := pp.GetArray(e.path); ok {
+			return m.aggregate(pp, e.fn, decimalsOf(pp, a, e.path, e.pathSpan))
+		}
+		r, ok := decimalAt(pp, e.path)
+		if !ok {
+			pathNotFound(pp, e.path, NumberKind, e.pathSpan)
+			r = new(big.Rat)
+		}
+		return m.aggregate(pp, e.fn, []*big.Rat{finite(pp, r)})
+	}
+	return DecimalOf(e.Value(pp))
+}
+
+// finite returns r, failing with ErrNaN if it's nil because the number it's
+// the value of isn't finite.
+func finite(pp PathParser, r *big.Rat) *big.Rat {
+	if r == nil {
+		checkNaN(pp, math.NaN())
+	}
+	return r
+}
+
+// values returns the exact values of es, any of which may be nil.
+func (m DecimalMode) values(pp PathParser, es []NumberExpression) []*big.Rat {
+	values := make([]*big.Rat, len(es))
+	for i, e := range es {
+		values[i] = m.value(pp, e)
+	}
+	return values
+}
+
+// decimalsOf is like numbersOf, but returns exact values. The elements of an
+// array at a path that doesn't fan out are read as the decimals they were
+// written as, and others converted with DecimalOf.
+func decimalsOf(pp PathParser, values []interface{}, path Path, span Span) []*big.Rat {
+	decimals := make([]*big.Rat, 0, len(values))
+	for i, value := range values {
+		n, ok := toFloat(value)
+		if !ok && value == nil {
+			continue
+		}
+		if !ok {
+			notNumber(pp, path, i, span)
+			continue
+		}
+		r := DecimalOf(n)
+		if path != nil && !path.fansOut() {
+			if exact, ok := decimalAt(pp, append(path[:len(path):len(path)], i)); ok {
+				r = exact
+			}
+		}
+		decimals = append(decimals, r)
+	}
+	return decimals
+}
+
+// round rounds r to the scale of m, or returns nil if r is nil.
+func (m DecimalMode) round(r *big.Rat) *big.Rat {
+	if r == nil {
+		return nil
+	}
+	return roundDecimal(r, m.Scale, m.Rounding)
+}
+
+// inexact returns the result of a computation done with float64s, rounded
+// to the scale of m.
+func (m DecimalMode) inexact(f float64) *big.Rat {
+	return m.round(DecimalOf(f))
+}
+
+// aggregate is like the aggregate function for exact numbers, which are nil
+// if any of them isn't finite. The average is rounded to the scale of m.
+func (m DecimalMode) aggregate(pp PathParser, fn TokenType, numbers []*big.Rat) *big.Rat {
+	if len(numbers) == 0 && !emptyAggregate(fn) {
+		fail(pp, ErrEmptyArray)
+		return nil
+	}
+	for _, n := range numbers {
+		if n == nil {
+			return nil
+		}
+	}
+	result := new(big.Rat)
+	switch fn {
+	case SUM_WORD, AVG_WORD:
+		for _, n := range numbers {
+			result.Add(result, n)
+		}
+		if fn == AVG_WORD {
+			result = m.round(result.Quo(result, new(big.Rat).SetInt64(int64(len(numbers)))))
+		}
+	case PRODUCT_WORD:
+		result.SetInt64(1)
+		for _, n := range numbers {
+			result.Mul(result, n)
+		}
+	case MIN_WORD, MAX_WORD:
+		result.Set(numbers[0])
+		for _, n := range numbers[1:] {
+			if c := n.Cmp(result); fn == MIN_WORD && c < 0 || fn == MAX_WORD && c > 0 {
+				result.Set(n)
+			}
+		}
+	case COUNT_WORD:
+		result.SetInt64(int64(len(numbers)))
+	}
+	return result
+}
+
+// arithmetic is like the arithmetic function for exact numbers, which are nil
+// if they aren't finite. Integer powers are exact, or rounded to the scale of
+// m if the exponent is negative, and other powers are computed with float64s
+// and rounded.
+func (m DecimalMode) arithmetic(pp PathParser, op TokenType, a, b *big.Rat) *big.Rat {
+	if op != POWER_OP && b != nil && b.Sign() == 0 {
+		fail(pp, ErrDivisionByZero)
+		return nil
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	switch op {
+	case MODULO_OP:
+		q := new(big.Rat).Quo(a, b)
+		q.SetInt(new(big.Int).Quo(q.Num(), q.Denom()))
+		return q.Sub(a, q.Mul(q, b))
+	case FLOOR_DIVIDE_OP:
+		q := new(big.Rat).Quo(a, b)
+		return q.SetInt(floor(q))
+	}
+	if !b.IsInt() || b.Num().CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+		af, _ := a.Float64()
+		bf, _ := b.Float64()
+		f, err := arithmetic(op, af, bf)
+		if err != nil {
+			fail(pp, err)
+			return nil
+		}
+		return m.inexact(f)
+	}
+	if a.Sign() == 0 && b.Sign() < 0 {
+		fail(pp, ErrDivisionByZero)
+		return nil
+	}
+	exp := new(big.Int).Abs(b.Num())
+	num := new(big.Int).Exp(a.Num(), exp, nil)
+	denom := new(big.Int).Exp(a.Denom(), exp, nil)
+	if b.Sign() < 0 {
+		return m.round(new(big.Rat).SetFrac(denom, num))
+	}
+	return new(big.Rat).SetFrac(num, denom)
+}
+
+// mathFunction is like the mathFunction function for exact numbers, which are
+// nil if they aren't finite. sqrt, log and exp are computed with float64s and
+// rounded to the scale of m, and round rounds as m does.
+func (m DecimalMode) mathFunction(pp PathParser, fn TokenType, args []*big.Rat) *big.Rat {
+	for _, arg := range args {
+		if arg == nil {
+			return nil
+		}
+	}
+	x := args[0]
+	switch fn {
+	case ABS_WORD:
+		return new(big.Rat).Abs(x)
+	case FLOOR_WORD:
+		return roundDecimal(x, 0, big.ToNegativeInf)
+	case CEIL_WORD:
+		return roundDecimal(x, 0, big.ToPositiveInf)
+	case ROUND_WORD:
+		digits := 0
+		if len(args) == 2 {
+			d := new(big.Int).Quo(args[1].Num(), args[1].Denom())
+			if d.CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+				d.SetInt64(int64(d.Sign() * maxExactExponent))
+			}
+			digits = int(d.Int64())
+		}
+		return roundDecimal(x, digits, m.Rounding)
+	case CLAMP_WORD:
+		lo, hi := args[1], args[2]
+		if lo.Cmp(hi) > 0 {
+			fail(pp, ErrDomain)
+			return nil
+		}
+		if x.Cmp(lo) < 0 {
+			return lo
+		}
+		if x.Cmp(hi) > 0 {
+			return hi
+		}
+		return x
+	}
+	floats := make([]float64, len(args))
+	for i, arg := range args {
+		floats[i], _ = arg.Float64()
+	}
+	f, err := mathFunction(fn, floats)
+	if err != nil {
+		fail(pp, err)
+		return nil
+	}
+	return m.inexact(f)
+}
+
+// floor returns the greatest integer that isn't greater than r.
+func floor(r *big.Rat) *big.Int {
+	// the denominator is positive, so Euclidean division rounds down
+	return new(big.Int).Div(r.Num(), r.Denom())
+}
+
+// roundDecimal rounds r to digits decimal places, or to a multiple of a power
+// of ten if digits is negative, as mode says.
+func roundDecimal(r *big.Rat, digits int, mode big.RoundingMode) *big.Rat {
+	exp := int64(digits)
+	if exp < 0 {
+		exp = -exp
+	}
+	p := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(exp), nil))
+	x := new(big.Rat)
+	if digits < 0 {
+		x.Quo(r, p)
+	} else {
+		x.Mul(r, p)
+	}
+
+	q, rem := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		// x is between q and q+1 away from zero, so it rounds to either
+		var away bool
+		switch mode {
+		case big.AwayFromZero:
+			away = true
+		case big.ToNegativeInf:
+			away = x.Sign() < 0
+		case big.ToPositiveInf:
+			away = x.Sign() > 0
+		case big.ToNearestEven, big.ToNearestAway:
+			half := rem.Abs(rem).Lsh(rem, 1).Cmp(x.Denom())
+			away = half > 0 || half == 0 && (mode == big.ToNearestAway || q.Bit(0) == 1)
+		}
+		if away {
+			q.Add(q, big.NewInt(int64(x.Sign())))
+		}
+	}
+
+	x.SetInt(q)
+	if digits < 0 {
+		return x.Mul(x, p)
+	}
+	return x.Quo(x, p)
+}
@@ -0,0 +1,211 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func Test_DecimalValue(t *testing.T) {
+	data := internal.NewJSONParser(map[string]interface{}{
+		"price":  float64(0.1),
+		"tax":    float64(0.2),
+		"zero":   float64(0),
+		"prices": []interface{}{float64(0.1), float64(0.2), nil, float64(0.4)},
+		"items": []interface{}{
+			map[string]interface{}{"price": float64(1.1), "quantity": float64(3)},
+			map[string]interface{}{"price": float64(2.2), "quantity": float64(1)},
+		},
+	})
+	halfEven := internal.DecimalMode{Scale: 2, Rounding: big.ToNearestEven}
+
+	tests := []struct {
+		name       string
+		expression string
+		mode       internal.DecimalMode
+		value      string
+	}{
+		{name: "sum", expression: "0.1 + 0.2 - 0.3", value: "0"},
+		{name: "paths", expression: "$.price + $.tax", value: "0.3"},
+		{name: "product", expression: "1.1 * 1.1 * $.price", value: "0.121"},
+		{name: "quotient", expression: "1 / 3", value: "0.33"},
+		{name: "quotient half even", expression: "0.125 / 1", value: "0.12"},
+		{name: "quotient half away", expression: "0.125 / 1", mode: internal.DecimalMode{Scale: 2, Rounding: big.ToNearestAway}, value: "0.13"},
+		{name: "quotient toward zero", expression: "-2 / 3", mode: internal.DecimalMode{Scale: 1, Rounding: big.ToZero}, value: "-0.6"},
+		{name: "quotient away from zero", expression: "-2 / 3", mode: internal.DecimalMode{Scale: 1, Rounding: big.AwayFromZero}, value: "-0.7"},
+		{name: "quotient down", expression: "-2 / 3", mode: internal.DecimalMode{Scale: 1, Rounding: big.ToNegativeInf}, value: "-0.7"},
+		{name: "quotient up", expression: "-2 / 3", mode: internal.DecimalMode{Scale: 1, Rounding: big.ToPositiveInf}, value: "-0.6"},
+		{name: "negative scale", expression: "1250 / 1", mode: internal.DecimalMode{Scale: -2, Rounding: big.ToNearestEven}, value: "1200"},
+		{name: "round", expression: "round(2.675, 2) + round(2.5)", value: "4.68"},
+		{name: "round to tens", expression: "round(1250, -2)", value: "1200"},
+		{name: "aggregates", expression: "sum($.prices) + avg(0.1, 0.2) + min(0.3, $.price) + max($.prices) + avg($.prices)", value: "1.58"},
+		{name: "array functions", expression: "sum(map($.items, @.price * @.quantity)) + sum($.items[?(@.price * 3 == 3.3)].quantity)", value: "8.5"},
+		{name: "modulo", expression: "5.5 % 2 + -7.5 % 2 + 7.5 // 2", value: "3"},
+		{name: "power", expression: "1.1 ** 2 + 2 ** -2 + 1.5 ** 0", value: "2.46"},
+		{name: "fractional power", expression: "2 ** 0.5", value: "1.41"},
+		{name: "math functions", expression: "abs(-0.1) + floor(-0.5) + ceil(0.1) + sqrt(2) + log(1) + exp(1) + clamp(0.15, 0.1, 0.2)", value: "4.38"},
+		{name: "if and default", expression: "if($.price > 0, $.missing ? 0.1 + 0.2, 0)", value: "0.3"},
+		{name: "count and length", expression: "count($.prices) + length('ab') / 4", value: "4.5"},
+		{name: "division by zero", expression: "1 / $.zero", value: ""},
+		{name: "empty aggregate", expression: "min([])", value: ""},
+		{name: "domain error", expression: "sqrt(-1) + 1", value: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := parse(t, test.expression)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			mode := test.mode
+			if mode == (internal.DecimalMode{}) {
+				mode = halfEven
+			}
+
+			value := internal.DecimalValue(expr, internal.WithDecimal(data, mode))
+			if test.value == "" {
+				if value != nil {
+					t.Errorf("got %s, want nil", value.RatString())
+				}
+				return
+			}
+			want, _ := new(big.Rat).SetString(test.value)
+			if value == nil || value.Cmp(want) != 0 {
+				t.Errorf("got %v, want %s", value, test.value)
+			}
+		})
+	}
+}
+
+func Test_DecimalValue_Precision(t *testing.T) {
+	doc := `{"a": 0.30000000000000001, "big": 12345678901234567.89, "items": [{"a": 0.30000000000000001}],
+		"list": [0.30000000000000001, null, 0.1]}`
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	parsers := map[string]internal.PathParser{
+		"raw JSON":  internal.NewRawJSONParser([]byte(doc)),
+		"UseNumber": internal.NewJSONParser(decoded),
+	}
+	mode := internal.DecimalMode{Scale: 2, Rounding: big.ToNearestEven}
+
+	tests := []struct {
+		expression string
+		value      string
+	}{
+		{expression: "12345678901234567.89 + 0.01", value: "12345678901234567.9"},
+		{expression: "$.a - 0.3", value: "0.00000000000000001"},
+		{expression: "$.big - 12345678901234567", value: "0.89"},
+		{expression: "($.missing ? 0) + $.a * 10", value: "3.0000000000000001"},
+		{expression: "sum($.list) + sum($.a) + count($.items, @.a - 0.3 > 0)", value: "1.70000000000000002"},
+	}
+	for name, p := range parsers {
+		for _, test := range tests {
+			t.Run(name+" "+test.expression, func(t *testing.T) {
+				expr, err := parse(t, test.expression)
+				if err != nil {
+					t.Fatalf("got unexpected error: %s", err)
+				}
+
+				value, err := internal.EvalDecimalStrict(expr, internal.WithDecimal(p, mode))
+				want, _ := new(big.Rat).SetString(test.value)
+				if err != nil || value.Cmp(want) != 0 {
+					t.Errorf("got %v, %v, want %s", value, err, test.value)
+				}
+			})
+		}
+	}
+}
+
+func Test_WithDecimal(t *testing.T) {
+	data := internal.WithDecimal(internal.NewJSONParser(map[string]interface{}{
+		"a":     float64(0.1),
+		"items": []interface{}{map[string]interface{}{"a": float64(0.1), "b": float64(0.2)}},
+	}), internal.DecimalMode{Scale: 2, Rounding: big.ToNearestEven})
+
+	tests := []struct {
+		name       string
+		expression string
+		value      interface{}
+	}{
+		{name: "comparison", expression: "$.a + 0.2 == 0.3 && 0.3 - 0.1 <= 0.2", value: true},
+		{name: "number", expression: "$.a * 3", value: 0.3},
+		{name: "in", expression: "$.a * 3 in [0.3]", value: true},
+		{name: "array function", expression: "any($.items, @.a + @.b == 0.3)", value: true},
+		{name: "filter", expression: "length($.items[?(@.a + @.b == 0.3)])", value: float64(1)},
+		{name: "map", expression: "map($.items, @.a + @.b)", value: []interface{}{0.3}},
+		{name: "join", expression: "join(map($.items, @.a + @.b), ',')", value: "0.3"},
+		{name: "not finite", expression: "1 / 0 > 0", value: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := parse(t, test.expression)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+
+			if value := expr.Value(data); !reflect.DeepEqual(value, test.value) {
+				t.Errorf("got %v, want %v", value, test.value)
+			}
+		})
+	}
+}
+
+func Test_EvalDecimalStrict(t *testing.T) {
+	data := internal.WithDecimal(internal.NewJSONParser(map[string]interface{}{
+		"num":  float64(0.5),
+		"zero": float64(0),
+	}), internal.DecimalMode{Scale: 2, Rounding: big.ToNearestEven})
+
+	tests := []struct {
+		name       string
+		expression string
+		value      string
+		err        error
+	}{
+		{name: "value", expression: "$.num / 3", value: "0.17"},
+		{name: "missing path", expression: "$.missing + 1", err: &internal.MissingPathError{Path: internal.Path{"missing"}}},
+		{name: "division by zero", expression: "$.num / $.zero", err: internal.ErrDivisionByZero},
+		{name: "modulo by zero", expression: "$.num % $.zero", err: internal.ErrDivisionByZero},
+		{name: "zero to a negative power", expression: "$.zero ** -1", err: internal.ErrDivisionByZero},
+		{name: "domain error", expression: "log($.zero)", err: internal.ErrDomain},
+		{name: "inverted clamp", expression: "clamp($.num, 1, 0)", err: internal.ErrDomain},
+		{name: "empty aggregate", expression: "avg([])", err: internal.ErrEmptyArray},
+		{name: "overflow", expression: "exp(1000)", err: internal.ErrNaN},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := parse(t, test.expression)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+
+			value, err := internal.EvalDecimalStrict(expr, data)
+			if test.err == nil {
+				want, _ := new(big.Rat).SetString(test.value)
+				if err != nil || value.Cmp(want) != 0 {
+					t.Errorf("got %v, %v, want %s", value, err, test.value)
+				}
+				return
+			}
+			switch want := test.err.(type) {
+			case *internal.MissingPathError:
+				var got *internal.MissingPathError
+				if !errors.As(err, &got) || !reflect.DeepEqual(got.Path, want.Path) {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+// DefaultMaxDepth is how many levels below the value it's applied to a
+// Descendants path segment descends, unless the PathParser is wrapped by
+// LimitDepth.
+const DefaultMaxDepth = 64
+
+type (
+	// depthLimit wraps a PathParser to set how deep Descendants path segments
+	// descend in it.
+	depthLimit struct {
+		PathParser
+		depth int
+	}
+
+	// depthLimiter is implemented by PathParsers that set how deep
+	// Descendants path segments descend.
+	depthLimiter interface {
+		maxDepth() int
+	}
+
+	// childLister is implemented by PathParsers that can list the keys of an
+	// object, in document order, or the indexes of an array.
+	childLister interface {
+		children(path Path) ([]interface{}, bool)
+	}
+)
+
+// LimitDepth returns a PathParser that finds values in pp, where Descendants
+// path segments descend at most depth levels below the value they're applied
+// to. Deeper values are silently skipped.
+func LimitDepth(pp PathParser, depth int) PathParser {
+	return &depthLimit{PathParser: pp, depth: depth}
+}
+
+func (d *depthLimit) GetValue(path Path) (interface{}, bool) {
+	if path.fansOut() {
+		return collect(d, path)
+	}
+	return d.PathParser.GetValue(path)
+}
+
+func (d *depthLimit) GetNumber(path Path) (float64, bool) {
+	path, ok := scalarPath(d, path)
+	if !ok {
+		return 0, false
+	}
+	return d.PathParser.GetNumber(path)
+}
+
+func (d *depthLimit) GetBoolean(path Path) (bool, bool) {
+	path, ok := scalarPath(d, path)
+	if !ok {
+		return false, false
+	}
+	return d.PathParser.GetBoolean(path)
+}
+
+func (d *depthLimit) GetString(path Path) (string, bool) {
+	path, ok := scalarPath(d, path)
+	if !ok {
+		return "", false
+	}
+	return d.PathParser.GetString(path)
+}
+
+func (d *depthLimit) GetArray(path Path) ([]interface{}, bool) {
+	if path.fansOut() {
+		return collect(d, path)
+	}
+	return d.PathParser.GetArray(path)
+}
+
+func (d *depthLimit) getDecimal(path Path) (*big.Rat, bool) {
+	path, ok := scalarPath(d, path)
+	if !ok {
+		return nil, false
+	}
+	return decimalAt(d.PathParser, path)
+}
+
+func (d *depthLimit) maxDepth() int {
+	return d.depth
+}
+
+func (d *depthLimit) children(path Path) ([]interface{}, bool) {
+	return childrenOf(d.PathParser, path)
+}
+
+// maxDepthOf returns how deep Descendants path segments descend in pp.
+func maxDepthOf(pp PathParser) int {
+	if d, ok := pp.(depthLimiter); ok {
+		return d.maxDepth()
+	}
+	return DefaultMaxDepth
+}
+
+// descendants returns the paths of the value at path and of every value
+// nested in it up to depth levels below it, each before the values nested in
+// it. It returns false if there's no value at path.
+func descendants(pp PathParser, path Path, depth int) ([]Path, bool) {
+	children, ok := childrenOf(pp, path)
+	if !ok {
+		return nil, false
+	}
+	paths := []Path{path}
+	if depth == 0 {
+		return paths, true
+	}
+	for _, child := range children {
+		childPaths, _ := descendants(pp, append(path[:len(path):len(path)], child), depth-1)
+		paths = append(paths, childPaths...)
+	}
+	return paths, true
+}
+
+// childrenOf returns the keys of the object or indexes of the array at path,
+// or nil if it's neither, and false if there's no value at path. The keys of
+// an object are in document order if pp can list them, or sorted otherwise.
+func childrenOf(pp PathParser, path Path) ([]interface{}, bool) {
+	if cl, ok := pp.(childLister); ok {
+		return cl.children(path)
+	}
+	value, ok := pp.GetValue(path)
+	if !ok {
+		return nil, false
+	}
+	return childrenOfValue(reflect.ValueOf(value)), true
+}
+
+// childrenOfValue returns the keys of v if it's a map with string keys or the
+// indexes of v if it's a slice or array, or else nil.
+func childrenOfValue(v reflect.Value) []interface{} {
+	var children []interface{}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			children = append(children, key)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			children = append(children, i)
+		}
+	}
+	return children
+}
@@ -0,0 +1,36 @@
+package internal_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func Test_LimitDepth(t *testing.T) {
+	var deep interface{} = map[string]interface{}{"id": float64(3)}
+	deep = map[string]interface{}{"id": float64(2), "next": deep}
+	deep = map[string]interface{}{"id": float64(1), "next": deep}
+	data := map[string]interface{}{"next": deep}
+
+	tests := []struct {
+		name  string
+		pp    internal.PathParser
+		path  internal.Path
+		value []interface{}
+	}{
+		{name: "default", pp: internal.NewJSONParser(data), path: internal.Path{internal.Descendants{}, "id"}, value: []interface{}{float64(1), float64(2), float64(3)}},
+		{name: "limited", pp: internal.LimitDepth(internal.NewJSONParser(data), 2), path: internal.Path{internal.Descendants{}, "id"}, value: []interface{}{float64(1), float64(2)}},
+		{name: "below path", pp: internal.LimitDepth(internal.NewJSONParser(data), 1), path: internal.Path{"next", "next", internal.Descendants{}, "id"}, value: []interface{}{float64(2), float64(3)}},
+		{name: "zero", pp: internal.LimitDepth(internal.NewJSONParser(data), 0), path: internal.Path{"next", internal.Descendants{}, "id"}, value: []interface{}{float64(1)}},
+		{name: "raw JSON", pp: internal.LimitDepth(internal.NewRawJSONParser([]byte(`{"id": 0, "a": {"id": 1, "b": {"id": 2}}}`)), 1), path: internal.Path{internal.Descendants{}, "id"}, value: []interface{}{float64(0), float64(1)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := test.pp.GetArray(test.path)
+			if !ok || !reflect.DeepEqual(value, test.value) {
+				t.Errorf("got %v, %v, want %v", value, ok, test.value)
+			}
+		})
+	}
+}
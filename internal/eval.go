@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+var (
+	// ErrDivisionByZero is returned by strict evaluation when a number is
+	// divided by zero, including with % and //, or zero is raised to a
+	// negative power.
+	ErrDivisionByZero = errors.New("division by zero")
+	// ErrNaN is returned by strict evaluation when a number expression
+	// evaluates to NaN.
+	ErrNaN = errors.New("result is NaN")
+	// ErrEmptyArray is returned by strict evaluation when the minimum,
+	// maximum or average of an empty array is taken.
+	ErrEmptyArray = errors.New("aggregate of empty array")
+	// ErrDomain is returned by strict evaluation when a math function or
+	// operator is applied to a number it isn't defined for, like the square
+	// root of a negative number.
+	ErrDomain = errors.New("argument out of domain")
+)
+
+type (
+	// MissingPathError is returned by strict evaluation when a path without a
+	// default value isn't found.
+	MissingPathError struct {
+		Path Path
+		Span Span
+	}
+
+	// TypeMismatchError is returned by strict evaluation when a path without a
+	// default value is found but isn't of the type it's used as.
+	TypeMismatchError struct {
+		Path Path
+		Want Kind
+		Span Span
+	}
+
+	// NullValueError is returned by strict evaluation when a path without a
+	// default value is found but is null, where a value of kind Want is
+	// needed.
+	NullValueError struct {
+		Path Path
+		Want Kind
+		Span Span
+	}
+
+	// strictParser wraps the PathParser an expression is evaluated against in
+	// strict mode, and records the first error found during evaluation.
+	strictParser struct {
+		PathParser
+		err error
+	}
+
+	// failer is implemented by PathParsers that record evaluation errors.
+	failer interface {
+		fail(error)
+	}
+)
+
+func (e *MissingPathError) Error() string {
+	return fmt.Sprintf("%s not found", e.Path)
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("%s is not %s", e.Path, e.Want)
+}
+
+func (e *NullValueError) Error() string {
+	return fmt.Sprintf("%s is null, not %s", e.Path, e.Want)
+}
+
+// EvalStrict evaluates e against pp, returning an error instead of a default
+// value if a path without a default isn't found, is null or is of the wrong
+// type, if a number is divided by zero, if a math function is applied to a
+// number it isn't defined for, or if a number expression evaluates to NaN.
+func EvalStrict(e Expression, pp PathParser) (interface{}, error) {
+	sp := &strictParser{PathParser: pp}
+	value := e.Value(sp)
+	if sp.err != nil {
+		return nil, sp.err
+	}
+	if f, ok := value.(float64); ok && math.IsNaN(f) {
+		return nil, ErrNaN
+	}
+	return value, nil
+}
+
+func (p *strictParser) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *strictParser) children(path Path) ([]interface{}, bool) {
+	return childrenOf(p.PathParser, path)
+}
+
+func (p *strictParser) maxDepth() int {
+	return maxDepthOf(p.PathParser)
+}
+
+func (p *strictParser) getDecimal(path Path) (*big.Rat, bool) {
+	return decimalAt(p.PathParser, path)
+}
+
+func (p *strictParser) decimalMode() (DecimalMode, bool) {
+	return decimalModeOf(p.PathParser)
+}
+
+// strict reports whether pp is evaluating in strict mode.
+func strict(pp PathParser) bool {
+	_, ok := pp.(failer)
+	return ok
+}
+
+// fail records err if pp is evaluating in strict mode.
+func fail(pp PathParser, err error) {
+	if f, ok := pp.(failer); ok {
+		f.fail(err)
+	}
+}
+
+// pathNotFound records the error for a path without a default that wasn't
+// found as a value of kind want.
+func pathNotFound(pp PathParser, path Path, want Kind, span Span) {
+	if !strict(pp) {
+		return
+	}
+	if want != ArrayKind && path.fansOut() {
+		// the first value selected is used, if there is one
+		first, ok := scalarPath(pp, path)
+		if !ok {
+			fail(pp, &MissingPathError{Path: path, Span: span})
+			return
+		}
+		path = first
+	}
+	switch value, ok := pp.GetValue(path); {
+	case !ok:
+		fail(pp, &MissingPathError{Path: path, Span: span})
+	case value == nil:
+		fail(pp, &NullValueError{Path: path, Want: want, Span: span})
+	default:
+		fail(pp, &TypeMismatchError{Path: path, Want: want, Span: span})
+	}
+}
+
+// checkNaN records ErrNaN if n is NaN.
+func checkNaN(pp PathParser, n float64) float64 {
+	if math.IsNaN(n) {
+		fail(pp, ErrNaN)
+	}
+	return n
+}
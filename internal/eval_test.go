@@ -0,0 +1,131 @@
+package internal_test
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func Test_EvalStrict(t *testing.T) {
+	data := internal.NewJSONParser(map[string]interface{}{
+		"num":   float64(4),
+		"zero":  float64(0),
+		"nan":   math.NaN(),
+		"name":  "dan",
+		"yes":   true,
+		"arr":   []interface{}{float64(1)},
+		"mixed": []interface{}{float64(1), "x"},
+		"null":  nil,
+		"nulls": []interface{}{float64(1), nil, float64(3)},
+		"items": []interface{}{
+			map[string]interface{}{"price": float64(2)},
+			map[string]interface{}{},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		expression string
+		value      interface{}
+		err        error
+	}{
+		{name: "valid", expression: "($.num / 2) + length($.arr)", value: float64(3)},
+		{name: "default used", expression: "$.missing ? 1", value: float64(1)},
+		{name: "default of wrong type used", expression: "$.name ? 1", value: float64(1)},
+		{name: "array function", expression: "any($.items, @.price > 1)", value: true},
+		{name: "missing element path", expression: "all($.items, @.price > 1)", err: &internal.MissingPathError{Path: internal.Path{internal.Element(""), "price"}}},
+		{name: "aggregate", expression: "avg($.arr) + max($.num, 2)", value: float64(5)},
+		{name: "empty aggregate", expression: "min(filter($.arr, @ > 1))", err: internal.ErrEmptyArray},
+		{name: "constant empty aggregate", expression: "max([])", err: internal.ErrEmptyArray},
+		{name: "aggregate of non-number", expression: "sum($.mixed)", err: &internal.TypeMismatchError{Path: internal.Path{"mixed", 1}, Want: internal.NumberKind}},
+		{name: "aggregate of wildcard non-number", expression: "sum($.mixed[*])", err: &internal.TypeMismatchError{Path: internal.Path{"mixed", 1}, Want: internal.NumberKind}},
+		{name: "aggregate of selected non-number", expression: "max($..name)", err: &internal.TypeMismatchError{Path: internal.Path{"name"}, Want: internal.NumberKind}},
+		{name: "wildcard path", expression: "sum($.items[*].price) + length($.items[*].price)", value: float64(3)},
+		{name: "missing wildcard path", expression: "length($.missing[*].price)", err: &internal.MissingPathError{Path: internal.Path{"missing", internal.Wildcard{}, "price"}}},
+		{name: "filter path", expression: "$.items[?(@.price > 1)].price", value: []interface{}{float64(2)}},
+		{name: "filter path as a single value", expression: "$.items[?(@.price > 1)].price * 2", value: float64(4)},
+		{name: "filter path of wrong type", expression: "$.items[?(@.price > 1)].price == 'x'", err: &internal.TypeMismatchError{Path: internal.Path{"items", 0, "price"}, Want: internal.StringKind}},
+		{name: "if branch not taken", expression: "if($.yes, $.num, $.missing)", value: float64(4)},
+		{name: "short circuit", expression: "$.yes || $.missing", value: true},
+		{name: "missing number", expression: "$.missing + 1", err: &internal.MissingPathError{Path: internal.Path{"missing"}}},
+		{name: "missing boolean", expression: "!$.a.b", err: &internal.MissingPathError{Path: internal.Path{"a", "b"}}},
+		{name: "missing bare path", expression: "$.missing", err: &internal.MissingPathError{Path: internal.Path{"missing"}}},
+		{name: "mismatched number", expression: "$.name * 2", err: &internal.TypeMismatchError{Path: internal.Path{"name"}, Want: internal.NumberKind}},
+		{name: "mismatched string", expression: "$.num == 'x'", err: &internal.TypeMismatchError{Path: internal.Path{"num"}, Want: internal.StringKind}},
+		{name: "mismatched array", expression: "length($.num)", err: &internal.TypeMismatchError{Path: internal.Path{"num"}, Want: internal.ArrayKind}},
+		{name: "null number", expression: "$.null + 1", err: &internal.NullValueError{Path: internal.Path{"null"}, Want: internal.NumberKind}},
+		{name: "null string", expression: "upper($.null)", err: &internal.NullValueError{Path: internal.Path{"null"}, Want: internal.StringKind}},
+		{name: "null with default", expression: "($.null ? 2) + ($.null ? $.num)", value: float64(6)},
+		{name: "null comparison", expression: "$.null == null && $.num != null", value: true},
+		{name: "missing null comparison", expression: "$.missing == null", err: &internal.MissingPathError{Path: internal.Path{"missing"}}},
+		{name: "missing or null comparison", expression: "($.missing ? null) == null && ($.null ? null) == null", value: true},
+		{name: "aggregate skips nulls", expression: "avg($.nulls) + sum($.nulls)", value: float64(6)},
+		{name: "missing array", expression: "length($.missing)", err: &internal.MissingPathError{Path: internal.Path{"missing"}}},
+		{name: "first error", expression: "sum($.a, $.b)", err: &internal.MissingPathError{Path: internal.Path{"a"}}},
+		{name: "division by zero", expression: "$.num / $.zero", err: internal.ErrDivisionByZero},
+		{name: "constant division by zero", expression: "1 / 0", err: internal.ErrDivisionByZero},
+		{name: "square root of a negative number", expression: "sqrt($.num - 5)", err: internal.ErrDomain},
+		{name: "logarithm of zero", expression: "log($.zero)", err: internal.ErrDomain},
+		{name: "inverted clamp", expression: "clamp($.num, 2, 1)", err: internal.ErrDomain},
+		{name: "fractional power of a negative number", expression: "(-8) ** 0.5", err: internal.ErrDomain},
+		{name: "modulo by zero", expression: "$.num % $.zero", err: internal.ErrDivisionByZero},
+		{name: "floor division by zero", expression: "1 // 0", err: internal.ErrDivisionByZero},
+		{name: "zero to a negative power", expression: "$.zero ** -1", err: internal.ErrDivisionByZero},
+		{name: "math functions", expression: "round(sqrt($.num) ** 3 % 5, 1) + clamp(log($.num), 0, 1)", value: float64(4)},
+		{name: "nan path", expression: "$.nan > 1", err: internal.ErrNaN},
+		{name: "invalid dynamic pattern", expression: "$.name =~ concat($.name, '[')", err: &internal.Error{Msg: "invalid pattern: error parsing regexp: missing closing ]: `[`"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := parse(t, test.expression)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			expr = expr.Reduce()
+
+			value, err := internal.EvalStrict(expr, data)
+			if test.err == nil {
+				if err != nil {
+					t.Fatalf("got unexpected error: %s", err)
+				}
+				if !reflect.DeepEqual(value, test.value) {
+					t.Errorf("got %v, want %v", value, test.value)
+				}
+				return
+			}
+
+			switch want := test.err.(type) {
+			case *internal.MissingPathError:
+				var got *internal.MissingPathError
+				if !errors.As(err, &got) || !reflect.DeepEqual(got.Path, want.Path) {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			case *internal.TypeMismatchError:
+				var got *internal.TypeMismatchError
+				if !errors.As(err, &got) || !reflect.DeepEqual(got.Path, want.Path) || got.Want != want.Want {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			case *internal.NullValueError:
+				var got *internal.NullValueError
+				if !errors.As(err, &got) || !reflect.DeepEqual(got.Path, want.Path) || got.Want != want.Want {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			case *internal.Error:
+				var got *internal.Error
+				if !errors.As(err, &got) || got.Msg != want.Msg {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("got error %v, want %v", err, want)
+				}
+			}
+
+			// lenient evaluation never fails
+			expr.Value(data)
+		})
+	}
+}
@@ -3,6 +3,11 @@ package internal
 type (
 	Path []interface{}
 
+	// node holds the source span of an expression node.
+	node struct {
+		span Span
+	}
+
 	PathParser interface {
 		GetValue(Path) (interface{}, bool)
 		GetNumber(Path) (float64, bool)
@@ -14,24 +19,31 @@ type (
 	Expression interface {
 		Value(PathParser) interface{}
 		Reduce() Expression
+		Span() Span
 	}
 
 	NumberExpression interface {
 		Value(PathParser) float64
 		Reduce() NumberExpression
+		Span() Span
 	}
 
 	BooleanExpression interface {
 		Value(PathParser) bool
 		Reduce() BooleanExpression
+		Span() Span
 	}
 
 	StringExpression interface {
 		Value(PathParser) string
+		Reduce() StringExpression
+		Span() Span
 	}
 
 	ArrayExpression interface {
 		Value(PathParser) []interface{}
+		Reduce() ArrayExpression
+		Span() Span
 	}
 
 	generic struct {
@@ -42,121 +54,243 @@ type (
 	}
 
 	genericPath struct {
+		node
 		path []interface{}
 	}
 
 	genericPathWithDefault struct {
+		node
 		path         []interface{}
-		defaultValue interface{}
+		defaultValue Expression
 	}
 
 	number struct {
+		node
 		n float64
+		// text is the literal as written, if it was parsed, which decimal
+		// mode evaluates exactly.
+		text string
 	}
 
 	numberPath struct {
+		node
 		path []interface{}
 	}
 
 	numberPathWithDefault struct {
+		node
 		path         []interface{}
 		defaultValue NumberExpression
 	}
 
 	inverseExpression struct {
+		node
 		subExpression NumberExpression
 	}
 
 	sumExpression struct {
+		node
 		subExpressions []NumberExpression
 	}
 
 	subtractExpression struct {
+		node
 		e1 NumberExpression
 		e2 NumberExpression
 	}
 
 	timesExpression struct {
+		node
 		subExpressions []NumberExpression
 	}
 
 	divideExpression struct {
+		node
 		e1 NumberExpression
 		e2 NumberExpression
 	}
 
 	lengthExpression struct {
+		node
 		ae ArrayExpression
 	}
 
 	boolean struct {
+		node
 		b bool
 	}
 
 	booleanPath struct {
+		node
 		path []interface{}
 	}
 
 	booleanPathWithDefault struct {
+		node
 		path         []interface{}
 		defaultValue BooleanExpression
 	}
 
 	notExpression struct {
+		node
 		subExpression BooleanExpression
 	}
 
 	lessThanExpression struct {
+		node
 		e1 NumberExpression
 		e2 NumberExpression
 	}
 
 	lessThanOrEqualExpression struct {
+		node
 		e1 NumberExpression
 		e2 NumberExpression
 	}
 
 	greaterThanExpression struct {
+		node
 		e1 NumberExpression
 		e2 NumberExpression
 	}
 
 	greaterThanOrEqualExpression struct {
+		node
 		e1 NumberExpression
 		e2 NumberExpression
 	}
 
+	// strCompareExpression orders two strings by comparing their bytes, which
+	// is the order of their code points. op is one of the operators accepted
+	// by isComparison.
+	strCompareExpression struct {
+		node
+		op TokenType
+		e1 StringExpression
+		e2 StringExpression
+	}
+
 	equalExpression struct {
+		node
 		e1 Expression
 		e2 Expression
 	}
 
 	andExpression struct {
+		node
 		subExpressions []BooleanExpression
 	}
 
 	orExpression struct {
+		node
 		subExpressions []BooleanExpression
 	}
 
 	str struct {
+		node
 		s string
 	}
 
+	// null is the null literal. It can only be compared with == and !=, and
+	// is only equal to a value that is found and is null.
+	null struct {
+		node
+	}
+
 	strPath struct {
+		node
 		path []interface{}
 	}
 
 	strPathWithDefault struct {
+		node
 		path         []interface{}
 		defaultValue StringExpression
 	}
 
 	arrayPath struct {
+		node
 		path []interface{}
 	}
+
+	arrayPathWithDefault struct {
+		node
+		path         []interface{}
+		defaultValue ArrayExpression
+	}
+
+	// array is an array literal. Its elements are all of the same kind, or
+	// are all untyped paths.
+	array struct {
+		node
+		elems []Expression
+	}
+
+	// inExpression reports whether the value of e is equal to an element of
+	// ae.
+	inExpression struct {
+		node
+		e  Expression
+		ae ArrayExpression
+	}
 )
 
+// spanner is implemented by every expression node so the parser can set the
+// span of the nodes it builds.
+type spanner interface {
+	setSpan(Span)
+}
+
+func (n node) Span() Span {
+	return n.span
+}
+
+func (n *node) setSpan(span Span) {
+	n.span = span
+}
+
+func (e *generic) setSpan(span Span) {
+	switch {
+	case e.n != nil:
+		e.n.(spanner).setSpan(span)
+	case e.b != nil:
+		e.b.(spanner).setSpan(span)
+	case e.s != nil:
+		e.s.(spanner).setSpan(span)
+	case e.a != nil:
+		e.a.(spanner).setSpan(span)
+	}
+}
+
+func (e *generic) Span() Span {
+	switch {
+	case e.n != nil:
+		return e.n.Span()
+	case e.b != nil:
+		return e.b.Span()
+	case e.s != nil:
+		return e.s.Span()
+	case e.a != nil:
+		return e.a.Span()
+	}
+	return Span{}
+}
+
+// typed returns the typed expression e wraps.
+func (e *generic) typed() interface{} {
+	switch {
+	case e.n != nil:
+		return e.n
+	case e.b != nil:
+		return e.b
+	case e.s != nil:
+		return e.s
+	case e.a != nil:
+		return e.a
+	}
+	return nil
+}
+
 func (e *generic) Value(pp PathParser) interface{} {
 	switch {
 	case e.n != nil:
@@ -178,23 +312,37 @@ func (e *generic) Reduce() Expression {
 	if e.b != nil {
 		e.b = e.b.Reduce()
 	}
+	if e.s != nil {
+		e.s = e.s.Reduce()
+	}
+	if e.a != nil {
+		e.a = e.a.Reduce()
+	}
 	return e
 }
 
 func (gp *genericPath) Value(pp PathParser) interface{} {
-	value, _ := pp.GetValue(gp.path)
+	value, ok := pp.GetValue(gp.path)
+	if !ok {
+		pathNotFound(pp, gp.path, AnyKind, gp.span)
+	}
 	return value
 }
 
+func (gp *genericPath) Reduce() Expression {
+	return gp
+}
+
 func (gpd *genericPathWithDefault) Value(pp PathParser) interface{} {
-	value, ok := pp.GetArray(gpd.path)
-	if !ok {
-		return gpd.defaultValue
+	value, ok := pp.GetValue(gpd.path)
+	if !ok || value == nil {
+		return gpd.defaultValue.Value(pp)
 	}
 	return value
 }
 
 func (gpd *genericPathWithDefault) Reduce() Expression {
+	gpd.defaultValue = gpd.defaultValue.Reduce()
 	return gpd
 }
 
@@ -207,8 +355,11 @@ func (n *number) Reduce() NumberExpression {
 }
 
 func (np *numberPath) Value(pp PathParser) float64 {
-	num, _ := pp.GetNumber(np.path)
-	return num
+	num, ok := pp.GetNumber(np.path)
+	if !ok {
+		pathNotFound(pp, np.path, NumberKind, np.span)
+	}
+	return checkNaN(pp, num)
 }
 
 func (np *numberPath) Reduce() NumberExpression {
@@ -220,7 +371,7 @@ func (npd *numberPathWithDefault) Value(pp PathParser) float64 {
 	if !ok {
 		return npd.defaultValue.Value(pp)
 	}
-	return num
+	return checkNaN(pp, num)
 }
 
 func (npd *numberPathWithDefault) Reduce() NumberExpression {
@@ -229,30 +380,36 @@ func (npd *numberPathWithDefault) Reduce() NumberExpression {
 }
 
 func (ie *inverseExpression) Value(pp PathParser) float64 {
-	return -ie.subExpression.Value(pp)
+	return checkNaN(pp, -ie.subExpression.Value(pp))
 }
 
 func (ie *inverseExpression) Reduce() NumberExpression {
 	ie.subExpression = ie.subExpression.Reduce()
 	if numExpr, ok := ie.subExpression.(*number); ok {
-		return &number{n: -numExpr.n}
+		return &number{node: ie.node, n: -numExpr.n}
 	}
 	return ie
 }
 
 func (se *sumExpression) Value(pp PathParser) float64 {
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, se)
+	}
 	var sum float64
 	for _, subExpression := range se.subExpressions {
 		sum += subExpression.Value(pp)
 	}
-	return sum
+	return checkNaN(pp, sum)
 }
 
+// Reduce folds the numbers being summed into one, unless their sum isn't
+// finite.
 func (se *sumExpression) Reduce() NumberExpression {
 	var sum float64
 	var subExpressions []NumberExpression
-	for _, subExpression := range se.subExpressions {
+	for i, subExpression := range se.subExpressions {
 		reducedSubExpression := subExpression.Reduce()
+		se.subExpressions[i] = reducedSubExpression
 		if numExpr, ok := reducedSubExpression.(*number); ok {
 			sum += numExpr.n
 		} else if sumExpr, ok := reducedSubExpression.(*sumExpression); ok {
@@ -267,18 +424,24 @@ func (se *sumExpression) Reduce() NumberExpression {
 			subExpressions = append(subExpressions, reducedSubExpression)
 		}
 	}
+	if !isFinite(sum) {
+		return se
+	}
 	if len(subExpressions) == 0 {
-		return &number{n: sum}
+		return &number{node: se.node, n: sum}
 	}
 	if sum != 0 {
-		subExpressions = append(subExpressions, &number{n: sum})
+		subExpressions = append(subExpressions, &number{node: se.node, n: sum})
 	}
 	se.subExpressions = subExpressions
 	return se
 }
 
 func (se *subtractExpression) Value(pp PathParser) float64 {
-	return se.e1.Value(pp) - se.e2.Value(pp)
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, se)
+	}
+	return checkNaN(pp, se.e1.Value(pp)-se.e2.Value(pp))
 }
 
 func (se *subtractExpression) Reduce() NumberExpression {
@@ -287,26 +450,32 @@ func (se *subtractExpression) Reduce() NumberExpression {
 
 	numExpr1, ok1 := se.e1.(*number)
 	numExpr2, ok2 := se.e2.(*number)
-	if ok1 && ok2 {
-		return &number{n: numExpr1.n - numExpr2.n}
+	if ok1 && ok2 && isFinite(numExpr1.n-numExpr2.n) {
+		return &number{node: se.node, n: numExpr1.n - numExpr2.n}
 	}
 
 	return se
 }
 
 func (te *timesExpression) Value(pp PathParser) float64 {
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, te)
+	}
 	var product float64 = 1
 	for _, subExpression := range te.subExpressions {
 		product *= subExpression.Value(pp)
 	}
-	return product
+	return checkNaN(pp, product)
 }
 
+// Reduce folds the numbers being multiplied into one, unless their product
+// isn't finite.
 func (te *timesExpression) Reduce() NumberExpression {
 	var product float64 = 1
 	var subExpressions []NumberExpression
-	for _, subExpression := range te.subExpressions {
+	for i, subExpression := range te.subExpressions {
 		reducedSubExpression := subExpression.Reduce()
+		te.subExpressions[i] = reducedSubExpression
 		if numExpr, ok := reducedSubExpression.(*number); ok {
 			product *= numExpr.n
 		} else if timesExpr, ok := reducedSubExpression.(*timesExpression); ok {
@@ -321,28 +490,40 @@ func (te *timesExpression) Reduce() NumberExpression {
 			subExpressions = append(subExpressions, reducedSubExpression)
 		}
 	}
+	if !isFinite(product) {
+		return te
+	}
 	if len(subExpressions) == 0 {
-		return &number{n: product}
+		return &number{node: te.node, n: product}
 	}
 	if product != 1 {
-		subExpressions = append(subExpressions, &number{n: product})
+		subExpressions = append(subExpressions, &number{node: te.node, n: product})
 	}
 	te.subExpressions = subExpressions
 	return te
 }
 
 func (de *divideExpression) Value(pp PathParser) float64 {
-	return de.e1.Value(pp) / de.e2.Value(pp)
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, de)
+	}
+	dividend, divisor := de.e1.Value(pp), de.e2.Value(pp)
+	if divisor == 0 {
+		fail(pp, ErrDivisionByZero)
+	}
+	return checkNaN(pp, dividend/divisor)
 }
 
 func (de *divideExpression) Reduce() NumberExpression {
 	de.e1 = de.e1.Reduce()
 	de.e2 = de.e2.Reduce()
 
+	// division by zero isn't folded so strict evaluation can report it, and
+	// a quotient that overflows isn't since it has no literal
 	numExpr1, ok1 := de.e1.(*number)
 	numExpr2, ok2 := de.e2.(*number)
-	if ok1 && ok2 {
-		return &number{n: numExpr1.n / numExpr2.n}
+	if ok1 && ok2 && numExpr2.n != 0 && isFinite(numExpr1.n/numExpr2.n) {
+		return &number{node: de.node, n: numExpr1.n / numExpr2.n}
 	}
 
 	return de
@@ -353,6 +534,12 @@ func (le *lengthExpression) Value(pp PathParser) float64 {
 }
 
 func (le *lengthExpression) Reduce() NumberExpression {
+	le.ae = le.ae.Reduce()
+	if a, ok := le.ae.(*array); ok {
+		if values, ok := constant(a); ok {
+			return &number{node: le.node, n: float64(len(values.([]interface{})))}
+		}
+	}
 	return le
 }
 
@@ -365,7 +552,10 @@ func (b *boolean) Reduce() BooleanExpression {
 }
 
 func (bp *booleanPath) Value(pp PathParser) bool {
-	value, _ := pp.GetBoolean(bp.path)
+	value, ok := pp.GetBoolean(bp.path)
+	if !ok {
+		pathNotFound(pp, bp.path, BooleanKind, bp.span)
+	}
 	return value
 }
 
@@ -387,13 +577,13 @@ func (bpd *booleanPathWithDefault) Reduce() BooleanExpression {
 }
 
 func (ne *notExpression) Value(pp PathParser) bool {
-	return !ne.Value(pp)
+	return !ne.subExpression.Value(pp)
 }
 
 func (ne *notExpression) Reduce() BooleanExpression {
 	ne.subExpression = ne.subExpression.Reduce()
 	if boolExpr, ok := ne.subExpression.(*boolean); ok {
-		return &boolean{b: !boolExpr.b}
+		return &boolean{node: ne.node, b: !boolExpr.b}
 	}
 	return ne
 }
@@ -409,7 +599,7 @@ func (e *lessThanExpression) Reduce() BooleanExpression {
 	numExpr1, ok1 := e.e1.(*number)
 	numExpr2, ok2 := e.e2.(*number)
 	if ok1 && ok2 {
-		return &boolean{b: numExpr1.n < numExpr2.n}
+		return &boolean{node: e.node, b: numExpr1.n < numExpr2.n}
 	}
 
 	return e
@@ -426,7 +616,7 @@ func (e *lessThanOrEqualExpression) Reduce() BooleanExpression {
 	numExpr1, ok1 := e.e1.(*number)
 	numExpr2, ok2 := e.e2.(*number)
 	if ok1 && ok2 {
-		return &boolean{b: numExpr1.n <= numExpr2.n}
+		return &boolean{node: e.node, b: numExpr1.n <= numExpr2.n}
 	}
 
 	return e
@@ -443,7 +633,7 @@ func (e *greaterThanExpression) Reduce() BooleanExpression {
 	numExpr1, ok1 := e.e1.(*number)
 	numExpr2, ok2 := e.e2.(*number)
 	if ok1 && ok2 {
-		return &boolean{b: numExpr1.n > numExpr2.n}
+		return &boolean{node: e.node, b: numExpr1.n > numExpr2.n}
 	}
 
 	return e
@@ -460,16 +650,75 @@ func (e *greaterThanOrEqualExpression) Reduce() BooleanExpression {
 	numExpr1, ok1 := e.e1.(*number)
 	numExpr2, ok2 := e.e2.(*number)
 	if ok1 && ok2 {
-		return &boolean{b: numExpr1.n >= numExpr2.n}
+		return &boolean{node: e.node, b: numExpr1.n >= numExpr2.n}
 	}
 
 	return e
 }
 
+func (e *strCompareExpression) Value(pp PathParser) bool {
+	s1, s2 := e.e1.Value(pp), e.e2.Value(pp)
+	switch e.op {
+	case LESS_THAN_OP:
+		return s1 < s2
+	case LESS_THAN_OR_EQUAL_OP:
+		return s1 <= s2
+	case GREATER_THAN_OP:
+		return s1 > s2
+	case GREATER_THAN_OR_EQUAL_OP:
+		return s1 >= s2
+	}
+	panic("unexpected comparison operator " + e.op.String())
+}
+
+func (e *strCompareExpression) Reduce() BooleanExpression {
+	e.e1 = e.e1.Reduce()
+	e.e2 = e.e2.Reduce()
+	return foldBoolean(e, e.e1, e.e2)
+}
+
 func (e *equalExpression) Value(pp PathParser) bool {
-	v1 := e.e1.Value(pp)
-	v2 := e.e2.Value(pp)
+	if _, ok := e.e2.(*null); ok {
+		return isNull(pp, e.e1)
+	}
+	if _, ok := e.e1.(*null); ok {
+		return isNull(pp, e.e2)
+	}
+	return valuesEqual(e.e1.Value(pp), e.e2.Value(pp))
+}
+
+// isNull reports whether e evaluates to null. An untyped path is null if its
+// value is found and is null, so a path that isn't found isn't null, and a
+// path with a default is null if its default is. Typed expressions are never
+// null.
+func isNull(pp PathParser, e Expression) bool {
+	switch e := e.(type) {
+	case *null:
+		return true
+	case *genericPath:
+		if path, ok := scalarPath(pp, e.path); ok {
+			if value, ok := pp.GetValue(path); ok {
+				return value == nil
+			}
+		}
+		pathNotFound(pp, e.path, AnyKind, e.span)
+	case *genericPathWithDefault:
+		if value, ok := pp.GetValue(e.path); ok && value != nil {
+			return false
+		}
+		return isNull(pp, e.defaultValue)
+	case *genericIfExpression:
+		if e.cond.Value(pp) {
+			return isNull(pp, e.then)
+		}
+		return isNull(pp, e.els)
+	}
+	return false
+}
 
+// valuesEqual reports whether v1 and v2 are equal numbers, booleans or
+// strings.
+func valuesEqual(v1, v2 interface{}) bool {
 	str1, ok1 := v1.(string)
 	str2, ok2 := v2.(string)
 	if ok1 && ok2 {
@@ -484,7 +733,7 @@ func (e *equalExpression) Value(pp PathParser) bool {
 
 	b1, ok1 := v1.(bool)
 	b2, ok2 := v2.(bool)
-	if ok1 == ok2 {
+	if ok1 && ok2 {
 		return b1 == b2
 	}
 
@@ -495,6 +744,12 @@ func (e *equalExpression) Reduce() BooleanExpression {
 	e.e1 = e.e1.Reduce()
 	e.e2 = e.e2.Reduce()
 
+	_, null1 := e.e1.(*null)
+	_, null2 := e.e2.(*null)
+	if null1 && null2 {
+		return &boolean{node: e.node, b: true}
+	}
+
 	expr1, ok1 := e.e1.(*generic)
 	expr2, ok2 := e.e2.(*generic)
 	if ok1 && ok2 {
@@ -502,7 +757,7 @@ func (e *equalExpression) Reduce() BooleanExpression {
 			strExpr1, ok1 := expr1.s.(*str)
 			strExpr2, ok2 := expr2.s.(*str)
 			if ok1 && ok2 {
-				return &boolean{b: strExpr1.s == strExpr2.s}
+				return &boolean{node: e.node, b: strExpr1.s == strExpr2.s}
 			}
 		}
 
@@ -510,7 +765,7 @@ func (e *equalExpression) Reduce() BooleanExpression {
 			numExpr1, ok1 := expr1.n.(*number)
 			numExpr2, ok2 := expr2.n.(*number)
 			if ok1 && ok2 {
-				return &boolean{b: numExpr1.n == numExpr2.n}
+				return &boolean{node: e.node, b: numExpr1.n == numExpr2.n}
 			}
 		}
 
@@ -518,7 +773,7 @@ func (e *equalExpression) Reduce() BooleanExpression {
 			boolExpr1, ok1 := expr1.b.(*boolean)
 			boolExpr2, ok2 := expr2.b.(*boolean)
 			if ok1 && ok2 {
-				return &boolean{b: boolExpr1.b == boolExpr2.b}
+				return &boolean{node: e.node, b: boolExpr1.b == boolExpr2.b}
 			}
 		}
 	}
@@ -550,7 +805,10 @@ func (e *andExpression) Reduce() BooleanExpression {
 		}
 	}
 	if len(subExpressions) == 0 {
-		return &boolean{b: true}
+		return &boolean{node: e.node, b: true}
+	}
+	if len(subExpressions) == 1 {
+		return subExpressions[0]
 	}
 	e.subExpressions = subExpressions
 	return e
@@ -580,7 +838,10 @@ func (e *orExpression) Reduce() BooleanExpression {
 		}
 	}
 	if len(subExpressions) == 0 {
-		return &boolean{b: false}
+		return &boolean{node: e.node, b: false}
+	}
+	if len(subExpressions) == 1 {
+		return subExpressions[0]
 	}
 	e.subExpressions = subExpressions
 	return e
@@ -594,8 +855,19 @@ func (e *str) Reduce() StringExpression {
 	return e
 }
 
+func (e *null) Value(PathParser) interface{} {
+	return nil
+}
+
+func (e *null) Reduce() Expression {
+	return e
+}
+
 func (e *strPath) Value(pp PathParser) string {
-	value, _ := pp.GetString(e.path)
+	value, ok := pp.GetString(e.path)
+	if !ok {
+		pathNotFound(pp, e.path, StringKind, e.span)
+	}
 	return value
 }
 
@@ -611,7 +883,101 @@ func (e *strPathWithDefault) Value(pp PathParser) string {
 	return value
 }
 
+func (e *strPathWithDefault) Reduce() StringExpression {
+	e.defaultValue = e.defaultValue.Reduce()
+	return e
+}
+
 func (e *arrayPath) Value(pp PathParser) []interface{} {
-	value, _ := pp.GetArray(e.path)
+	value, ok := pp.GetArray(e.path)
+	if !ok {
+		pathNotFound(pp, e.path, ArrayKind, e.span)
+	}
 	return value
 }
+
+func (e *arrayPath) Reduce() ArrayExpression {
+	return e
+}
+
+func (e *arrayPathWithDefault) Value(pp PathParser) []interface{} {
+	value, ok := pp.GetArray(e.path)
+	if !ok {
+		return e.defaultValue.Value(pp)
+	}
+	return value
+}
+
+func (e *arrayPathWithDefault) Reduce() ArrayExpression {
+	e.defaultValue = e.defaultValue.Reduce()
+	return e
+}
+
+func (e *array) Value(pp PathParser) []interface{} {
+	values := make([]interface{}, len(e.elems))
+	for i, elem := range e.elems {
+		values[i] = elem.Value(pp)
+	}
+	return values
+}
+
+func (e *array) Reduce() ArrayExpression {
+	for i, elem := range e.elems {
+		e.elems[i] = elem.Reduce()
+	}
+	return e
+}
+
+func (e *inExpression) Value(pp PathParser) bool {
+	value := e.e.Value(pp)
+	for _, elem := range e.ae.Value(pp) {
+		if valuesEqual(value, elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *inExpression) Reduce() BooleanExpression {
+	e.e = e.e.Reduce()
+	e.ae = e.ae.Reduce()
+
+	value, ok1 := constant(e.e)
+	values, ok2 := constant(e.ae)
+	if ok1 && ok2 {
+		for _, elem := range values.([]interface{}) {
+			if valuesEqual(value, elem) {
+				return &boolean{node: e.node, b: true}
+			}
+		}
+		return &boolean{node: e.node, b: false}
+	}
+
+	return e
+}
+
+// constant returns the value of e if it's a literal, or an array literal whose
+// elements are all literals.
+func constant(e interface{}) (interface{}, bool) {
+	switch e := e.(type) {
+	case *generic:
+		return constant(e.typed())
+	case *number:
+		return e.n, true
+	case *boolean:
+		return e.b, true
+	case *str:
+		return e.s, true
+	case *array:
+		values := make([]interface{}, len(e.elems))
+		for i, elem := range e.elems {
+			value, ok := constant(elem)
+			if !ok {
+				return nil, false
+			}
+			values[i] = value
+		}
+		return values, true
+	}
+	return nil, false
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+)
+
+type jsonParser struct {
+	value interface{}
+}
+
+// NewJSONParser returns a PathParser for v, a value decoded by encoding/json
+// into an interface{}. Objects must be map[string]interface{} and arrays
+// []interface{}. Numbers can be float64, json.Number (when the decoder's
+// UseNumber is set) or any other Go int, uint or float type, and are all
+// returned as float64.
+//
+// A path isn't found if it indexes an array with a key, an object with an
+// index, or a primitive with either, or if an array index is out of range.
+// Negative indexes count back from the end of an array.
+func NewJSONParser(v interface{}) PathParser {
+	return &jsonParser{value: v}
+}
+
+// GetValue returns the value at path, with numbers converted to float64,
+// including those in arrays and objects. A null value is found, and returned
+// as nil.
+func (p *jsonParser) GetValue(path Path) (interface{}, bool) {
+	if path.fansOut() {
+		return collect(p, path)
+	}
+	value, ok := p.get(path)
+	if !ok {
+		return nil, false
+	}
+	return normalize(value), true
+}
+
+func (p *jsonParser) GetNumber(path Path) (float64, bool) {
+	value, ok := p.get(path)
+	if !ok {
+		return 0, false
+	}
+	return toFloat(value)
+}
+
+// getDecimal reads a json.Number as the decimal it was written as.
+func (p *jsonParser) getDecimal(path Path) (*big.Rat, bool) {
+	value, ok := p.get(path)
+	if !ok {
+		return nil, false
+	}
+	f, ok := toFloat(value)
+	if !ok {
+		return nil, false
+	}
+	if n, isNumber := value.(json.Number); isNumber {
+		return parseDecimal(string(n), f), true
+	}
+	return DecimalOf(f), true
+}
+
+func (p *jsonParser) GetBoolean(path Path) (bool, bool) {
+	value, _ := p.get(path)
+	b, ok := value.(bool)
+	return b, ok
+}
+
+func (p *jsonParser) GetString(path Path) (string, bool) {
+	value, _ := p.get(path)
+	s, ok := value.(string)
+	return s, ok
+}
+
+func (p *jsonParser) GetArray(path Path) ([]interface{}, bool) {
+	if path.fansOut() {
+		return collect(p, path)
+	}
+	value, _ := p.get(path)
+	a, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	return normalize(a).([]interface{}), true
+}
+
+// get returns the value at path, or the first value selected by a path that
+// fans out.
+func (p *jsonParser) get(path Path) (interface{}, bool) {
+	path, ok := scalarPath(p, path)
+	if !ok {
+		return nil, false
+	}
+	return valueAt(p.value, path)
+}
+
+// valueAt returns the value at path in v, a value decoded by encoding/json.
+func valueAt(v interface{}, path Path) (interface{}, bool) {
+	for _, segment := range path {
+		switch segment := segment.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[segment]; !ok {
+				return nil, false
+			}
+		case int:
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			i, ok := arrayIndex(segment, len(a))
+			if !ok {
+				return nil, false
+			}
+			v = a[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// normalize returns v with the numbers in it, at any depth, converted to
+// float64.
+func normalize(v interface{}) interface{} {
+	n, _ := normalized(v)
+	return n
+}
+
+// normalized is normalize, also reporting whether anything was converted. An
+// array or object is only copied if something in it is, so a value that's
+// already normalized is returned as it is.
+func normalized(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, false
+	case []interface{}:
+		var a []interface{}
+		for i, elem := range v {
+			n, changed := normalized(elem)
+			if changed && a == nil {
+				a = make([]interface{}, len(v))
+				copy(a, v)
+			}
+			if a != nil {
+				a[i] = n
+			}
+		}
+		if a == nil {
+			return v, false
+		}
+		return a, true
+	case map[string]interface{}:
+		var m map[string]interface{}
+		for key, elem := range v {
+			n, changed := normalized(elem)
+			if !changed {
+				continue
+			}
+			if m == nil {
+				m = make(map[string]interface{}, len(v))
+				for key, elem := range v {
+					m[key] = elem
+				}
+			}
+			m[key] = n
+		}
+		if m == nil {
+			return v, false
+		}
+		return m, true
+	}
+	if f, ok := toFloat(v); ok {
+		return f, true
+	}
+	return v, false
+}
+
+// toFloat converts any Go number or json.Number to a float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,164 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func Test_JSONParser(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(`{
+		"num": 1.5,
+		"big": 12345678901234567890,
+		"str": "s",
+		"bool": true,
+		"null": null,
+		"arr": [1, "two", [3]],
+		"obj": {"key": {"inner": false}}
+	}`))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	decoded.(map[string]interface{})["ints"] = []interface{}{int(1), int8(-2), uint16(3), int64(4), uint64(5), float32(0.5)}
+	p := internal.NewJSONParser(decoded)
+
+	tests := []struct {
+		name  string
+		path  internal.Path
+		get   func(internal.PathParser, internal.Path) (interface{}, bool)
+		value interface{}
+		ok    bool
+	}{
+		{name: "json.Number", path: internal.Path{"num"}, get: getNumber, value: 1.5, ok: true},
+		{name: "big json.Number", path: internal.Path{"big"}, get: getNumber, value: 12345678901234567890.0, ok: true},
+		{name: "json.Number value", path: internal.Path{"num"}, get: getValue, value: 1.5, ok: true},
+		{name: "int", path: internal.Path{"ints", 0}, get: getNumber, value: float64(1), ok: true},
+		{name: "int8", path: internal.Path{"ints", 1}, get: getNumber, value: float64(-2), ok: true},
+		{name: "uint16", path: internal.Path{"ints", 2}, get: getNumber, value: float64(3), ok: true},
+		{name: "int64 value", path: internal.Path{"ints", 3}, get: getValue, value: float64(4), ok: true},
+		{name: "uint64", path: internal.Path{"ints", 4}, get: getNumber, value: float64(5), ok: true},
+		{name: "float32", path: internal.Path{"ints", 5}, get: getNumber, value: 0.5, ok: true},
+		{name: "string", path: internal.Path{"str"}, get: getString, value: "s", ok: true},
+		{name: "boolean", path: internal.Path{"obj", "key", "inner"}, get: getBoolean, value: false, ok: true},
+		{name: "array", path: internal.Path{"arr", 2}, get: getArray, value: []interface{}{float64(3)}, ok: true},
+		{name: "array of ints", path: internal.Path{"ints"}, get: getArray, value: []interface{}{float64(1), float64(-2), float64(3), float64(4), float64(5), 0.5}, ok: true},
+		{name: "null", path: internal.Path{"null"}, get: getValue, value: nil, ok: true},
+		{name: "object", path: internal.Path{"obj"}, get: getValue, value: map[string]interface{}{"key": map[string]interface{}{"inner": false}}, ok: true},
+		{name: "nested numbers", path: internal.Path{"arr"}, get: getValue, value: []interface{}{float64(1), "two", []interface{}{float64(3)}}, ok: true},
+		{name: "missing key", path: internal.Path{"nope"}, get: getValue, value: nil, ok: false},
+		{name: "index out of range", path: internal.Path{"arr", 3}, get: getValue, value: nil, ok: false},
+		{name: "negative index", path: internal.Path{"arr", -1}, get: getValue, value: []interface{}{float64(3)}, ok: true},
+		{name: "negative index out of range", path: internal.Path{"arr", -4}, get: getValue, value: nil, ok: false},
+		{name: "wildcard", path: internal.Path{"ints", internal.Wildcard{}}, get: getArray, value: []interface{}{float64(1), float64(-2), float64(3), float64(4), float64(5), 0.5}, ok: true},
+		{name: "slice", path: internal.Path{"ints", internal.Slice{Start: 1, End: -3, HasEnd: true}}, get: getValue, value: []interface{}{float64(-2), float64(3)}, ok: true},
+		{name: "open slice", path: internal.Path{"ints", internal.Slice{Start: -1}}, get: getArray, value: []interface{}{0.5}, ok: true},
+		{name: "empty slice", path: internal.Path{"ints", internal.Slice{Start: 4, End: 2, HasEnd: true}}, get: getArray, value: []interface{}{}, ok: true},
+		{name: "wildcard skips missing", path: internal.Path{"arr", internal.Wildcard{}, 0}, get: getArray, value: []interface{}{float64(3)}, ok: true},
+		{name: "wildcard of missing", path: internal.Path{"nope", internal.Wildcard{}}, get: getArray, value: []interface{}(nil), ok: false},
+		{name: "descendants", path: internal.Path{internal.Descendants{}, "inner"}, get: getArray, value: []interface{}{false}, ok: true},
+		{name: "descendants in key order", path: internal.Path{internal.Descendants{}, 0}, get: getValue, value: []interface{}{float64(1), float64(3), float64(1)}, ok: true},
+		{name: "descendants of missing", path: internal.Path{"nope", internal.Descendants{}}, get: getArray, value: []interface{}(nil), ok: false},
+		{name: "wildcard as number", path: internal.Path{"ints", internal.Wildcard{}}, get: getNumber, value: float64(0), ok: false},
+		{name: "key into array", path: internal.Path{"arr", "key"}, get: getValue, value: nil, ok: false},
+		{name: "index into object", path: internal.Path{"obj", 0}, get: getValue, value: nil, ok: false},
+		{name: "key into primitive", path: internal.Path{"str", "key"}, get: getValue, value: nil, ok: false},
+		{name: "wrong type", path: internal.Path{"str"}, get: getNumber, value: float64(0), ok: false},
+		{name: "null as number", path: internal.Path{"null"}, get: getNumber, value: float64(0), ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := test.get(p, test.path)
+			if ok != test.ok || !reflect.DeepEqual(value, test.value) {
+				t.Errorf("got %#v, %v, want %#v, %v", value, ok, test.value, test.ok)
+			}
+		})
+	}
+}
+
+func Test_JSONParser_ArrayNumbers(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(`{"a": 2, "arr": [1, 2, 3]}`))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	parsers := map[string]internal.PathParser{
+		"UseNumber": internal.NewJSONParser(decoded),
+		"ints":      internal.NewJSONParser(map[string]interface{}{"a": 2, "arr": []interface{}{1, 2, 3}}),
+	}
+
+	tests := []struct {
+		expression string
+		value      interface{}
+	}{
+		{expression: "2 in $.arr", value: true},
+		{expression: "$.a in $.arr", value: true},
+		{expression: "4 not in $.arr", value: true},
+		{expression: "join($.arr, ',')", value: "1,2,3"},
+		{expression: "sum($.arr)", value: float64(6)},
+	}
+	for name, p := range parsers {
+		for _, test := range tests {
+			t.Run(name+" "+test.expression, func(t *testing.T) {
+				expr, err := parse(t, test.expression)
+				if err != nil {
+					t.Fatalf("got unexpected error: %s", err)
+				}
+				if value := expr.Value(p); !reflect.DeepEqual(value, test.value) {
+					t.Errorf("got %#v, want %#v", value, test.value)
+				}
+			})
+		}
+	}
+}
+
+func Test_JSONParser_Copies(t *testing.T) {
+	floats := []interface{}{float64(1), map[string]interface{}{"a": "x"}}
+	ints := []interface{}{float64(1), map[string]interface{}{"a": 2}}
+	p := internal.NewJSONParser(map[string]interface{}{"floats": floats, "ints": ints})
+
+	// values without numbers to convert aren't copied
+	value, _ := p.GetArray(internal.Path{"floats"})
+	if &value[0] != &floats[0] {
+		t.Errorf("got a copy of %v", floats)
+	}
+	obj, _ := p.GetValue(internal.Path{"floats", 1})
+	if reflect.ValueOf(obj).Pointer() != reflect.ValueOf(floats[1]).Pointer() {
+		t.Errorf("got a copy of %v", floats[1])
+	}
+
+	// values that are converted are, leaving the original as it was
+	value, _ = p.GetArray(internal.Path{"ints"})
+	want := []interface{}{float64(1), map[string]interface{}{"a": float64(2)}}
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("got %#v, want %#v", value, want)
+	}
+	if ints[1].(map[string]interface{})["a"] != 2 {
+		t.Errorf("converted the original %v", ints)
+	}
+}
+
+func getValue(p internal.PathParser, path internal.Path) (interface{}, bool) {
+	return p.GetValue(path)
+}
+
+func getNumber(p internal.PathParser, path internal.Path) (interface{}, bool) {
+	return p.GetNumber(path)
+}
+
+func getBoolean(p internal.PathParser, path internal.Path) (interface{}, bool) {
+	return p.GetBoolean(path)
+}
+
+func getString(p internal.PathParser, path internal.Path) (interface{}, bool) {
+	return p.GetString(path)
+}
+
+func getArray(p internal.PathParser, path internal.Path) (interface{}, bool) {
+	return p.GetArray(path)
+}
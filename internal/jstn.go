@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+type jstnDocument struct {
+	schema *Type
+	value  interface{}
+}
+
+// ParseJSTN parses a JSTN type, i.e.
+//
+//	{name: string; age: number?; tags: [string]; "first name": string}
+//
+// The primitive types are string, number, boolean and null. A '?' after a type
+// makes it optional, which means the value may be null or, for an object
+// field, missing. Object keys that aren't valid identifiers are written as
+// JSON strings. Any error it returns is an *Error.
+func ParseJSTN(src string) (*Type, error) {
+	iter := newStringIterator(src)
+	t, err := readType(iter)
+	if err == nil {
+		skipSpace(iter)
+		if r, ok := iter.next(); ok {
+			err = iter.errorf("unexpected token %q", r)
+		}
+	}
+	if err != nil {
+		err.Source = src
+		return nil, err
+	}
+	return t, nil
+}
+
+func readType(iter *stringIterator) (*Type, *Error) {
+	skipSpace(iter)
+	r, ok := iter.next()
+	if !ok {
+		return nil, iter.errEOF()
+	}
+
+	var t *Type
+	switch {
+	case r == '[':
+		elem, err := readType(iter)
+		if err != nil {
+			return nil, err
+		}
+		if err := expectRune(iter, ']'); err != nil {
+			return nil, err
+		}
+		t = &Type{Kind: ArrayKind, Elem: elem}
+	case r == '{':
+		fields, err := readFields(iter)
+		if err != nil {
+			return nil, err
+		}
+		t = &Type{Kind: ObjectKind, Fields: fields}
+	case unicode.Is(idStart, r):
+		start := iter.prev
+		switch id := readID(iter, r); id {
+		case "string":
+			t = &Type{Kind: StringKind}
+		case "number":
+			t = &Type{Kind: NumberKind}
+		case "boolean":
+			t = &Type{Kind: BooleanKind}
+		case "null":
+			t = &Type{Kind: NullKind}
+		default:
+			return nil, errorf(Span{Start: start, End: iter.pos}, "unknown type %q", id)
+		}
+	default:
+		return nil, iter.errorf("unexpected token %q", r)
+	}
+
+	skipSpace(iter)
+	if peek, ok := iter.peek(); ok && peek == '?' {
+		_, _ = iter.next()
+		t.Optional = true
+	}
+	return t, nil
+}
+
+// readFields is called after a '{' rune is read, and reads object fields up
+// to and including the closing '}'.
+func readFields(iter *stringIterator) (map[string]*Type, *Error) {
+	fields := map[string]*Type{}
+	for {
+		skipSpace(iter)
+		r, ok := iter.next()
+		if !ok {
+			return nil, iter.errEOF()
+		}
+
+		var key string
+		switch {
+		case r == '}':
+			return fields, nil
+		case r == '"':
+			k, err := readJSONString(iter)
+			if err != nil {
+				return nil, err
+			}
+			key = k
+		case unicode.Is(idStart, r):
+			key = readID(iter, r)
+		default:
+			return nil, iter.errorf("unexpected token %q", r)
+		}
+		if _, ok := fields[key]; ok {
+			return nil, iter.errorf("duplicate key %q", key)
+		}
+
+		if err := expectRune(iter, ':'); err != nil {
+			return nil, err
+		}
+		t, err := readType(iter)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = t
+
+		skipSpace(iter)
+		r, ok = iter.next()
+		if !ok {
+			return nil, iter.errEOF()
+		}
+		switch r {
+		case ';':
+		case '}':
+			return fields, nil
+		default:
+			return nil, iter.errorf("unexpected token %q, expected ';' or '}'", r)
+		}
+	}
+}
+
+// readJSONString is called after a '"' rune is read, and reads the rest of a
+// JSON string.
+func readJSONString(iter *stringIterator) (string, *Error) {
+	start := iter.prev
+	sb := strings.Builder{}
+	sb.WriteRune('"')
+	for {
+		r, ok := iter.next()
+		if !ok {
+			return "", iter.errEOF()
+		}
+		sb.WriteRune(r)
+		if r == '"' {
+			break
+		}
+		if r == '\\' {
+			escaped, ok := iter.next()
+			if !ok {
+				return "", iter.errEOF()
+			}
+			sb.WriteRune(escaped)
+		}
+	}
+	var s string
+	if err := json.Unmarshal([]byte(sb.String()), &s); err != nil {
+		return "", errorf(Span{Start: start, End: iter.pos}, "invalid string %s", sb.String())
+	}
+	return s, nil
+}
+
+func expectRune(iter *stringIterator, want rune) *Error {
+	skipSpace(iter)
+	r, ok := iter.next()
+	if !ok {
+		return iter.errEOF()
+	}
+	if r != want {
+		return iter.errorf("unexpected token %q, expected %q", r, want)
+	}
+	return nil
+}
+
+func skipSpace(iter *stringIterator) {
+	for {
+		peek, ok := iter.peek()
+		if !ok || !unicode.IsSpace(peek) {
+			return
+		}
+		_, _ = iter.next()
+	}
+}
+
+// NewJSTNDocument returns a PathParser for a JSON document of type schema.
+// Values are only returned for paths declared in the schema, and only if they
+// match their declared type.
+func NewJSTNDocument(schema *Type, data []byte) (PathParser, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return &jstnDocument{schema: schema, value: value}, nil
+}
+
+// get returns the value at path, or the first value selected by a path that
+// fans out, and its declared type.
+func (d *jstnDocument) get(path Path) (interface{}, *Type, bool) {
+	path, ok := scalarPath(d, path)
+	if !ok {
+		return nil, nil, false
+	}
+	t, err := d.schema.Lookup(path)
+	if err != nil {
+		return nil, nil, false
+	}
+	value, ok := valueAt(d.value, path)
+	if !ok || !t.matches(value) {
+		return nil, nil, false
+	}
+	return value, t, true
+}
+
+func (d *jstnDocument) GetValue(path Path) (interface{}, bool) {
+	if path.fansOut() {
+		return collect(d, path)
+	}
+	value, _, ok := d.get(path)
+	return value, ok
+}
+
+func (d *jstnDocument) GetNumber(path Path) (float64, bool) {
+	value, t, ok := d.get(path)
+	if !ok || t.Kind != NumberKind {
+		return 0, false
+	}
+	return toFloat(value)
+}
+
+func (d *jstnDocument) GetBoolean(path Path) (bool, bool) {
+	value, t, ok := d.get(path)
+	if !ok || t.Kind != BooleanKind {
+		return false, false
+	}
+	b, ok := value.(bool)
+	return b, ok
+}
+
+func (d *jstnDocument) GetString(path Path) (string, bool) {
+	value, t, ok := d.get(path)
+	if !ok || t.Kind != StringKind {
+		return "", false
+	}
+	s, ok := value.(string)
+	return s, ok
+}
+
+func (d *jstnDocument) GetArray(path Path) ([]interface{}, bool) {
+	if path.fansOut() {
+		return collect(d, path)
+	}
+	value, t, ok := d.get(path)
+	if !ok || t.Kind != ArrayKind {
+		return nil, false
+	}
+	a, ok := value.([]interface{})
+	return a, ok
+}
@@ -0,0 +1,110 @@
+package internal_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func Test_ParseJSTN(t *testing.T) {
+	tests := []struct {
+		name   string
+		jstn   string
+		want   string
+		errMsg string
+	}{
+		{name: "primitive", jstn: "number", want: "number"},
+		{name: "optional", jstn: " string ? ", want: "string?"},
+		{name: "array", jstn: "[[boolean]]?", want: "[[boolean]]?"},
+		{
+			name: "object",
+			jstn: "{\n  name: string;\n  age: number?;\n  tags: [string];\n  \"first name\": null;\n}",
+			want: "{age: number?; \"first name\": null; name: string; tags: [string]}",
+		},
+		{name: "empty object", jstn: "{}", want: "{}"},
+		{name: "no trailing semicolon", jstn: "{a: {b: number}}", want: "{a: {b: number}}"},
+		{name: "unknown type", jstn: "{a: int}", errMsg: "1:5: unknown type \"int\""},
+		{name: "missing colon", jstn: "{a number}", errMsg: "1:4: unexpected token 'n', expected ':'"},
+		{name: "missing separator", jstn: "{a: number b: string}", errMsg: "1:12: unexpected token 'b', expected ';' or '}'"},
+		{name: "duplicate key", jstn: "{a: number; a: string}", errMsg: "duplicate key \"a\""},
+		{name: "unclosed array", jstn: "[number", errMsg: "1:8: unexpected end of input"},
+		{name: "trailing input", jstn: "number string", errMsg: "1:8: unexpected token 's'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			typ, err := internal.ParseJSTN(test.jstn)
+			if test.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+					t.Fatalf("expected error containing %s, got %v", test.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			if typ.String() != test.want {
+				t.Errorf("got %s, want %s", typ, test.want)
+			}
+		})
+	}
+}
+
+func Test_JSTNDocument(t *testing.T) {
+	schema, err := internal.ParseJSTN("{name: string; age: number?; tags: [string]; nested: {ok: boolean}; wrong: number; nums: [number]}")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	doc, err := internal.NewJSTNDocument(schema, []byte(`{
+		"name": "dan",
+		"age": null,
+		"tags": ["a", "b"],
+		"nested": {"ok": true},
+		"wrong": "not a number",
+		"nums": [1, "2"],
+		"undeclared": 1
+	}`))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	if s, ok := doc.GetString(internal.Path{"name"}); !ok || s != "dan" {
+		t.Errorf("GetString got %v, %v", s, ok)
+	}
+	if b, ok := doc.GetBoolean(internal.Path{"nested", "ok"}); !ok || !b {
+		t.Errorf("GetBoolean got %v, %v", b, ok)
+	}
+	if a, ok := doc.GetArray(internal.Path{"tags"}); !ok || !reflect.DeepEqual(a, []interface{}{"a", "b"}) {
+		t.Errorf("GetArray got %v, %v", a, ok)
+	}
+	if s, ok := doc.GetString(internal.Path{"tags", 1}); !ok || s != "b" {
+		t.Errorf("GetString of element got %v, %v", s, ok)
+	}
+	if a, ok := doc.GetArray(internal.Path{"tags", internal.Slice{Start: -1}}); !ok || !reflect.DeepEqual(a, []interface{}{"b"}) {
+		t.Errorf("GetArray of slice got %v, %v", a, ok)
+	}
+	if v, ok := doc.GetValue(internal.Path{"age"}); !ok || v != nil {
+		t.Errorf("GetValue of optional null got %v, %v", v, ok)
+	}
+
+	for _, test := range []struct {
+		name string
+		ok   bool
+	}{
+		{name: "declared type doesn't match getter", ok: func() bool { _, ok := doc.GetNumber(internal.Path{"name"}); return ok }()},
+		{name: "null optional number", ok: func() bool { _, ok := doc.GetNumber(internal.Path{"age"}); return ok }()},
+		{name: "value doesn't match declared type", ok: func() bool { _, ok := doc.GetNumber(internal.Path{"wrong"}); return ok }()},
+		{name: "element doesn't match declared type", ok: func() bool { _, ok := doc.GetArray(internal.Path{"nums"}); return ok }()},
+		{name: "undeclared path", ok: func() bool { _, ok := doc.GetValue(internal.Path{"undeclared"}); return ok }()},
+		{name: "index out of range", ok: func() bool { _, ok := doc.GetString(internal.Path{"tags", 2}); return ok }()},
+	} {
+		if test.ok {
+			t.Errorf("%s: expected value not to be found", test.name)
+		}
+	}
+
+	if _, err := internal.NewJSTNDocument(schema, []byte("{")); err == nil {
+		t.Errorf("expected error for invalid JSON")
+	}
+}
@@ -1,7 +1,6 @@
 package internal
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -34,6 +33,48 @@ const (
 	OR_OP
 	OR_WORD
 	COMMA
+	LEFT_BRACKET
+	RIGHT_BRACKET
+	IN_WORD
+	NOT_WORD
+	CONCAT_WORD
+	UPPER_WORD
+	LOWER_WORD
+	TRIM_WORD
+	SUBSTRING_WORD
+	STARTS_WITH_WORD
+	ENDS_WITH_WORD
+	CONTAINS_WORD
+	REPLACE_WORD
+	SPLIT_WORD
+	JOIN_WORD
+	MATCH_OP
+	MATCHES_WORD
+	NOT_EQUAL_OP
+	IF_WORD
+	ARROW
+	ANY_WORD
+	ALL_WORD
+	NONE_WORD
+	COUNT_WORD
+	FILTER_WORD
+	MAP_WORD
+	MIN_WORD
+	MAX_WORD
+	AVG_WORD
+	NULL
+	MODULO_OP
+	FLOOR_DIVIDE_OP
+	POWER_OP
+	POW_WORD
+	ABS_WORD
+	FLOOR_WORD
+	CEIL_WORD
+	ROUND_WORD
+	SQRT_WORD
+	LOG_WORD
+	EXP_WORD
+	CLAMP_WORD
 )
 
 type (
@@ -42,9 +83,90 @@ type (
 	Token struct {
 		Type  TokenType
 		Value interface{}
+		Span  Span
+		// Text is the number as written, for a NUMBER token, which keeps
+		// digits its float64 Value can't.
+		Text string
 	}
 )
 
+var tokenNames = [...]string{
+	PATH:                     "path",
+	NUMBER:                   "number",
+	BOOL:                     "boolean",
+	STRING:                   "string",
+	IF_NOT_FOUND_OP:          "'?'",
+	MINUS:                    "'-'",
+	PLUS_OP:                  "'+'",
+	SUM_WORD:                 "'sum'",
+	LEFT_PAREN:               "'('",
+	RIGHT_PAREN:              "')'",
+	TIMES_OP:                 "'*'",
+	PRODUCT_WORD:             "'product'",
+	DIVIDE_OP:                "'/'",
+	LENGTH_WORD:              "'length'",
+	NOT_OP:                   "'!'",
+	LESS_THAN_OP:             "'<'",
+	LESS_THAN_OR_EQUAL_OP:    "'<='",
+	GREATER_THAN_OP:          "'>'",
+	GREATER_THAN_OR_EQUAL_OP: "'>='",
+	EQUAL_OP:                 "'=='",
+	AND_OP:                   "'&&'",
+	AND_WORD:                 "'and'",
+	OR_OP:                    "'||'",
+	OR_WORD:                  "'or'",
+	COMMA:                    "','",
+	LEFT_BRACKET:             "'['",
+	RIGHT_BRACKET:            "']'",
+	IN_WORD:                  "'in'",
+	NOT_WORD:                 "'not'",
+	CONCAT_WORD:              "'concat'",
+	UPPER_WORD:               "'upper'",
+	LOWER_WORD:               "'lower'",
+	TRIM_WORD:                "'trim'",
+	SUBSTRING_WORD:           "'substring'",
+	STARTS_WITH_WORD:         "'startsWith'",
+	ENDS_WITH_WORD:           "'endsWith'",
+	CONTAINS_WORD:            "'contains'",
+	REPLACE_WORD:             "'replace'",
+	SPLIT_WORD:               "'split'",
+	JOIN_WORD:                "'join'",
+	MATCH_OP:                 "'=~'",
+	MATCHES_WORD:             "'matches'",
+	NOT_EQUAL_OP:             "'!='",
+	IF_WORD:                  "'if'",
+	ARROW:                    "'=>'",
+	ANY_WORD:                 "'any'",
+	ALL_WORD:                 "'all'",
+	NONE_WORD:                "'none'",
+	COUNT_WORD:               "'count'",
+	FILTER_WORD:              "'filter'",
+	MAP_WORD:                 "'map'",
+	MIN_WORD:                 "'min'",
+	MAX_WORD:                 "'max'",
+	AVG_WORD:                 "'avg'",
+	NULL:                     "'null'",
+	MODULO_OP:                "'%'",
+	FLOOR_DIVIDE_OP:          "'//'",
+	POWER_OP:                 "'**'",
+	POW_WORD:                 "'pow'",
+	ABS_WORD:                 "'abs'",
+	FLOOR_WORD:               "'floor'",
+	CEIL_WORD:                "'ceil'",
+	ROUND_WORD:               "'round'",
+	SQRT_WORD:                "'sqrt'",
+	LOG_WORD:                 "'log'",
+	EXP_WORD:                 "'exp'",
+	CLAMP_WORD:               "'clamp'",
+}
+
+func (t TokenType) String() string {
+	if t < 0 || int(t) >= len(tokenNames) {
+		return fmt.Sprintf("TokenType(%d)", int(t))
+	}
+	return tokenNames[t]
+}
+
 var (
 	idStart = &unicode.RangeTable{R16: []unicode.Range16{
 		{Lo: 'A', Hi: 'Z', Stride: 1},
@@ -64,29 +186,45 @@ var (
 	}}
 )
 
+// Lex splits expr into tokens. Any error it returns is an *Error.
 func Lex(expr string) ([]Token, error) {
+	tokens, err := lex(newStringIterator(expr), false)
+	if err != nil {
+		err.(*Error).Source = expr
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// lex reads tokens until the end of the input or, for the predicate of a
+// filter path segment, until the ')' that closes it.
+func lex(iter *stringIterator, filter bool) ([]Token, error) {
 	var tokens []Token
-	iter := &stringIterator{runes: []rune(expr)}
 	var err error
+	depth := 0
 	for !iter.done() && err == nil {
+		start, count := iter.pos, len(tokens)
 		r, _ := iter.next()
 		switch {
 		case r == '$':
-			t, e := readPath(iter)
+			t, e := readPath(iter, nil)
+			tokens, err = append(tokens, t), e
+		case r == '@':
+			t, e := readElementPath(iter)
 			tokens, err = append(tokens, t), e
 		case r == '-':
-			if peek, ok := iter.peek(); !ok || !unicode.Is(numRune, peek) {
+			if peek, ok := iter.peek(); !ok || !unicode.Is(numRune, peek) || endsOperand(tokens) {
 				tokens = append(tokens, Token{Type: MINUS})
 			} else {
 				_, _ = iter.next()
 				numStr := readNum(iter, r, peek)
 				num, e := convertFloat(numStr)
-				tokens, err = append(tokens, Token{Type: NUMBER, Value: num}), e
+				tokens, err = append(tokens, Token{Type: NUMBER, Value: num, Text: numStr}), e
 			}
 		case unicode.Is(numRune, r):
 			numStr := readNum(iter, r)
 			num, e := convertFloat(numStr)
-			tokens, err = append(tokens, Token{Type: NUMBER, Value: num}), e
+			tokens, err = append(tokens, Token{Type: NUMBER, Value: num, Text: numStr}), e
 		case unicode.Is(idStart, r):
 			id := readID(iter, r)
 			t, e := idToToken(id)
@@ -99,13 +237,30 @@ func Lex(expr string) ([]Token, error) {
 		case r == '+':
 			tokens = append(tokens, Token{Type: PLUS_OP})
 		case r == '(':
+			depth++
 			tokens = append(tokens, Token{Type: LEFT_PAREN})
 		case r == ')':
+			if filter && depth == 0 {
+				return tokens, nil
+			}
+			depth--
 			tokens = append(tokens, Token{Type: RIGHT_PAREN})
 		case r == '*':
-			tokens = append(tokens, Token{Type: TIMES_OP})
+			if peek, ok := iter.peek(); ok && peek == '*' {
+				_, _ = iter.next()
+				tokens = append(tokens, Token{Type: POWER_OP})
+			} else {
+				tokens = append(tokens, Token{Type: TIMES_OP})
+			}
 		case r == '/':
-			tokens = append(tokens, Token{Type: DIVIDE_OP})
+			if peek, ok := iter.peek(); ok && peek == '/' {
+				_, _ = iter.next()
+				tokens = append(tokens, Token{Type: FLOOR_DIVIDE_OP})
+			} else {
+				tokens = append(tokens, Token{Type: DIVIDE_OP})
+			}
+		case r == '%':
+			tokens = append(tokens, Token{Type: MODULO_OP})
 		case r == '<':
 			if peek, ok := iter.peek(); ok && peek == '=' {
 				_, _ = iter.next()
@@ -121,10 +276,23 @@ func Lex(expr string) ([]Token, error) {
 				tokens = append(tokens, Token{Type: GREATER_THAN_OP})
 			}
 		case r == '!':
-			tokens = append(tokens, Token{Type: NOT_OP})
+			if peek, ok := iter.peek(); ok && peek == '=' {
+				_, _ = iter.next()
+				tokens = append(tokens, Token{Type: NOT_EQUAL_OP})
+			} else {
+				tokens = append(tokens, Token{Type: NOT_OP})
+			}
 		case r == '=':
-			t, e := readDoubleToken(iter, '=', EQUAL_OP)
-			tokens, err = append(tokens, t), e
+			if peek, ok := iter.peek(); ok && peek == '~' {
+				_, _ = iter.next()
+				tokens = append(tokens, Token{Type: MATCH_OP})
+			} else if ok && peek == '>' {
+				_, _ = iter.next()
+				tokens = append(tokens, Token{Type: ARROW})
+			} else {
+				t, e := readDoubleToken(iter, '=', EQUAL_OP)
+				tokens, err = append(tokens, t), e
+			}
 		case r == '&':
 			t, e := readDoubleToken(iter, '&', AND_OP)
 			tokens, err = append(tokens, t), e
@@ -133,20 +301,61 @@ func Lex(expr string) ([]Token, error) {
 			tokens, err = append(tokens, t), e
 		case r == ',':
 			tokens = append(tokens, Token{Type: COMMA})
+		case r == '[':
+			tokens = append(tokens, Token{Type: LEFT_BRACKET})
+		case r == ']':
+			tokens = append(tokens, Token{Type: RIGHT_BRACKET})
 		case unicode.IsSpace(r):
 		default:
-			err = fmt.Errorf("unexpected token %q", r)
+			err = iter.errorf("unexpected token %q", r)
+		}
+		span := Span{Start: start, End: iter.pos}
+		if len(tokens) > count {
+			tokens[len(tokens)-1].Span = span
+		}
+		if err != nil {
+			if _, ok := err.(*Error); !ok {
+				err = &Error{Msg: err.Error(), Span: span}
+			}
 		}
 	}
 	if err != nil {
 		return nil, err
 	}
+	if filter {
+		return nil, iter.errEOF()
+	}
 	return tokens, nil
 }
 
-// readPath is called after a '$' rune is read, which starts a path.
-func readPath(iter *stringIterator) (Token, error) {
-	var path []interface{}
+// endsOperand reports whether the last token read can end an operand, in which
+// case a following '-' is a binary minus rather than the sign of a number.
+func endsOperand(tokens []Token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	switch tokens[len(tokens)-1].Type {
+	case PATH, NUMBER, BOOL, STRING, NULL, RIGHT_PAREN, RIGHT_BRACKET:
+		return true
+	}
+	return false
+}
+
+// readElementPath is called after a '@' rune is read, which starts a path
+// relative to an array element, optionally followed by the name of a lambda
+// parameter.
+func readElementPath(iter *stringIterator) (Token, error) {
+	var name string
+	if peek, ok := iter.peek(); ok && unicode.Is(idStart, peek) {
+		_, _ = iter.next()
+		name = readID(iter, peek)
+	}
+	return readPath(iter, []interface{}{Element(name)})
+}
+
+// readPath is called after a '$' rune, or an element path's '@' and name, is
+// read. It reads the segments of the path onto path.
+func readPath(iter *stringIterator, path []interface{}) (Token, error) {
 outer:
 	for {
 		peek, ok := iter.peek()
@@ -156,12 +365,19 @@ outer:
 		switch peek {
 		case '.':
 			_, _ = iter.next()
+			if peek, ok := iter.peek(); ok && peek == '.' {
+				_, _ = iter.next()
+				path = append(path, Descendants{})
+				if peek, ok := iter.peek(); ok && peek == '[' {
+					continue
+				}
+			}
 			next, ok := iter.next()
 			if !ok {
-				return Token{}, errors.New("unexpected end of input")
+				return Token{}, iter.errEOF()
 			}
 			if !unicode.Is(idStart, next) {
-				return Token{}, fmt.Errorf("key in path cannot start with %q", next)
+				return Token{}, iter.errorf("key in path cannot start with %q", next)
 			}
 			key := readID(iter, next)
 			path = append(path, key)
@@ -179,10 +395,13 @@ outer:
 	return Token{Type: PATH, Value: path}, nil
 }
 
+// readIndex reads a bracketed path segment after its '[': a quoted key, an
+// index, which may be negative, a wildcard '*', a slice 'start:end' with
+// either bound left out or a filter '?(pred)'.
 func readIndex(iter *stringIterator) (interface{}, error) {
 	next, ok := iter.next()
 	if !ok {
-		return nil, errors.New("unexpected end of input")
+		return nil, iter.errEOF()
 	}
 
 	var index interface{}
@@ -193,28 +412,97 @@ func readIndex(iter *stringIterator) (interface{}, error) {
 			return nil, err
 		}
 		index = str
-	case unicode.Is(numRune, next):
-		numStr := readNum(iter, next)
-		num, err := convertInt(numStr)
+	case next == '*':
+		index = Wildcard{}
+	case next == '?':
+		if next, ok = iter.next(); !ok {
+			return nil, iter.errEOF()
+		}
+		if next != '(' {
+			return nil, iter.errorf("unexpected token %q", next)
+		}
+		tokens, err := lex(iter, true)
+		if err != nil {
+			return nil, err
+		}
+		index = filterTokens(tokens)
+	case next == ':':
+		slice, err := readSliceEnd(iter, 0)
+		if err != nil {
+			return nil, err
+		}
+		index = slice
+	case next == '-' || unicode.Is(numRune, next):
+		num, err := readIndexNum(iter, next)
 		if err != nil {
 			return nil, err
 		}
 		index = num
+		if peek, ok := iter.peek(); ok && peek == ':' {
+			_, _ = iter.next()
+			if index, err = readSliceEnd(iter, num); err != nil {
+				return nil, err
+			}
+		}
 	default:
-		return nil, fmt.Errorf("unexpected token %q", next)
+		return nil, iter.errorf("unexpected token %q", next)
 	}
 
 	next, ok = iter.next()
 	if !ok {
-		return nil, errors.New("unexpected end of input")
+		return nil, iter.errEOF()
 	}
 	if next != ']' {
-		return nil, fmt.Errorf("unexpected token %q", next)
+		return nil, iter.errorf("unexpected token %q", next)
 	}
 
 	return index, nil
 }
 
+// readIndexNum reads an index or slice bound starting with next, which is a
+// digit or '-'.
+func readIndexNum(iter *stringIterator, next rune) (int, error) {
+	start := iter.prev
+	sign := 1
+	if next == '-' {
+		sign = -1
+		var ok bool
+		if next, ok = iter.next(); !ok {
+			return 0, iter.errEOF()
+		}
+		if !unicode.Is(numRune, next) {
+			return 0, iter.errorf("unexpected token %q", next)
+		}
+	}
+	num, err := convertInt(readNum(iter, next))
+	if err != nil {
+		return 0, &Error{Msg: err.Error(), Span: Span{Start: start, End: iter.pos}}
+	}
+	return sign * num, nil
+}
+
+// readSliceEnd reads the end of a slice after its ':', if it has one.
+func readSliceEnd(iter *stringIterator, start int) (Slice, error) {
+	slice := Slice{Start: start}
+	next, ok := iter.peek()
+	if !ok {
+		return Slice{}, iter.errEOF()
+	}
+	if next == ']' {
+		return slice, nil
+	}
+	_, _ = iter.next()
+	if next != '-' && !unicode.Is(numRune, next) {
+		return Slice{}, iter.errorf("unexpected token %q", next)
+	}
+	end, err := readIndexNum(iter, next)
+	if err != nil {
+		return Slice{}, err
+	}
+	slice.End, slice.HasEnd = end, true
+	return slice, nil
+}
+
 func readNum(iter *stringIterator, r ...rune) string {
 	var seenDecimal bool
 	sb := strings.Builder{}
@@ -282,6 +570,26 @@ func idToToken(id string) (Token, error) {
 		return Token{Type: BOOL, Value: true}, nil
 	case "false":
 		return Token{Type: BOOL, Value: false}, nil
+	case "null":
+		return Token{Type: NULL}, nil
+	case "pow":
+		return Token{Type: POW_WORD}, nil
+	case "abs":
+		return Token{Type: ABS_WORD}, nil
+	case "floor":
+		return Token{Type: FLOOR_WORD}, nil
+	case "ceil":
+		return Token{Type: CEIL_WORD}, nil
+	case "round":
+		return Token{Type: ROUND_WORD}, nil
+	case "sqrt":
+		return Token{Type: SQRT_WORD}, nil
+	case "log":
+		return Token{Type: LOG_WORD}, nil
+	case "exp":
+		return Token{Type: EXP_WORD}, nil
+	case "clamp":
+		return Token{Type: CLAMP_WORD}, nil
 	case "sum":
 		return Token{Type: SUM_WORD}, nil
 	case "product":
@@ -292,6 +600,54 @@ func idToToken(id string) (Token, error) {
 		return Token{Type: AND_WORD}, nil
 	case "or":
 		return Token{Type: OR_WORD}, nil
+	case "in":
+		return Token{Type: IN_WORD}, nil
+	case "not":
+		return Token{Type: NOT_WORD}, nil
+	case "concat":
+		return Token{Type: CONCAT_WORD}, nil
+	case "upper":
+		return Token{Type: UPPER_WORD}, nil
+	case "lower":
+		return Token{Type: LOWER_WORD}, nil
+	case "trim":
+		return Token{Type: TRIM_WORD}, nil
+	case "substring":
+		return Token{Type: SUBSTRING_WORD}, nil
+	case "startsWith":
+		return Token{Type: STARTS_WITH_WORD}, nil
+	case "endsWith":
+		return Token{Type: ENDS_WITH_WORD}, nil
+	case "contains":
+		return Token{Type: CONTAINS_WORD}, nil
+	case "replace":
+		return Token{Type: REPLACE_WORD}, nil
+	case "split":
+		return Token{Type: SPLIT_WORD}, nil
+	case "join":
+		return Token{Type: JOIN_WORD}, nil
+	case "matches":
+		return Token{Type: MATCHES_WORD}, nil
+	case "if":
+		return Token{Type: IF_WORD}, nil
+	case "any":
+		return Token{Type: ANY_WORD}, nil
+	case "all":
+		return Token{Type: ALL_WORD}, nil
+	case "none":
+		return Token{Type: NONE_WORD}, nil
+	case "count":
+		return Token{Type: COUNT_WORD}, nil
+	case "filter":
+		return Token{Type: FILTER_WORD}, nil
+	case "map":
+		return Token{Type: MAP_WORD}, nil
+	case "min":
+		return Token{Type: MIN_WORD}, nil
+	case "max":
+		return Token{Type: MAX_WORD}, nil
+	case "avg":
+		return Token{Type: AVG_WORD}, nil
 	default:
 		return Token{}, fmt.Errorf("unexpected identifier %q", id)
 	}
@@ -302,10 +658,10 @@ func idToToken(id string) (Token, error) {
 func readDoubleToken(iter *stringIterator, want rune, tokenType TokenType) (Token, error) {
 	next, ok := iter.next()
 	if !ok {
-		return Token{}, errors.New("unexpected end of input")
+		return Token{}, iter.errEOF()
 	}
 	if next != want {
-		return Token{}, fmt.Errorf("unexpected token %q after %q", next, want)
+		return Token{}, iter.errorf("unexpected token %q after %q", next, want)
 	}
 	return Token{Type: tokenType}, nil
 }
@@ -322,7 +678,7 @@ loop:
 	for {
 		r, ok := iter.next()
 		if !ok {
-			return "", errors.New("unexpected end of input")
+			return "", iter.errEOF()
 		}
 		switch r {
 		case '\'':
@@ -330,10 +686,10 @@ loop:
 		case '\\':
 			escaped, ok := iter.next()
 			if !ok {
-				return "", errors.New("unexpected end of input")
+				return "", iter.errEOF()
 			}
 			if escaped != '\'' && escaped != '\\' {
-				return "", fmt.Errorf("unexpected escaped token %q in string", escaped)
+				return "", iter.errorf("unexpected escaped token %q in string", escaped)
 			}
 			sb.WriteRune(escaped)
 		default:
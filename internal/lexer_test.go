@@ -37,6 +37,15 @@ func Test_Lex(t *testing.T) {
 				{Type: internal.RIGHT_PAREN},
 			},
 		},
+		{
+			name:       "minus after operand",
+			expression: "$.a-1",
+			tokens: []internal.Token{
+				{Type: internal.PATH, Value: []interface{}{"a"}},
+				{Type: internal.MINUS},
+				{Type: internal.NUMBER, Value: float64(1)},
+			},
+		},
 		{
 			name:       "path",
 			expression: "$.id1.id2['id3'][1][2]",
@@ -168,6 +177,159 @@ func Test_Lex(t *testing.T) {
 			expression: "$.id1['hey there",
 			errMsg:     "unexpected end of input",
 		},
+		{
+			name:       "array literal",
+			expression: "$.a not in ['x', -1]",
+			tokens: []internal.Token{
+				{Type: internal.PATH, Value: []interface{}{"a"}},
+				{Type: internal.NOT_WORD},
+				{Type: internal.IN_WORD},
+				{Type: internal.LEFT_BRACKET},
+				{Type: internal.STRING, Value: "x"},
+				{Type: internal.COMMA},
+				{Type: internal.NUMBER, Value: float64(-1)},
+				{Type: internal.RIGHT_BRACKET},
+			},
+		},
+		{
+			name:       "lambda",
+			expression: "any($.a, @x => @x.b > @[0])",
+			tokens: []internal.Token{
+				{Type: internal.ANY_WORD},
+				{Type: internal.LEFT_PAREN},
+				{Type: internal.PATH, Value: []interface{}{"a"}},
+				{Type: internal.COMMA},
+				{Type: internal.PATH, Value: []interface{}{internal.Element("x")}},
+				{Type: internal.ARROW},
+				{Type: internal.PATH, Value: []interface{}{internal.Element("x"), "b"}},
+				{Type: internal.GREATER_THAN_OP},
+				{Type: internal.PATH, Value: []interface{}{internal.Element(""), 0}},
+				{Type: internal.RIGHT_PAREN},
+			},
+		},
+		{
+			name:       "if",
+			expression: "if($.a, 1, 'x')",
+			tokens: []internal.Token{
+				{Type: internal.IF_WORD},
+				{Type: internal.LEFT_PAREN},
+				{Type: internal.PATH, Value: []interface{}{"a"}},
+				{Type: internal.COMMA},
+				{Type: internal.NUMBER, Value: float64(1)},
+				{Type: internal.COMMA},
+				{Type: internal.STRING, Value: "x"},
+				{Type: internal.RIGHT_PAREN},
+			},
+		},
+		{
+			name:       "not equal",
+			expression: "$.a != !$.b",
+			tokens: []internal.Token{
+				{Type: internal.PATH, Value: []interface{}{"a"}},
+				{Type: internal.NOT_EQUAL_OP},
+				{Type: internal.NOT_OP},
+				{Type: internal.PATH, Value: []interface{}{"b"}},
+			},
+		},
+		{
+			name:       "match operator",
+			expression: "$.a =~ 'x' == matches($.a, 'y')",
+			tokens: []internal.Token{
+				{Type: internal.PATH, Value: []interface{}{"a"}},
+				{Type: internal.MATCH_OP},
+				{Type: internal.STRING, Value: "x"},
+				{Type: internal.EQUAL_OP},
+				{Type: internal.MATCHES_WORD},
+				{Type: internal.LEFT_PAREN},
+				{Type: internal.PATH, Value: []interface{}{"a"}},
+				{Type: internal.COMMA},
+				{Type: internal.STRING, Value: "y"},
+				{Type: internal.RIGHT_PAREN},
+			},
+		},
+		{
+			name:       "wildcard, slice and negative index path segments",
+			expression: "$.a[*].b[1:3][-1][:-2][-2:][:]",
+			tokens: []internal.Token{
+				{Type: internal.PATH, Value: []interface{}{
+					"a",
+					internal.Wildcard{},
+					"b",
+					internal.Slice{Start: 1, End: 3, HasEnd: true},
+					-1,
+					internal.Slice{End: -2, HasEnd: true},
+					internal.Slice{Start: -2},
+					internal.Slice{},
+				}},
+			},
+		},
+		{
+			name:       "recursive descent",
+			expression: "$..a + @.b..['c'][0]",
+			tokens: []internal.Token{
+				{Type: internal.PATH, Value: []interface{}{internal.Descendants{}, "a"}},
+				{Type: internal.PLUS_OP},
+				{Type: internal.PATH, Value: []interface{}{internal.Element(""), "b", internal.Descendants{}, "c", 0}},
+			},
+		},
+		{
+			name:       "null",
+			expression: "$.null != null-1",
+			tokens: []internal.Token{
+				{Type: internal.PATH, Value: []interface{}{"null"}},
+				{Type: internal.NOT_EQUAL_OP},
+				{Type: internal.NULL},
+				{Type: internal.MINUS},
+				{Type: internal.NUMBER, Value: float64(1)},
+			},
+		},
+		{
+			name:       "arithmetic operators",
+			expression: "$.a**2 % 3 // 4*5/6",
+			tokens: []internal.Token{
+				{Type: internal.PATH, Value: []interface{}{"a"}},
+				{Type: internal.POWER_OP},
+				{Type: internal.NUMBER, Value: float64(2)},
+				{Type: internal.MODULO_OP},
+				{Type: internal.NUMBER, Value: float64(3)},
+				{Type: internal.FLOOR_DIVIDE_OP},
+				{Type: internal.NUMBER, Value: float64(4)},
+				{Type: internal.TIMES_OP},
+				{Type: internal.NUMBER, Value: float64(5)},
+				{Type: internal.DIVIDE_OP},
+				{Type: internal.NUMBER, Value: float64(6)},
+			},
+		},
+		{
+			name:       "end of expression after recursive descent",
+			expression: "$..",
+			errMsg:     "unexpected end of input",
+		},
+		{
+			name:       "unclosed filter in path",
+			expression: "$.a[?(@.b == (1)",
+			errMsg:     "unexpected end of input",
+		},
+		{
+			name:       "filter without parentheses in path",
+			expression: "$.a[?@.b]",
+			errMsg:     "unexpected token '@'",
+		},
+		{
+			name:       "error in filter in path",
+			expression: "$.a[?(@.b & 1)]",
+			errMsg:     "unexpected token ' ' after '&'",
+		},
+		{
+			name:       "minus without digits in path",
+			expression: "$.a[-x]",
+			errMsg:     "unexpected token 'x'",
+		},
+		{
+			name:       "bad slice end in path",
+			expression: "$.a[1:*]",
+			errMsg:     "unexpected token '*'",
+		},
 		{
 			name:       "error converting array index in path",
 			expression: "$.id1[1.1]",
@@ -217,6 +379,12 @@ func Test_Lex(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			tokens, err := internal.Lex(test.expression)
+			// spans are covered by Test_Lex_Spans, and number text by
+			// Test_Lex_NumberText
+			for i := range tokens {
+				tokens[i].Span = internal.Span{}
+				tokens[i].Text = ""
+			}
 
 			if test.errMsg == "" && err != nil {
 				t.Errorf("got unexpected error: %s", err)
@@ -232,3 +400,85 @@ func Test_Lex(t *testing.T) {
 		})
 	}
 }
+
+func Test_Lex_Spans(t *testing.T) {
+	tokens, err := internal.Lex("$['ké'] >=\n  -1.5")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	want := []internal.Span{
+		{Start: internal.Position{Offset: 0, Line: 1, Column: 1}, End: internal.Position{Offset: 8, Line: 1, Column: 8}},
+		{Start: internal.Position{Offset: 9, Line: 1, Column: 9}, End: internal.Position{Offset: 11, Line: 1, Column: 11}},
+		{Start: internal.Position{Offset: 14, Line: 2, Column: 3}, End: internal.Position{Offset: 18, Line: 2, Column: 7}},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(tokens), len(want))
+	}
+	for i, tok := range tokens {
+		if tok.Span != want[i] {
+			t.Errorf("token %d got span %v, want %v", i, tok.Span, want[i])
+		}
+	}
+}
+
+func Test_Lex_NumberText(t *testing.T) {
+	tokens, err := internal.Lex("12345678901234567.89 - -0.30000000000000001")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	want := []string{"12345678901234567.89", "", "-0.30000000000000001"}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(tokens), len(want))
+	}
+	for i, tok := range tokens {
+		if tok.Text != want[i] {
+			t.Errorf("token %d got text %q, want %q", i, tok.Text, want[i])
+		}
+	}
+}
+
+func Test_Lex_ErrorPosition(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+		snippet    string
+	}{
+		{
+			expression: "1 + #",
+			err:        "1:5: unexpected token '#'",
+			snippet:    "1 + #\n    ^",
+		},
+		{
+			expression: "true &&\n\t$.a[x]",
+			err:        "2:6: unexpected token 'x'",
+			snippet:    "\t$.a[x]\n\t    ^",
+		},
+		{
+			expression: "'abc",
+			err:        "1:5: unexpected end of input",
+			snippet:    "'abc\n    ^",
+		},
+		{
+			expression: "1 + what",
+			err:        "1:5: unexpected identifier \"what\"",
+			snippet:    "1 + what\n    ^^^^",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := internal.Lex(test.expression)
+			e, ok := err.(*internal.Error)
+			if !ok {
+				t.Fatalf("expected *internal.Error, got %v", err)
+			}
+			if e.Error() != test.err {
+				t.Errorf("got error %q, want %q", e.Error(), test.err)
+			}
+			if e.Snippet() != test.snippet {
+				t.Errorf("got snippet\n%s\nwant\n%s", e.Snippet(), test.snippet)
+			}
+		})
+	}
+}
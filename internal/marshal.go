@@ -0,0 +1,579 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"unicode"
+)
+
+// ASTVersion is the version of the JSON form of expressions written by
+// MarshalJSON. Node kinds may be added without changing it, but it changes if
+// the form of an existing node does.
+const ASTVersion = 1
+
+type (
+	// jsonAST is the JSON form of an expression, i.e.
+	//
+	//	{"version": 1, "expr": {"kind": "sum", "operands": [
+	//		{"kind": "path", "type": "number", "path": ["a", 0]},
+	//		{"kind": "number", "value": 2}
+	//	]}}
+	jsonAST struct {
+		Version int       `json:"version"`
+		Expr    *jsonNode `json:"expr"`
+	}
+
+	// jsonNode is the JSON form of an expression node. Kind says which fields
+	// are used:
+	//
+	//	number, boolean, string:  value, which for a number is finite, and
+	//	                          text, the number as written if it has more
+	//	                          digits than value keeps
+	//	null:                     no fields
+	//	path:                     type (any, number, boolean, string or
+	//	                          array), path, and optionally default; the
+	//	                          path of an array element starts with
+	//	                          {"element": name}
+	//	negate, not, length:      operand
+	//	sum, product, min, max, avg, abs, floor, ceil, round, sqrt, log, exp,
+	//	clamp, and, or, concat, upper, lower, trim, substring, startsWith,
+	//	endsWith, contains, replace, split, join, if: operands
+	//	any, all, none, count, filter, map: operands, the array and the
+	//	                          predicate, and param if it's a lambda
+	//	array:                    operands, which may be empty
+	//	subtract, divide, modulo, floorDivide, power, lessThan,
+	//	lessThanOrEqual, greaterThan, greaterThanOrEqual, equal, in, match:
+	//	left and right
+	jsonNode struct {
+		Kind     string        `json:"kind"`
+		Value    interface{}   `json:"value,omitempty"`
+		Type     string        `json:"type,omitempty"`
+		Path     []interface{} `json:"path,omitempty"`
+		Default  *jsonNode     `json:"default,omitempty"`
+		Operand  *jsonNode     `json:"operand,omitempty"`
+		Operands []*jsonNode   `json:"operands,omitempty"`
+		Left     *jsonNode     `json:"left,omitempty"`
+		Right    *jsonNode     `json:"right,omitempty"`
+		Param    string        `json:"param,omitempty"`
+		Text     string        `json:"text,omitempty"`
+	}
+)
+
+// binaryKinds are the kinds of the nodes built by binary, by operator.
+var binaryKinds = map[string]TokenType{
+	"subtract":           MINUS,
+	"divide":             DIVIDE_OP,
+	"modulo":             MODULO_OP,
+	"floorDivide":        FLOOR_DIVIDE_OP,
+	"power":              POWER_OP,
+	"lessThan":           LESS_THAN_OP,
+	"lessThanOrEqual":    LESS_THAN_OR_EQUAL_OP,
+	"greaterThan":        GREATER_THAN_OP,
+	"greaterThanOrEqual": GREATER_THAN_OR_EQUAL_OP,
+	"equal":              EQUAL_OP,
+	"in":                 IN_WORD,
+	"match":              MATCH_OP,
+}
+
+// binaryKind returns the kind of the node built by binary for op.
+func binaryKind(op TokenType) string {
+	for kind, t := range binaryKinds {
+		if t == op {
+			return kind
+		}
+	}
+	panic(fmt.Sprintf("unexpected binary operator %s", op))
+}
+
+// MarshalJSON writes an Element path segment as {"element": name}.
+func (e Element) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"element": string(e)})
+}
+
+// MarshalJSON writes a Wildcard path segment as {"wildcard": true}.
+func (Wildcard) MarshalJSON() ([]byte, error) {
+	return []byte(`{"wildcard":true}`), nil
+}
+
+// MarshalJSON writes a Descendants path segment as {"descendants": true}.
+func (Descendants) MarshalJSON() ([]byte, error) {
+	return []byte(`{"descendants":true}`), nil
+}
+
+// MarshalJSON writes a Slice path segment as {"start": start, "end": end},
+// without "end" if it doesn't have one.
+func (s Slice) MarshalJSON() ([]byte, error) {
+	m := map[string]int{"start": s.Start}
+	if s.HasEnd {
+		m["end"] = s.End
+	}
+	return json.Marshal(m)
+}
+
+// MarshalJSON writes a Filter path segment as {"filter": pred}, with pred in
+// the JSON form of expressions.
+func (f Filter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]*jsonNode{"filter": toJSON(f.pred)})
+}
+
+// MarshalJSON returns the versioned JSON form of e, which UnmarshalJSON turns
+// back into an equivalent expression. Source spans aren't kept.
+func MarshalJSON(e Expression) ([]byte, error) {
+	return json.Marshal(&jsonAST{Version: ASTVersion, Expr: toJSON(e)})
+}
+
+// UnmarshalJSON parses the JSON form of an expression written by MarshalJSON,
+// typing it the same way Parse does.
+func UnmarshalJSON(data []byte) (Expression, error) {
+	var ast jsonAST
+	if err := json.Unmarshal(data, &ast); err != nil {
+		return nil, err
+	}
+	if ast.Version < 1 || ast.Version > ASTVersion {
+		return nil, errorf(Span{}, "unsupported AST version %d", ast.Version)
+	}
+	if ast.Expr == nil {
+		return nil, errorf(Span{}, "missing expr")
+	}
+	return ast.Expr.expression(nil)
+}
+
+func toJSON(e interface{}) *jsonNode {
+	switch e := e.(type) {
+	case *generic:
+		return toJSON(e.typed())
+	case *genericPath:
+		return &jsonNode{Kind: "path", Type: AnyKind.String(), Path: e.path}
+	case *genericPathWithDefault:
+		return &jsonNode{Kind: "path", Type: AnyKind.String(), Path: e.path, Default: toJSON(e.defaultValue)}
+	case *number:
+		return &jsonNode{Kind: "number", Value: e.n, Text: e.exactText()}
+	case *numberPath:
+		return &jsonNode{Kind: "path", Type: NumberKind.String(), Path: e.path}
+	case *numberPathWithDefault:
+		return &jsonNode{Kind: "path", Type: NumberKind.String(), Path: e.path, Default: toJSON(e.defaultValue)}
+	case *inverseExpression:
+		return &jsonNode{Kind: "negate", Operand: toJSON(e.subExpression)}
+	case *sumExpression:
+		return &jsonNode{Kind: "sum", Operands: numbersToJSON(e.subExpressions)}
+	case *subtractExpression:
+		return &jsonNode{Kind: "subtract", Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *timesExpression:
+		return &jsonNode{Kind: "product", Operands: numbersToJSON(e.subExpressions)}
+	case *divideExpression:
+		return &jsonNode{Kind: "divide", Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *arithmeticExpression:
+		return &jsonNode{Kind: binaryKind(e.op), Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *mathExpression:
+		return &jsonNode{Kind: source(e.fn), Operands: numbersToJSON(e.subExpressions)}
+	case *lengthExpression:
+		return &jsonNode{Kind: "length", Operand: toJSON(e.ae)}
+	case *aggregateExpression:
+		return &jsonNode{Kind: source(e.fn), Operands: numbersToJSON(e.subExpressions)}
+	case *arrayAggregateExpression:
+		return &jsonNode{Kind: source(e.fn), Operands: argsToJSON(e.ae)}
+	case *pathAggregateExpression:
+		return &jsonNode{Kind: source(e.fn), Operands: argsToJSON(&genericPath{path: e.path})}
+	case *boolean:
+		return &jsonNode{Kind: "boolean", Value: e.b}
+	case *booleanPath:
+		return &jsonNode{Kind: "path", Type: BooleanKind.String(), Path: e.path}
+	case *booleanPathWithDefault:
+		return &jsonNode{Kind: "path", Type: BooleanKind.String(), Path: e.path, Default: toJSON(e.defaultValue)}
+	case *notExpression:
+		return &jsonNode{Kind: "not", Operand: toJSON(e.subExpression)}
+	case *lessThanExpression:
+		return &jsonNode{Kind: "lessThan", Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *lessThanOrEqualExpression:
+		return &jsonNode{Kind: "lessThanOrEqual", Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *greaterThanExpression:
+		return &jsonNode{Kind: "greaterThan", Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *greaterThanOrEqualExpression:
+		return &jsonNode{Kind: "greaterThanOrEqual", Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *strCompareExpression:
+		return &jsonNode{Kind: binaryKind(e.op), Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *equalExpression:
+		return &jsonNode{Kind: "equal", Left: toJSON(e.e1), Right: toJSON(e.e2)}
+	case *andExpression:
+		return &jsonNode{Kind: "and", Operands: booleansToJSON(e.subExpressions)}
+	case *orExpression:
+		return &jsonNode{Kind: "or", Operands: booleansToJSON(e.subExpressions)}
+	case *str:
+		return &jsonNode{Kind: "string", Value: e.s}
+	case *null:
+		return &jsonNode{Kind: "null"}
+	case *strPath:
+		return &jsonNode{Kind: "path", Type: StringKind.String(), Path: e.path}
+	case *strPathWithDefault:
+		return &jsonNode{Kind: "path", Type: StringKind.String(), Path: e.path, Default: toJSON(e.defaultValue)}
+	case *arrayPath:
+		return &jsonNode{Kind: "path", Type: ArrayKind.String(), Path: e.path}
+	case *arrayPathWithDefault:
+		return &jsonNode{Kind: "path", Type: ArrayKind.String(), Path: e.path, Default: toJSON(e.defaultValue)}
+	case *array:
+		operands := make([]*jsonNode, len(e.elems))
+		for i, elem := range e.elems {
+			operands[i] = toJSON(elem)
+		}
+		return &jsonNode{Kind: "array", Operands: operands}
+	case *inExpression:
+		return &jsonNode{Kind: "in", Left: toJSON(e.e), Right: toJSON(e.ae)}
+	case *concatExpression:
+		return &jsonNode{Kind: source(CONCAT_WORD), Operands: stringsToJSON(e.subExpressions)}
+	case *upperExpression:
+		return &jsonNode{Kind: source(UPPER_WORD), Operands: argsToJSON(e.se)}
+	case *lowerExpression:
+		return &jsonNode{Kind: source(LOWER_WORD), Operands: argsToJSON(e.se)}
+	case *trimExpression:
+		return &jsonNode{Kind: source(TRIM_WORD), Operands: argsToJSON(e.se)}
+	case *substringExpression:
+		if e.end == nil {
+			return &jsonNode{Kind: source(SUBSTRING_WORD), Operands: argsToJSON(e.se, e.start)}
+		}
+		return &jsonNode{Kind: source(SUBSTRING_WORD), Operands: argsToJSON(e.se, e.start, e.end)}
+	case *startsWithExpression:
+		return &jsonNode{Kind: source(STARTS_WITH_WORD), Operands: argsToJSON(e.se, e.prefix)}
+	case *endsWithExpression:
+		return &jsonNode{Kind: source(ENDS_WITH_WORD), Operands: argsToJSON(e.se, e.suffix)}
+	case *containsExpression:
+		return &jsonNode{Kind: source(CONTAINS_WORD), Operands: argsToJSON(e.se, e.substr)}
+	case *replaceExpression:
+		return &jsonNode{Kind: source(REPLACE_WORD), Operands: argsToJSON(e.se, e.old, e.new)}
+	case *splitExpression:
+		return &jsonNode{Kind: source(SPLIT_WORD), Operands: argsToJSON(e.se, e.sep)}
+	case *joinExpression:
+		return &jsonNode{Kind: source(JOIN_WORD), Operands: argsToJSON(e.ae, e.sep)}
+	case *numberIfExpression:
+		return &jsonNode{Kind: "if", Operands: argsToJSON(e.cond, e.then, e.els)}
+	case *booleanIfExpression:
+		return &jsonNode{Kind: "if", Operands: argsToJSON(e.cond, e.then, e.els)}
+	case *strIfExpression:
+		return &jsonNode{Kind: "if", Operands: argsToJSON(e.cond, e.then, e.els)}
+	case *arrayIfExpression:
+		return &jsonNode{Kind: "if", Operands: argsToJSON(e.cond, e.then, e.els)}
+	case *genericIfExpression:
+		return &jsonNode{Kind: "if", Operands: argsToJSON(e.cond, e.then, e.els)}
+	case *anyExpression:
+		return &jsonNode{Kind: "any", Param: e.param, Operands: argsToJSON(e.ae, e.pred)}
+	case *allExpression:
+		return &jsonNode{Kind: "all", Param: e.param, Operands: argsToJSON(e.ae, e.pred)}
+	case *noneExpression:
+		return &jsonNode{Kind: "none", Param: e.param, Operands: argsToJSON(e.ae, e.pred)}
+	case *countExpression:
+		return &jsonNode{Kind: "count", Param: e.param, Operands: argsToJSON(e.ae, e.pred)}
+	case *filterExpression:
+		return &jsonNode{Kind: "filter", Param: e.param, Operands: argsToJSON(e.ae, e.pred)}
+	case *mapExpression:
+		return &jsonNode{Kind: "map", Param: e.param, Operands: argsToJSON(e.ae, e.body)}
+	case *matchExpression:
+		return &jsonNode{Kind: "match", Left: toJSON(e.se), Right: toJSON(e.pattern)}
+	case *strLengthExpression:
+		return &jsonNode{Kind: "length", Operand: toJSON(e.se)}
+	case *pathLengthExpression:
+		return &jsonNode{Kind: "length", Operand: &jsonNode{Kind: "path", Type: AnyKind.String(), Path: e.path}}
+	}
+	panic(fmt.Sprintf("marshal: unexpected expression %T", e))
+}
+
+func numbersToJSON(nes []NumberExpression) []*jsonNode {
+	nodes := make([]*jsonNode, len(nes))
+	for i, ne := range nes {
+		nodes[i] = toJSON(ne)
+	}
+	return nodes
+}
+
+func booleansToJSON(bes []BooleanExpression) []*jsonNode {
+	nodes := make([]*jsonNode, len(bes))
+	for i, be := range bes {
+		nodes[i] = toJSON(be)
+	}
+	return nodes
+}
+
+func stringsToJSON(ses []StringExpression) []*jsonNode {
+	nodes := make([]*jsonNode, len(ses))
+	for i, se := range ses {
+		nodes[i] = toJSON(se)
+	}
+	return nodes
+}
+
+func argsToJSON(args ...interface{}) []*jsonNode {
+	nodes := make([]*jsonNode, len(args))
+	for i, arg := range args {
+		nodes[i] = toJSON(arg)
+	}
+	return nodes
+}
+
+// expression builds the expression n is the JSON form of. scopes are the
+// parameters of the array function predicates n is in, like the parser's.
+func (n *jsonNode) expression(scopes []string) (Expression, error) {
+	switch n.Kind {
+	case "number":
+		f, err := numberFromJSON(n.Value)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := new(big.Rat).SetString(n.Text); n.Text != "" && !ok {
+			return nil, errorf(Span{}, "invalid number text %q", n.Text)
+		}
+		return &generic{n: &number{n: f, text: n.Text}}, nil
+	case "boolean":
+		b, ok := n.Value.(bool)
+		if !ok {
+			return nil, errorf(Span{}, "invalid boolean %v", n.Value)
+		}
+		return &generic{b: &boolean{b: b}}, nil
+	case "string":
+		s, ok := n.Value.(string)
+		if !ok {
+			return nil, errorf(Span{}, "invalid string %v", n.Value)
+		}
+		return &generic{s: &str{s: s}}, nil
+	case "null":
+		return &null{}, nil
+	case "path":
+		return n.path(scopes)
+	case "negate", "not":
+		if n.Operand == nil {
+			return nil, errorf(Span{}, "%s is missing its operand", n.Kind)
+		}
+		operand, err := n.Operand.expression(scopes)
+		if err != nil {
+			return nil, err
+		}
+		if n.Kind == "negate" {
+			ne, err := asNumber(operand)
+			return &generic{n: &inverseExpression{subExpression: ne}}, err
+		}
+		be, err := asBoolean(operand)
+		return &generic{b: &notExpression{subExpression: be}}, err
+	case "array":
+		elems, err := expressions(n.Operands, scopes)
+		if err != nil {
+			return nil, err
+		}
+		return arrayOf(elems)
+	}
+
+	if tok, err := idToToken(n.Kind); err == nil && isFunction(tok.Type) {
+		nodes := n.Operands
+		if n.Operand != nil {
+			nodes = append([]*jsonNode{n.Operand}, nodes...)
+		}
+		if !isArrayFunction(tok.Type) || len(nodes) != 2 {
+			if n.Param != "" {
+				return nil, errorf(Span{}, "%s can't have a lambda parameter", n.Kind)
+			}
+			args, err := expressions(nodes, scopes)
+			if err != nil {
+				return nil, err
+			}
+			return call(tok.Type, args, Span{})
+		}
+		return n.arrayFunction(tok.Type, nodes, scopes)
+	}
+
+	op, ok := binaryKinds[n.Kind]
+	if !ok {
+		return nil, errorf(Span{}, "unknown node kind %q", n.Kind)
+	}
+	if n.Left == nil || n.Right == nil {
+		return nil, errorf(Span{}, "%s is missing an operand", n.Kind)
+	}
+	left, err := n.Left.expression(scopes)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.Right.expression(scopes)
+	if err != nil {
+		return nil, err
+	}
+	return binary(op, left, right)
+}
+
+// arrayFunction builds a call of the array function fn, whose second node is
+// its predicate, decoded with n's lambda parameter in scope.
+func (n *jsonNode) arrayFunction(fn TokenType, nodes []*jsonNode, scopes []string) (Expression, error) {
+	if n.Param != "" && !isParam(n.Param) {
+		return nil, errorf(Span{}, "lambda parameter must be a name like @item, got %s", Path{Element(n.Param)})
+	}
+	array, err := nodes[0].expression(scopes)
+	if err != nil {
+		return nil, err
+	}
+	pred, err := nodes[1].expression(append(scopes[:len(scopes):len(scopes)], n.Param))
+	if err != nil {
+		return nil, err
+	}
+	if n.Param != "" {
+		pred = &lambda{param: n.Param, body: pred}
+	}
+	return call(fn, []Expression{array, pred}, Span{})
+}
+
+// isParam reports whether s is a name the lexer reads as a lambda parameter.
+func isParam(s string) bool {
+	for i, r := range s {
+		if !unicode.Is(idRune, r) || i == 0 && !unicode.Is(idStart, r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func expressions(nodes []*jsonNode, scopes []string) ([]Expression, error) {
+	es := make([]Expression, len(nodes))
+	for i, n := range nodes {
+		e, err := n.expression(scopes)
+		if err != nil {
+			return nil, err
+		}
+		es[i] = e
+	}
+	return es, nil
+}
+
+// path builds a path expression, typing it like the parser would if it has a
+// default and then as its declared type. An element path must be in the scope
+// of an array function, as the parser requires.
+func (n *jsonNode) path(scopes []string) (Expression, error) {
+	path := make([]interface{}, len(n.Path))
+	for i, segment := range n.Path {
+		switch segment := segment.(type) {
+		case string:
+			path[i] = segment
+		case float64:
+			index, ok := indexFromJSON(segment)
+			if !ok {
+				return nil, errorf(Span{}, "invalid path index %v", segment)
+			}
+			path[i] = index
+		case map[string]interface{}:
+			if pred, ok := segment["filter"]; ok && len(segment) == 1 {
+				f, err := filterFromJSON(pred, scopes)
+				if err != nil {
+					return nil, err
+				}
+				path[i] = f
+				continue
+			}
+			s, ok := segmentFromJSON(segment, i)
+			if !ok {
+				return nil, errorf(Span{}, "invalid path segment %v", segment)
+			}
+			if elem, ok := s.(Element); ok && !inScope(elem, scopes) {
+				return nil, unbound(elem)
+			}
+			path[i] = s
+		default:
+			return nil, errorf(Span{}, "invalid path segment %v", segment)
+		}
+	}
+
+	var e Expression = &genericPath{path: path}
+	if n.Default != nil {
+		defaultValue, err := n.Default.expression(scopes)
+		if err != nil {
+			return nil, err
+		}
+		if e, err = withDefault(path, defaultValue); err != nil {
+			return nil, err
+		}
+	}
+
+	switch n.Type {
+	case AnyKind.String():
+		if KindOf(e) != AnyKind {
+			return nil, errorf(Span{}, "path %s with a %s default can't be of type any", Path(path), KindOf(e))
+		}
+		return e, nil
+	case NumberKind.String():
+		return as(e, NumberKind)
+	case BooleanKind.String():
+		return as(e, BooleanKind)
+	case StringKind.String():
+		return as(e, StringKind)
+	case ArrayKind.String():
+		return as(e, ArrayKind)
+	}
+	return nil, errorf(Span{}, "invalid path type %q", n.Type)
+}
+
+// segmentFromJSON decodes the object form of the path segment at index i,
+// an Element, which can only be the first segment, a Wildcard, a Descendants
+// or a Slice.
+func segmentFromJSON(m map[string]interface{}, i int) (interface{}, bool) {
+	if elem, ok := m["element"].(string); ok && len(m) == 1 {
+		return Element(elem), i == 0
+	}
+	if wildcard, ok := m["wildcard"].(bool); ok && len(m) == 1 {
+		return Wildcard{}, wildcard
+	}
+	if descendants, ok := m["descendants"].(bool); ok && len(m) == 1 {
+		return Descendants{}, descendants
+	}
+	if _, ok := m["start"]; !ok {
+		return nil, false
+	}
+	var slice Slice
+	for key, value := range m {
+		f, ok := value.(float64)
+		if !ok {
+			return nil, false
+		}
+		switch key {
+		case "start":
+			slice.Start, ok = indexFromJSON(f)
+		case "end":
+			slice.End, ok = indexFromJSON(f)
+			slice.HasEnd = true
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return slice, true
+}
+
+// filterFromJSON decodes the predicate of a Filter path segment, which was
+// decoded as part of its path without being typed. The element is in scope in
+// the predicate.
+func filterFromJSON(v interface{}, scopes []string) (Filter, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Filter{}, errorf(Span{}, "invalid filter %v", v)
+	}
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return Filter{}, errorf(Span{}, "invalid filter %v", v)
+	}
+	e, err := n.expression(append(scopes[:len(scopes):len(scopes)], ""))
+	if err != nil {
+		return Filter{}, err
+	}
+	pred, err := asBoolean(e)
+	if err != nil {
+		return Filter{}, err
+	}
+	return Filter{pred: pred}, nil
+}
+
+// indexFromJSON converts an array index or slice bound decoded from JSON to
+// an int.
+func indexFromJSON(f float64) (int, bool) {
+	return int(f), f == math.Trunc(f) && math.Abs(f) <= math.MaxInt32
+}
+
+func numberFromJSON(v interface{}) (float64, error) {
+	if f, ok := v.(float64); ok {
+		return f, nil
+	}
+	return 0, errorf(Span{}, "invalid number %v", v)
+}
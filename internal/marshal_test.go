@@ -0,0 +1,197 @@
+package internal_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func Test_MarshalJSON(t *testing.T) {
+	expressions := []string{
+		"42.5",
+		"-3",
+		"12345678901234567.89 + 0.30000000000000001",
+		"true",
+		`'it\'s'`,
+		"$.a",
+		"$.a ? $.b",
+		"$.a ? 1",
+		"!($.a ? false)",
+		`$.a[0]['b c'] == 'x'`,
+		"$.a == $.b",
+		"-$.a * 2 + 1 - $.b / 4",
+		"sum($.a, 1, 2) > product($.b)",
+		"length($.arr) <= 3 || $.yes >= 2 && $.n < 1",
+		"and($.a, $.b, or($.c))",
+		"1 / 0",
+		"$.a in [1, $.b] || $.c not in []",
+		"'x' in ($.tags ? ['y'])",
+		"concat(upper($.a), lower($.b), trim('x'), substring($.c, 1), substring($.c, 1, $.d))",
+		"startsWith($.a, 'x') || endsWith($.a, 'y') || contains($.a, 'z')",
+		"join(split(replace($.a, '-', ','), ','), ' ')",
+		"length($.a) + length($.b ? 'x') + length(['y']) + length(upper($.c))",
+		"$.a =~ '^x+$' && matches($.b, $.c)",
+		"$.a != 'x' || $.b < 'c' || $.c >= $.d",
+		"if($.a, $.b, 'x') == $.c || length(if($.c, [], $.d)) > 0",
+		"if($.a, $.b, if($.c, $.d, $.e))",
+		"any($.a, @x => all(@x.b, @.c == @x['d e']))",
+		"map(filter($.a, @ > 1), @ * 2)",
+		"sum($.a[*].b) + length($.c[1:][-1][:2][:]) + max($.d[-2:-1] ? [1])",
+		"$.a[?(@.b == 'x' && any(@.c, @ > $.d))].e == 'y' || length($.f[?(true)]) > 0",
+		"length($..a[0]..['b c']) + sum($.d..e ? [1])",
+		"$.a == null || null != ($.b ? null)",
+		"$.a % 3 + $.b // 2 - -$.c ** 2 ** $.d + ($.e ** $.f) ** 2 + pow($.g, 0.5)",
+		"abs($.a) + floor($.b) + ceil($.c) + round($.d) + round($.e, 2) + sqrt($.f) + log($.g) + exp($.h) + clamp($.i, 0, 1)",
+		"min($.a) + max($.b, 1) + avg(map($.c, @.d)) + count($.e) + sum([1, $.f])",
+	}
+	for _, src := range expressions {
+		t.Run(src, func(t *testing.T) {
+			expr, err := parse(t, src)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			data, err := internal.MarshalJSON(expr)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			decoded, err := internal.UnmarshalJSON(data)
+			if err != nil {
+				t.Fatalf("got unexpected error decoding %s: %s", data, err)
+			}
+			if got, want := internal.Print(decoded), internal.Print(expr); got != want {
+				t.Errorf("decoded %s as %s, want %s", data, got, want)
+			}
+			if internal.KindOf(decoded) != internal.KindOf(expr) {
+				t.Errorf("decoded %s as %s, want %s", data, internal.KindOf(decoded), internal.KindOf(expr))
+			}
+			again, _ := internal.MarshalJSON(decoded)
+			if string(again) != string(data) {
+				t.Errorf("re-encoded %s as %s", data, again)
+			}
+		})
+	}
+}
+
+func Test_MarshalJSON_Form(t *testing.T) {
+	expr, err := parse(t, "($.items[0].price ? 0) > 100")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	data, err := internal.MarshalJSON(expr)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	want := `{"version":1,"expr":{"kind":"greaterThan",` +
+		`"left":{"kind":"path","type":"number","path":["items",0,"price"],"default":{"kind":"number","value":0}},` +
+		`"right":{"kind":"number","value":100}}}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	expr, err = parse(t, "2 ** 1023 * 2")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	data, _ = internal.MarshalJSON(expr.Reduce())
+	want = `{"version":1,"expr":{"kind":"product","operands":[` +
+		`{"kind":"number","value":8.98846567431158e+307},{"kind":"number","value":2}]}}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+	decoded, err := internal.UnmarshalJSON(data)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if value := decoded.Value(nil); value != math.Inf(1) {
+		t.Errorf("got %v, want +Inf", value)
+	}
+}
+
+func Test_UnmarshalJSON_Error(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		errMsg string
+	}{
+		{name: "syntax", data: `{"version": 1,`, errMsg: "unexpected end of JSON input"},
+		{name: "version", data: `{"version": 2, "expr": {"kind": "number", "value": 1}}`, errMsg: "unsupported AST version 2"},
+		{name: "no version", data: `{"expr": {"kind": "number", "value": 1}}`, errMsg: "unsupported AST version 0"},
+		{name: "no expr", data: `{"version": 1}`, errMsg: "missing expr"},
+		{name: "unknown kind", data: `{"version": 1, "expr": {"kind": "bitwiseAnd"}}`, errMsg: `unknown node kind "bitwiseAnd"`},
+		{name: "bad literal", data: `{"version": 1, "expr": {"kind": "boolean", "value": 1}}`, errMsg: "invalid boolean 1"},
+		{name: "number not finite", data: `{"version": 1, "expr": {"kind": "number", "value": "Inf"}}`, errMsg: "invalid number Inf"},
+		{name: "bad index", data: `{"version": 1, "expr": {"kind": "path", "type": "any", "path": ["a", 1.5]}}`, errMsg: "invalid path index 1.5"},
+		{name: "bad path segment", data: `{"version": 1, "expr": {"kind": "path", "type": "any", "path": ["a", {"start": 1, "step": 2}]}}`, errMsg: "invalid path segment map[start:1 step:2]"},
+		{name: "element not first", data: `{"version": 1, "expr": {"kind": "path", "type": "any", "path": ["a", {"element": ""}]}}`, errMsg: "invalid path segment map[element:]"},
+		{name: "bad path type", data: `{"version": 1, "expr": {"kind": "path", "type": "object", "path": ["a"]}}`, errMsg: `invalid path type "object"`},
+		{name: "missing operand", data: `{"version": 1, "expr": {"kind": "not"}}`, errMsg: "not is missing its operand"},
+		{
+			name:   "mismatched operand",
+			data:   `{"version": 1, "expr": {"kind": "sum", "operands": [{"kind": "string", "value": "a"}]}}`,
+			errMsg: "expected number expression, got string",
+		},
+		{
+			name: "mismatched default",
+			data: `{"version": 1, "expr": {"kind": "path", "type": "number", "path": ["a"],
+				"default": {"kind": "boolean", "value": true}}}`,
+			errMsg: "expected number expression, got boolean",
+		},
+		{
+			name: "mismatched equality",
+			data: `{"version": 1, "expr": {"kind": "equal",
+				"left": {"kind": "number", "value": 1}, "right": {"kind": "string", "value": "a"}}}`,
+			errMsg: "cannot compare number with string",
+		},
+		{
+			name:   "element outside predicate",
+			data:   `{"version": 1, "expr": {"kind": "path", "type": "any", "path": [{"element": ""}, "a"]}}`,
+			errMsg: "@ can only be used in the predicate of an array function",
+		},
+		{
+			name: "unbound lambda parameter",
+			data: `{"version": 1, "expr": {"kind": "any", "param": "item", "operands": [
+				{"kind": "path", "type": "array", "path": ["a"]},
+				{"kind": "path", "type": "boolean", "path": [{"element": "zz"}]}]}}`,
+			errMsg: "@zz isn't the parameter of an enclosing lambda",
+		},
+		{
+			name: "lambda parameter in array",
+			data: `{"version": 1, "expr": {"kind": "any", "param": "item", "operands": [
+				{"kind": "path", "type": "array", "path": [{"element": "item"}]},
+				{"kind": "boolean", "value": true}]}}`,
+			errMsg: "@item isn't the parameter of an enclosing lambda",
+		},
+		{
+			name: "bad lambda parameter",
+			data: `{"version": 1, "expr": {"kind": "any", "param": "1x", "operands": [
+				{"kind": "path", "type": "array", "path": ["a"]},
+				{"kind": "boolean", "value": true}]}}`,
+			errMsg: "lambda parameter must be a name like @item, got @1x",
+		},
+		{
+			name: "lambda parameter of other function",
+			data: `{"version": 1, "expr": {"kind": "length", "param": "item", "operands": [
+				{"kind": "string", "value": "a"}]}}`,
+			errMsg: "length can't have a lambda parameter",
+		},
+		{
+			name:   "bad filter",
+			data:   `{"version": 1, "expr": {"kind": "path", "type": "any", "path": ["a", {"filter": {"kind": 1}}]}}`,
+			errMsg: "invalid filter map[kind:1]",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := internal.UnmarshalJSON([]byte(test.data))
+			if err == nil || err.Error() != test.errMsg {
+				t.Errorf("got error %v, want %s", err, test.errMsg)
+			}
+			var exprErr *internal.Error
+			if test.name != "syntax" && !errors.As(err, &exprErr) {
+				t.Errorf("got error %T, want *internal.Error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"math"
+	"math/big"
+)
+
+type (
+	// arithmeticExpression applies op, which is MODULO_OP, FLOOR_DIVIDE_OP or
+	// POWER_OP, to e1 and e2.
+	arithmeticExpression struct {
+		node
+		op TokenType
+		e1 NumberExpression
+		e2 NumberExpression
+	}
+
+	// mathExpression applies fn, one of the math functions accepted by
+	// isMathFunction other than POW_WORD, to its arguments.
+	mathExpression struct {
+		node
+		fn             TokenType
+		subExpressions []NumberExpression
+	}
+)
+
+func (e *arithmeticExpression) Value(pp PathParser) float64 {
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, e)
+	}
+	n, err := arithmetic(e.op, e.e1.Value(pp), e.e2.Value(pp))
+	if err != nil {
+		fail(pp, err)
+	}
+	return checkNaN(pp, n)
+}
+
+// Reduce doesn't fold an operation that fails strict evaluation, so that it
+// still does, or whose result isn't finite, which has no literal.
+func (e *arithmeticExpression) Reduce() NumberExpression {
+	e.e1 = e.e1.Reduce()
+	e.e2 = e.e2.Reduce()
+
+	numExpr1, ok1 := e.e1.(*number)
+	numExpr2, ok2 := e.e2.(*number)
+	if ok1 && ok2 {
+		if n, err := arithmetic(e.op, numExpr1.n, numExpr2.n); err == nil && isFinite(n) {
+			return &number{node: e.node, n: n}
+		}
+	}
+	return e
+}
+
+func (e *mathExpression) Value(pp PathParser) float64 {
+	if m, ok := decimalModeOf(pp); ok {
+		return m.float(pp, e)
+	}
+	args := make([]float64, len(e.subExpressions))
+	for i, subExpression := range e.subExpressions {
+		args[i] = subExpression.Value(pp)
+	}
+	n, err := mathFunction(e.fn, args)
+	if err != nil {
+		fail(pp, err)
+	}
+	return checkNaN(pp, n)
+}
+
+// Reduce doesn't fold a call that fails strict evaluation, so that it still
+// does, or whose result isn't finite.
+func (e *mathExpression) Reduce() NumberExpression {
+	args := make([]float64, len(e.subExpressions))
+	constants := true
+	for i, subExpression := range e.subExpressions {
+		e.subExpressions[i] = subExpression.Reduce()
+		if numExpr, ok := e.subExpressions[i].(*number); ok {
+			args[i] = numExpr.n
+		} else {
+			constants = false
+		}
+	}
+	if constants {
+		if n, err := mathFunction(e.fn, args); err == nil && isFinite(n) {
+			return &number{node: e.node, n: n}
+		}
+	}
+	return e
+}
+
+// arithmetic applies op to a and b. It returns ErrDivisionByZero for a
+// remainder or floor division by zero and for zero raised to a negative power,
+// and ErrDomain for a negative number raised to a power that isn't an
+// integer, along with the infinity or NaN that they result in.
+func arithmetic(op TokenType, a, b float64) (float64, error) {
+	switch op {
+	case MODULO_OP:
+		if b == 0 {
+			return math.NaN(), ErrDivisionByZero
+		}
+		return math.Mod(a, b), nil
+	case FLOOR_DIVIDE_OP:
+		if b == 0 {
+			return math.Floor(a / b), ErrDivisionByZero
+		}
+		return math.Floor(a / b), nil
+	case POWER_OP:
+		n := math.Pow(a, b)
+		switch {
+		case a == 0 && b < 0:
+			return n, ErrDivisionByZero
+		case a < 0 && b != math.Trunc(b) && !math.IsInf(b, 0):
+			return n, ErrDomain
+		}
+		return n, nil
+	}
+	panic("unexpected arithmetic operator " + op.String())
+}
+
+// mathFunction applies fn to args, which are as many as fn takes. It returns
+// ErrDomain for arguments fn isn't defined for, along with the NaN or infinity
+// that they result in: the square root of a negative number, the logarithm of
+// a number that isn't positive and a clamp whose minimum is greater than its
+// maximum.
+func mathFunction(fn TokenType, args []float64) (float64, error) {
+	x := args[0]
+	switch fn {
+	case ABS_WORD:
+		return math.Abs(x), nil
+	case FLOOR_WORD:
+		return math.Floor(x), nil
+	case CEIL_WORD:
+		return math.Ceil(x), nil
+	case ROUND_WORD:
+		if len(args) == 1 {
+			return math.Round(x), nil
+		}
+		return round(x, math.Trunc(args[1])), nil
+	case SQRT_WORD:
+		if x < 0 {
+			return math.NaN(), ErrDomain
+		}
+		return math.Sqrt(x), nil
+	case LOG_WORD:
+		if x <= 0 {
+			return math.Log(x), ErrDomain
+		}
+		return math.Log(x), nil
+	case EXP_WORD:
+		return math.Exp(x), nil
+	case CLAMP_WORD:
+		lo, hi := args[1], args[2]
+		if lo > hi {
+			return math.NaN(), ErrDomain
+		}
+		return math.Max(lo, math.Min(x, hi)), nil
+	}
+	panic("unexpected math function " + fn.String())
+}
+
+// round rounds x to digits decimal places, or to a multiple of a power of ten
+// if digits is negative, with halves rounded away from zero. It rounds the
+// shortest decimal that rounds to x, which is what x was written as, so a
+// half is rounded the same way whether x is just above or below it.
+func round(x, digits float64) float64 {
+	if math.IsNaN(digits) {
+		return math.NaN()
+	}
+	r := DecimalOf(x)
+	if r == nil {
+		return x
+	}
+	digits = math.Max(-maxExactExponent, math.Min(digits, maxExactExponent))
+	n, _ := roundDecimal(r, int(digits), big.ToNearestAway).Float64()
+	return n
+}
+
+// isFinite reports whether n is neither an infinity nor NaN.
+func isFinite(n float64) bool {
+	return !math.IsInf(n, 0) && !math.IsNaN(n)
+}
+
+// isMathFunction reports whether tokenType is a math function, which takes
+// numbers and is built by mathOf.
+func isMathFunction(tokenType TokenType) bool {
+	switch tokenType {
+	case ABS_WORD, FLOOR_WORD, CEIL_WORD, ROUND_WORD, SQRT_WORD, LOG_WORD, EXP_WORD, POW_WORD, CLAMP_WORD:
+		return true
+	}
+	return false
+}
+
+// mathArity returns the least and most arguments math function fn takes.
+func mathArity(fn TokenType) (int, int) {
+	switch fn {
+	case ROUND_WORD:
+		return 1, 2
+	case POW_WORD:
+		return 2, 2
+	case CLAMP_WORD:
+		return 3, 3
+	}
+	return 1, 1
+}
+
+// mathOf builds a call of math function fn. pow(x, y) is built as x ** y.
+func mathOf(fn TokenType, args []Expression) (Expression, error) {
+	subExpressions, err := asNumbers(args)
+	if err != nil {
+		return nil, err
+	}
+	if fn == POW_WORD {
+		return &generic{n: &arithmeticExpression{op: POWER_OP, e1: subExpressions[0], e2: subExpressions[1]}}, nil
+	}
+	return &generic{n: &mathExpression{fn: fn, subExpressions: subExpressions}}, nil
+}
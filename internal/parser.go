@@ -1,5 +1,1110 @@
 package internal
 
-func Parse(tokens []Token) Expression {
-	return &generic{}
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+type parser struct {
+	iter *tokenIterator
+	// end is the end of the last token read
+	end Position
+	eof Position
+	// scopes are the parameters of the array function predicates being
+	// parsed, innermost last, with "" for a predicate that isn't a lambda.
+	scopes []string
+}
+
+// Parse builds an expression tree from the tokens produced by Lex, following
+// the grammar in the README. Bare paths are typed by the slot they are used
+// in; a bare path at the top level stays untyped and evaluates to whatever
+// value the PathParser finds. Any error it returns is an *Error.
+func Parse(tokens []Token) (Expression, error) {
+	p := &parser{iter: &tokenIterator{tokens: tokens}, eof: Position{Line: 1, Column: 1}}
+	if len(tokens) > 0 {
+		p.eof = tokens[len(tokens)-1].Span.End
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if next, ok := p.iter.peek(); ok {
+		return nil, errorf(next.Span, "unexpected token %s", next.Type)
+	}
+	return expr, nil
+}
+
+func (p *parser) next() (Token, bool) {
+	tok, ok := p.iter.next()
+	if ok {
+		p.end = tok.Span.End
+	}
+	return tok, ok
+}
+
+func (p *parser) errEOF() *Error {
+	return errorf(Span{Start: p.eof, End: p.eof}, "unexpected end of input")
+}
+
+// spanFrom sets the span of e to run from start to the end of the last token
+// read.
+func (p *parser) spanFrom(e Expression, start Position) Expression {
+	e.(spanner).setSpan(Span{Start: start, End: p.end})
+	return e
+}
+
+// parseExpr parses expr from the grammar: operands joined by binary
+// operators.
+func (p *parser) parseExpr() (Expression, error) {
+	return p.parseBinary(1)
+}
+
+// parseBinary parses operands joined by binary operators of at least
+// minPrecedence, by precedence climbing. Operators of the same precedence are
+// left associative, except for '**', which is right associative.
+func (p *parser) parseBinary(minPrecedence int) (Expression, error) {
+	start, ok := p.iter.peek()
+	if !ok {
+		return nil, p.errEOF()
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		next, ok := p.iter.peek()
+		if !ok {
+			return left, nil
+		}
+		prec := precedence(next.Type)
+		if prec == 0 || prec < minPrecedence {
+			return left, nil
+		}
+
+		_, _ = p.next()
+		if next.Type == NOT_WORD {
+			if err := p.expect(IN_WORD); err != nil {
+				return nil, err
+			}
+		}
+		rightPrecedence := prec + 1
+		if next.Type == POWER_OP {
+			rightPrecedence = prec
+		}
+		right, err := p.parseBinary(rightPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		expr, err := binary(next.Type, left, right)
+		if err != nil {
+			return nil, err
+		}
+		left = p.spanFrom(expr, start.Span.Start)
+	}
+}
+
+// parseOperand parses a single operand of a binary operator, which is a
+// literal, a path, a path with a default value, a function call, a unary
+// expression or a parenthesized expression.
+func (p *parser) parseOperand() (Expression, error) {
+	tok, ok := p.next()
+	if !ok {
+		return nil, p.errEOF()
+	}
+	if tok.Type == LEFT_PAREN {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(RIGHT_PAREN); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+
+	expr, err := p.parseOperandOf(tok)
+	if err != nil {
+		return nil, err
+	}
+	return p.spanFrom(expr, tok.Span.Start), nil
+}
+
+func (p *parser) parseOperandOf(tok Token) (Expression, error) {
+	switch tok.Type {
+	case NUMBER:
+		return &generic{n: &number{n: tok.Value.(float64), text: tok.Text}}, nil
+	case BOOL:
+		return &generic{b: &boolean{b: tok.Value.(bool)}}, nil
+	case STRING:
+		return &generic{s: &str{s: tok.Value.(string)}}, nil
+	case NULL:
+		return &null{}, nil
+	case PATH:
+		path := tok.Value.([]interface{})
+		if err := p.bound(tok); err != nil {
+			return nil, err
+		}
+		if err := p.filters(path, tok.Span); err != nil {
+			return nil, err
+		}
+		isArray := Path(path).isArray()
+		if next, ok := p.iter.peek(); !ok || next.Type != IF_NOT_FOUND_OP {
+			if isArray {
+				// a path that fans out is always an array
+				return &generic{a: &arrayPath{path: path}}, nil
+			}
+			return &genericPath{path: path}, nil
+		}
+		_, _ = p.next()
+		defaultValue, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if isArray {
+			if defaultValue, err = as(defaultValue, ArrayKind); err != nil {
+				return nil, err
+			}
+		}
+		return withDefault(path, defaultValue)
+	case MINUS:
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		ne, err := asNumber(operand)
+		if err != nil {
+			return nil, err
+		}
+		return &generic{n: &inverseExpression{subExpression: ne}}, nil
+	case NOT_OP:
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		be, err := asBoolean(operand)
+		if err != nil {
+			return nil, err
+		}
+		return &generic{b: &notExpression{subExpression: be}}, nil
+	case LEFT_BRACKET:
+		elems, err := p.parseList(RIGHT_BRACKET)
+		if err != nil {
+			return nil, err
+		}
+		return arrayOf(elems)
+	}
+	if isFunction(tok.Type) {
+		args, err := p.parseArgs(tok.Type)
+		if err != nil {
+			return nil, err
+		}
+		return call(tok.Type, args, Span{Start: tok.Span.Start, End: p.end})
+	}
+
+	return nil, errorf(tok.Span, "unexpected token %s", tok.Type)
+}
+
+// parseArgs parses a parenthesized, comma separated list of at least one
+// expression, the arguments of fn.
+func (p *parser) parseArgs(fn TokenType) ([]Expression, error) {
+	if err := p.expect(LEFT_PAREN); err != nil {
+		return nil, err
+	}
+	var args []Expression
+	for {
+		var arg Expression
+		var err error
+		if len(args) == 1 && isArrayFunction(fn) {
+			arg, err = p.parsePredicate()
+		} else {
+			arg, err = p.parseExpr()
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		next, ok := p.next()
+		if !ok {
+			return nil, p.errEOF()
+		}
+		switch next.Type {
+		case COMMA:
+		case RIGHT_PAREN:
+			return args, nil
+		default:
+			return nil, errorf(next.Span, "unexpected token %s", next.Type)
+		}
+	}
+}
+
+// parsePredicate parses the second argument of an array function, which is
+// evaluated with each element of the array in scope. It's either an
+// expression using @ paths, or a lambda like @item => expr.
+func (p *parser) parsePredicate() (Expression, error) {
+	param, start := "", p.end
+	next, ok := p.iter.peek()
+	if arrow, _ := p.iter.lookahead(1); ok && next.Type == PATH && arrow.Type == ARROW {
+		path := Path(next.Value.([]interface{}))
+		if elem, _ := path.element(); elem == "" || len(path) > 1 {
+			return nil, errorf(next.Span, "lambda parameter must be a name like @item, got %s", path)
+		}
+		param, start = string(path[0].(Element)), next.Span.Start
+		_, _ = p.next()
+		_, _ = p.next()
+	}
+
+	p.scopes = append(p.scopes, param)
+	body, err := p.parseExpr()
+	p.scopes = p.scopes[:len(p.scopes)-1]
+	if err != nil || param == "" {
+		return body, err
+	}
+	return p.spanFrom(&lambda{param: param, body: body}, start), nil
+}
+
+// bound checks that tok, a path, is in the scope of an array function if
+// it's relative to an element.
+func (p *parser) bound(tok Token) error {
+	elem, ok := Path(tok.Value.([]interface{})).element()
+	if !ok || inScope(elem, p.scopes) {
+		return nil
+	}
+	err := unbound(elem)
+	err.Span = tok.Span
+	return err
+}
+
+// inScope reports whether elem, the start of an element path, refers to the
+// element of one of the array function predicates in scopes.
+func inScope(elem Element, scopes []string) bool {
+	for _, param := range scopes {
+		if elem == "" || string(elem) == param {
+			return true
+		}
+	}
+	return false
+}
+
+// unbound is the error for an element path starting with elem that isn't in
+// scope, without a position.
+func unbound(elem Element) *Error {
+	if elem == "" {
+		return errorf(Span{}, "@ can only be used in the predicate of an array function")
+	}
+	return errorf(Span{}, "@%s isn't the parameter of an enclosing lambda", elem)
+}
+
+// parseList parses a comma separated list of expressions, which may be empty,
+// up to and including the end token.
+func (p *parser) parseList(end TokenType) ([]Expression, error) {
+	if next, ok := p.iter.peek(); ok && next.Type == end {
+		_, _ = p.next()
+		return nil, nil
+	}
+	var elems []Expression
+	for {
+		elem, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+
+		next, ok := p.next()
+		if !ok {
+			return nil, p.errEOF()
+		}
+		switch next.Type {
+		case COMMA:
+		case end:
+			return elems, nil
+		default:
+			return nil, errorf(next.Span, "unexpected token %s", next.Type)
+		}
+	}
+}
+
+func (p *parser) expect(tokenType TokenType) error {
+	next, ok := p.next()
+	if !ok {
+		return p.errEOF()
+	}
+	if next.Type != tokenType {
+		return errorf(next.Span, "unexpected token %s, expected %s", next.Type, tokenType)
+	}
+	return nil
+}
+
+// precedence returns how tightly a binary operator binds, or 0 if tokenType
+// isn't a binary operator.
+func precedence(tokenType TokenType) int {
+	switch tokenType {
+	case OR_OP:
+		return 1
+	case AND_OP:
+		return 2
+	case EQUAL_OP, NOT_EQUAL_OP:
+		return 3
+	case LESS_THAN_OP, LESS_THAN_OR_EQUAL_OP, GREATER_THAN_OP, GREATER_THAN_OR_EQUAL_OP, IN_WORD, NOT_WORD, MATCH_OP:
+		return 4
+	case PLUS_OP, MINUS:
+		return 5
+	case TIMES_OP, DIVIDE_OP, MODULO_OP, FLOOR_DIVIDE_OP:
+		return 6
+	case POWER_OP:
+		return 7
+	}
+	return 0
+}
+
+// binary builds a binary operation. A NOT_WORD operator is 'not in'.
+func binary(op TokenType, left, right Expression) (Expression, error) {
+	switch op {
+	case EQUAL_OP:
+		return equal(left, right)
+	case NOT_EQUAL_OP:
+		e, err := equal(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return &generic{b: &notExpression{subExpression: e.(*generic).b}}, nil
+	case IN_WORD:
+		return in(left, right)
+	case NOT_WORD:
+		e, err := in(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return &generic{b: &notExpression{subExpression: e.(*generic).b}}, nil
+	case MATCH_OP:
+		return match(left, right)
+	}
+
+	if op == AND_OP || op == OR_OP {
+		be1, err := asBoolean(left)
+		if err != nil {
+			return nil, err
+		}
+		be2, err := asBoolean(right)
+		if err != nil {
+			return nil, err
+		}
+		if op == AND_OP {
+			return &generic{b: &andExpression{subExpressions: []BooleanExpression{be1, be2}}}, nil
+		}
+		return &generic{b: &orExpression{subExpressions: []BooleanExpression{be1, be2}}}, nil
+	}
+
+	if isComparison(op) && (KindOf(left) == StringKind || KindOf(right) == StringKind) {
+		se1, err := asString(left)
+		if err != nil {
+			return nil, err
+		}
+		se2, err := asString(right)
+		if err != nil {
+			return nil, err
+		}
+		return &generic{b: &strCompareExpression{op: op, e1: se1, e2: se2}}, nil
+	}
+
+	ne1, err := asNumber(left)
+	if err != nil {
+		return nil, err
+	}
+	ne2, err := asNumber(right)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case PLUS_OP:
+		return &generic{n: &sumExpression{subExpressions: []NumberExpression{ne1, ne2}}}, nil
+	case MINUS:
+		return &generic{n: &subtractExpression{e1: ne1, e2: ne2}}, nil
+	case TIMES_OP:
+		return &generic{n: &timesExpression{subExpressions: []NumberExpression{ne1, ne2}}}, nil
+	case DIVIDE_OP:
+		return &generic{n: &divideExpression{e1: ne1, e2: ne2}}, nil
+	case MODULO_OP, FLOOR_DIVIDE_OP, POWER_OP:
+		return &generic{n: &arithmeticExpression{op: op, e1: ne1, e2: ne2}}, nil
+	case LESS_THAN_OP:
+		return &generic{b: &lessThanExpression{e1: ne1, e2: ne2}}, nil
+	case LESS_THAN_OR_EQUAL_OP:
+		return &generic{b: &lessThanOrEqualExpression{e1: ne1, e2: ne2}}, nil
+	case GREATER_THAN_OP:
+		return &generic{b: &greaterThanExpression{e1: ne1, e2: ne2}}, nil
+	case GREATER_THAN_OR_EQUAL_OP:
+		return &generic{b: &greaterThanOrEqualExpression{e1: ne1, e2: ne2}}, nil
+	}
+	panic(fmt.Sprintf("unexpected binary operator %s", op))
+}
+
+// isComparison reports whether tokenType is one of the ordering operators,
+// which compare numbers or strings.
+func isComparison(tokenType TokenType) bool {
+	switch tokenType {
+	case LESS_THAN_OP, LESS_THAN_OR_EQUAL_OP, GREATER_THAN_OP, GREATER_THAN_OR_EQUAL_OP:
+		return true
+	}
+	return false
+}
+
+// equal builds an equality expression. If only one side is an untyped path it
+// takes the type of the other side, and if both sides are typed the types must
+// match.
+func equal(left, right Expression) (Expression, error) {
+	k1, k2 := KindOf(left), KindOf(right)
+	switch {
+	case k1 == AnyKind && k2 != AnyKind:
+		typed, err := as(left, k2)
+		if err != nil {
+			return nil, err
+		}
+		left = typed
+	case k2 == AnyKind && k1 != AnyKind:
+		typed, err := as(right, k1)
+		if err != nil {
+			return nil, err
+		}
+		right = typed
+	case k1 != k2:
+		return nil, errorf(spanOf(left, right), "cannot compare %s with %s", k1, k2)
+	}
+	if k1 == ArrayKind || k2 == ArrayKind {
+		return nil, errorf(spanOf(left, right), "cannot compare arrays")
+	}
+	return &generic{b: &equalExpression{e1: left, e2: right}}, nil
+}
+
+func isFunction(tokenType TokenType) bool {
+	switch tokenType {
+	case SUM_WORD, PRODUCT_WORD, MIN_WORD, MAX_WORD, AVG_WORD, AND_WORD, OR_WORD, LENGTH_WORD,
+		CONCAT_WORD, UPPER_WORD, LOWER_WORD, TRIM_WORD, SUBSTRING_WORD,
+		STARTS_WITH_WORD, ENDS_WITH_WORD, CONTAINS_WORD, REPLACE_WORD, SPLIT_WORD, JOIN_WORD, MATCHES_WORD, IF_WORD:
+		return true
+	}
+	return isArrayFunction(tokenType) || isMathFunction(tokenType)
+}
+
+// isArrayFunction reports whether tokenType is a function that evaluates its
+// second argument for each element of its first.
+func isArrayFunction(tokenType TokenType) bool {
+	switch tokenType {
+	case ANY_WORD, ALL_WORD, NONE_WORD, COUNT_WORD, FILTER_WORD, MAP_WORD:
+		return true
+	}
+	return false
+}
+
+// call builds a call of the function fn, whose source spans span.
+func call(fn TokenType, args []Expression, span Span) (Expression, error) {
+	arity := func(min, max int) error {
+		if len(args) >= min && len(args) <= max {
+			return nil
+		}
+		want := strconv.Itoa(min)
+		if max > min {
+			want += " or " + strconv.Itoa(max)
+		}
+		plural := "s"
+		if want == "1" {
+			plural = ""
+		}
+		return errorf(span, "%s takes %s argument%s, got %d", source(fn), want, plural, len(args))
+	}
+
+	if len(args) == 0 {
+		return nil, errorf(span, "%s takes at least 1 argument", source(fn))
+	}
+
+	if isMathFunction(fn) {
+		if err := arity(mathArity(fn)); err != nil {
+			return nil, err
+		}
+		return mathOf(fn, args)
+	}
+
+	switch fn {
+	case SUM_WORD, PRODUCT_WORD, MIN_WORD, MAX_WORD, AVG_WORD:
+		return aggregateOf(fn, args)
+	case AND_WORD, OR_WORD:
+		subExpressions, err := asBooleans(args)
+		if err != nil {
+			return nil, err
+		}
+		if fn == AND_WORD {
+			return &generic{b: &andExpression{subExpressions: subExpressions}}, nil
+		}
+		return &generic{b: &orExpression{subExpressions: subExpressions}}, nil
+	case COUNT_WORD:
+		if err := arity(1, 2); err != nil {
+			return nil, err
+		}
+		if len(args) == 2 {
+			return arrayFunction(fn, args[0], args[1])
+		}
+		ae, err := asArray(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &generic{n: &arrayAggregateExpression{fn: fn, ae: ae}}, nil
+	case ANY_WORD, ALL_WORD, NONE_WORD, FILTER_WORD, MAP_WORD:
+		if err := arity(2, 2); err != nil {
+			return nil, err
+		}
+		return arrayFunction(fn, args[0], args[1])
+	case IF_WORD:
+		if err := arity(3, 3); err != nil {
+			return nil, err
+		}
+		return conditional(args[0], args[1], args[2])
+	case CONCAT_WORD:
+		subExpressions, err := asStrings(args)
+		if err != nil {
+			return nil, err
+		}
+		return &generic{s: &concatExpression{subExpressions: subExpressions}}, nil
+	case LENGTH_WORD:
+		if err := arity(1, 1); err != nil {
+			return nil, err
+		}
+		if gp, ok := args[0].(*genericPath); ok {
+			// it's only known whether the path is an array or a string once
+			// it's evaluated
+			return &generic{n: &pathLengthExpression{path: gp.path, pathSpan: gp.span}}, nil
+		}
+		if KindOf(args[0]) == StringKind {
+			se, _ := asString(args[0])
+			return &generic{n: &strLengthExpression{se: se}}, nil
+		}
+		ae, err := asArray(args[0])
+		if err != nil {
+			return nil, errorf(args[0].Span(), "expected array or string expression, got %s", KindOf(args[0]))
+		}
+		return &generic{n: &lengthExpression{ae: ae}}, nil
+	case JOIN_WORD:
+		if err := arity(2, 2); err != nil {
+			return nil, err
+		}
+		ae, err := asArray(args[0])
+		if err != nil {
+			return nil, err
+		}
+		sep, err := asString(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &generic{s: &joinExpression{ae: ae, sep: sep}}, nil
+	case MATCHES_WORD:
+		if err := arity(2, 2); err != nil {
+			return nil, err
+		}
+		return match(args[0], args[1])
+	case SUBSTRING_WORD:
+		if err := arity(2, 3); err != nil {
+			return nil, err
+		}
+		se, err := asString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		indexes, err := asNumbers(args[1:])
+		if err != nil {
+			return nil, err
+		}
+		e := &substringExpression{se: se, start: indexes[0]}
+		if len(indexes) == 2 {
+			e.end = indexes[1]
+		}
+		return &generic{s: e}, nil
+	}
+
+	// the rest take only strings
+	var ses []StringExpression
+	var err error
+	switch fn {
+	case UPPER_WORD, LOWER_WORD, TRIM_WORD:
+		err = arity(1, 1)
+	case STARTS_WITH_WORD, ENDS_WITH_WORD, CONTAINS_WORD, SPLIT_WORD:
+		err = arity(2, 2)
+	case REPLACE_WORD:
+		err = arity(3, 3)
+	default:
+		panic(fmt.Sprintf("unexpected function %s", fn))
+	}
+	if err == nil {
+		ses, err = asStrings(args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch fn {
+	case UPPER_WORD:
+		return &generic{s: &upperExpression{se: ses[0]}}, nil
+	case LOWER_WORD:
+		return &generic{s: &lowerExpression{se: ses[0]}}, nil
+	case TRIM_WORD:
+		return &generic{s: &trimExpression{se: ses[0]}}, nil
+	case STARTS_WITH_WORD:
+		return &generic{b: &startsWithExpression{se: ses[0], prefix: ses[1]}}, nil
+	case ENDS_WITH_WORD:
+		return &generic{b: &endsWithExpression{se: ses[0], suffix: ses[1]}}, nil
+	case CONTAINS_WORD:
+		return &generic{b: &containsExpression{se: ses[0], substr: ses[1]}}, nil
+	case SPLIT_WORD:
+		return &generic{a: &splitExpression{se: ses[0], sep: ses[1]}}, nil
+	}
+	return &generic{s: &replaceExpression{se: ses[0], old: ses[1], new: ses[2]}}, nil
+}
+
+// filters parses the predicates of the Filter segments of path in place, with
+// the element being filtered in scope as @.
+func (p *parser) filters(path []interface{}, span Span) error {
+	for i, segment := range path {
+		tokens, ok := segment.(filterTokens)
+		if !ok {
+			continue
+		}
+		eof := span.End
+		if len(tokens) > 0 {
+			eof = tokens[len(tokens)-1].Span.End
+		}
+		sub := &parser{
+			iter:   &tokenIterator{tokens: tokens},
+			eof:    eof,
+			scopes: append(p.scopes[:len(p.scopes):len(p.scopes)], ""),
+		}
+		e, err := sub.parseExpr()
+		if err != nil {
+			return err
+		}
+		if next, ok := sub.iter.peek(); ok {
+			return errorf(next.Span, "unexpected token %s", next.Type)
+		}
+		pred, err := asBoolean(e)
+		if err != nil {
+			return err
+		}
+		path[i] = Filter{pred: pred}
+	}
+	return nil
+}
+
+// aggregateOf builds a call of an aggregate function, which takes either
+// numbers or a single array of numbers. A single untyped path could be either
+// until it's evaluated.
+func aggregateOf(fn TokenType, args []Expression) (Expression, error) {
+	if len(args) == 1 {
+		if gp, ok := args[0].(*genericPath); ok {
+			return &generic{n: &pathAggregateExpression{fn: fn, path: gp.path, pathSpan: gp.span}}, nil
+		}
+		if KindOf(args[0]) == ArrayKind {
+			ae, err := asNumberArray(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &generic{n: &arrayAggregateExpression{fn: fn, ae: ae}}, nil
+		}
+	}
+
+	subExpressions, err := asNumbers(args)
+	if err != nil {
+		return nil, err
+	}
+	switch fn {
+	case SUM_WORD:
+		return &generic{n: &sumExpression{subExpressions: subExpressions}}, nil
+	case PRODUCT_WORD:
+		return &generic{n: &timesExpression{subExpressions: subExpressions}}, nil
+	}
+	return &generic{n: &aggregateExpression{fn: fn, subExpressions: subExpressions}}, nil
+}
+
+// asNumberArray converts e, an array expression, to an array of numbers,
+// typing the elements of an array literal.
+func asNumberArray(e Expression) (ArrayExpression, error) {
+	ae, err := asArray(e)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := ae.(*array); ok {
+		for i, elem := range a.elems {
+			if a.elems[i], err = as(elem, NumberKind); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	if k := elemKind(ae); k != AnyKind && k != NumberKind {
+		return nil, errorf(e.Span(), "expected array of number, got array of %s", k)
+	}
+	return ae, nil
+}
+
+// arrayFunction builds a call of an array function, whose second argument is
+// either a lambda or an expression using @ paths.
+func arrayFunction(fn TokenType, array, arg Expression) (Expression, error) {
+	ae, err := asArray(array)
+	if err != nil {
+		return nil, err
+	}
+	param, body := "", arg
+	if l, ok := arg.(*lambda); ok {
+		param, body = l.param, l.body
+	}
+	if fn == MAP_WORD {
+		return &generic{a: &mapExpression{ae: ae, param: param, body: body}}, nil
+	}
+
+	pred, err := asBoolean(body)
+	if err != nil {
+		return nil, err
+	}
+	switch fn {
+	case ANY_WORD:
+		return &generic{b: &anyExpression{ae: ae, param: param, pred: pred}}, nil
+	case ALL_WORD:
+		return &generic{b: &allExpression{ae: ae, param: param, pred: pred}}, nil
+	case NONE_WORD:
+		return &generic{b: &noneExpression{ae: ae, param: param, pred: pred}}, nil
+	case COUNT_WORD:
+		return &generic{n: &countExpression{ae: ae, param: param, pred: pred}}, nil
+	}
+	return &generic{a: &filterExpression{ae: ae, param: param, pred: pred}}, nil
+}
+
+// conditional builds an if expression. If only one branch is untyped it takes
+// the type of the other, and if both are typed the types must match. Neither
+// can be null.
+func conditional(c, then, els Expression) (Expression, error) {
+	cond, err := asBoolean(c)
+	if err != nil {
+		return nil, err
+	}
+	k1, k2 := KindOf(then), KindOf(els)
+	switch {
+	case k1 == NullKind:
+		return nil, errorf(then.Span(), "an if branch can't be null")
+	case k2 == NullKind:
+		return nil, errorf(els.Span(), "an if branch can't be null")
+	case k1 == AnyKind && k2 == AnyKind:
+		return &genericIfExpression{cond: cond, then: then, els: els}, nil
+	case k1 == AnyKind:
+		k1 = k2
+	case k2 != AnyKind && k1 != k2:
+		return nil, errorf(els.Span(), "if branches must both be %s, got %s", k1, k2)
+	}
+	return as(&genericIfExpression{cond: cond, then: then, els: els}, k1)
+}
+
+// match builds a regular expression match. A literal pattern is compiled
+// here, so an invalid one is an error.
+func match(left, right Expression) (Expression, error) {
+	se, err := asString(left)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := asString(right)
+	if err != nil {
+		return nil, err
+	}
+	e := &matchExpression{se: se, pattern: pattern}
+	if lit, ok := pattern.(*str); ok {
+		re, err := regexp.Compile(lit.s)
+		if err != nil {
+			return nil, errorf(lit.span, "invalid pattern: %s", err)
+		}
+		e.re = re
+	}
+	return &generic{b: e}, nil
+}
+
+// in builds an 'in' expression. Like equal, an untyped path on one side takes
+// the type of the other side, where the type of an array literal is the type
+// of its elements.
+func in(left, right Expression) (Expression, error) {
+	ae, err := asArray(right)
+	if err != nil {
+		return nil, err
+	}
+	k1, k2 := KindOf(left), elemKind(ae)
+	if k1 == ArrayKind || k2 == ArrayKind {
+		return nil, errorf(spanOf(left, right), "cannot compare arrays")
+	}
+	switch {
+	case k1 == AnyKind && k2 != AnyKind:
+		typed, err := as(left, k2)
+		if err != nil {
+			return nil, err
+		}
+		left = typed
+	case k2 == AnyKind && k1 != AnyKind:
+		if a, ok := ae.(*array); ok {
+			for i, elem := range a.elems {
+				typed, err := as(elem, k1)
+				if err != nil {
+					return nil, err
+				}
+				a.elems[i] = typed
+			}
+		}
+	case k1 != k2:
+		return nil, errorf(spanOf(left, right), "cannot compare %s with %s", k1, k2)
+	}
+	return &generic{b: &inExpression{node: node{span: spanOf(left, right)}, e: left, ae: ae}}, nil
+}
+
+// arrayOf builds an array literal. Untyped paths take the type of the other
+// elements, which must all be of the same type.
+func arrayOf(elems []Expression) (Expression, error) {
+	kind := AnyKind
+	for _, elem := range elems {
+		switch k := KindOf(elem); {
+		case k == NullKind:
+			return nil, errorf(elem.Span(), "array elements can't be null")
+		case k == AnyKind:
+		case kind == AnyKind:
+			kind = k
+		case k != kind:
+			return nil, errorf(elem.Span(), "array elements must all be %s, got %s", kind, k)
+		}
+	}
+	for i, elem := range elems {
+		typed, err := as(elem, kind)
+		if err != nil {
+			return nil, err
+		}
+		elems[i] = typed
+	}
+	return &generic{a: &array{elems: elems}}, nil
+}
+
+// elemKind returns the kind of the elements of ae, or AnyKind if they can be
+// of any kind.
+func elemKind(ae ArrayExpression) Kind {
+	switch ae := ae.(type) {
+	case *array:
+		if len(ae.elems) > 0 {
+			return KindOf(ae.elems[0])
+		}
+	case *splitExpression:
+		return StringKind
+	case *filterExpression:
+		return elemKind(ae.ae)
+	case *mapExpression:
+		return KindOf(ae.body)
+	}
+	return AnyKind
+}
+
+// withDefault builds a path expression with a default value, taking the type
+// of the default value.
+func withDefault(path []interface{}, defaultValue Expression) (Expression, error) {
+	switch KindOf(defaultValue) {
+	case NumberKind:
+		ne, _ := asNumber(defaultValue)
+		return &generic{n: &numberPathWithDefault{path: path, defaultValue: ne}}, nil
+	case BooleanKind:
+		be, _ := asBoolean(defaultValue)
+		return &generic{b: &booleanPathWithDefault{path: path, defaultValue: be}}, nil
+	case StringKind:
+		se, _ := asString(defaultValue)
+		return &generic{s: &strPathWithDefault{path: path, defaultValue: se}}, nil
+	case ArrayKind:
+		ae, _ := asArray(defaultValue)
+		return &generic{a: &arrayPathWithDefault{path: path, defaultValue: ae}}, nil
+	}
+	return &genericPathWithDefault{path: path, defaultValue: defaultValue}, nil
+}
+
+func spanOf(first, last Expression) Span {
+	return Span{Start: first.Span().Start, End: last.Span().End}
+}
+
+// KindOf returns the kind of value e evaluates to.
+func KindOf(e Expression) Kind {
+	if _, ok := e.(*null); ok {
+		return NullKind
+	}
+	if g, ok := e.(*generic); ok {
+		switch {
+		case g.n != nil:
+			return NumberKind
+		case g.b != nil:
+			return BooleanKind
+		case g.s != nil:
+			return StringKind
+		case g.a != nil:
+			return ArrayKind
+		}
+	}
+	return AnyKind
+}
+
+// as converts e to an expression of kind k, typing any untyped paths.
+func as(e Expression, k Kind) (Expression, error) {
+	switch k {
+	case NumberKind:
+		ne, err := asNumber(e)
+		return &generic{n: ne}, err
+	case BooleanKind:
+		be, err := asBoolean(e)
+		return &generic{b: be}, err
+	case StringKind:
+		se, err := asString(e)
+		return &generic{s: se}, err
+	case ArrayKind:
+		ae, err := asArray(e)
+		return &generic{a: ae}, err
+	}
+	return e, nil
+}
+
+func asNumber(e Expression) (NumberExpression, error) {
+	switch e := e.(type) {
+	case *generic:
+		if e.n != nil {
+			return e.n, nil
+		}
+	case *genericPath:
+		return &numberPath{node: e.node, path: e.path}, nil
+	case *genericPathWithDefault:
+		defaultValue, err := asNumber(e.defaultValue)
+		if err != nil {
+			return nil, err
+		}
+		return &numberPathWithDefault{node: e.node, path: e.path, defaultValue: defaultValue}, nil
+	case *genericIfExpression:
+		then, err := asNumber(e.then)
+		if err != nil {
+			return nil, err
+		}
+		els, err := asNumber(e.els)
+		if err != nil {
+			return nil, err
+		}
+		return &numberIfExpression{node: e.node, cond: e.cond, then: then, els: els}, nil
+	}
+	return nil, errorf(e.Span(), "expected number expression, got %s", KindOf(e))
+}
+
+func asBoolean(e Expression) (BooleanExpression, error) {
+	switch e := e.(type) {
+	case *generic:
+		if e.b != nil {
+			return e.b, nil
+		}
+	case *genericPath:
+		return &booleanPath{node: e.node, path: e.path}, nil
+	case *genericPathWithDefault:
+		defaultValue, err := asBoolean(e.defaultValue)
+		if err != nil {
+			return nil, err
+		}
+		return &booleanPathWithDefault{node: e.node, path: e.path, defaultValue: defaultValue}, nil
+	case *genericIfExpression:
+		then, err := asBoolean(e.then)
+		if err != nil {
+			return nil, err
+		}
+		els, err := asBoolean(e.els)
+		if err != nil {
+			return nil, err
+		}
+		return &booleanIfExpression{node: e.node, cond: e.cond, then: then, els: els}, nil
+	}
+	return nil, errorf(e.Span(), "expected boolean expression, got %s", KindOf(e))
+}
+
+func asString(e Expression) (StringExpression, error) {
+	switch e := e.(type) {
+	case *generic:
+		if e.s != nil {
+			return e.s, nil
+		}
+	case *genericPath:
+		return &strPath{node: e.node, path: e.path}, nil
+	case *genericPathWithDefault:
+		defaultValue, err := asString(e.defaultValue)
+		if err != nil {
+			return nil, err
+		}
+		return &strPathWithDefault{node: e.node, path: e.path, defaultValue: defaultValue}, nil
+	case *genericIfExpression:
+		then, err := asString(e.then)
+		if err != nil {
+			return nil, err
+		}
+		els, err := asString(e.els)
+		if err != nil {
+			return nil, err
+		}
+		return &strIfExpression{node: e.node, cond: e.cond, then: then, els: els}, nil
+	}
+	return nil, errorf(e.Span(), "expected string expression, got %s", KindOf(e))
+}
+
+func asArray(e Expression) (ArrayExpression, error) {
+	switch e := e.(type) {
+	case *generic:
+		if e.a != nil {
+			return e.a, nil
+		}
+	case *genericPath:
+		return &arrayPath{node: e.node, path: e.path}, nil
+	case *genericPathWithDefault:
+		defaultValue, err := asArray(e.defaultValue)
+		if err != nil {
+			return nil, err
+		}
+		return &arrayPathWithDefault{node: e.node, path: e.path, defaultValue: defaultValue}, nil
+	case *genericIfExpression:
+		then, err := asArray(e.then)
+		if err != nil {
+			return nil, err
+		}
+		els, err := asArray(e.els)
+		if err != nil {
+			return nil, err
+		}
+		return &arrayIfExpression{node: e.node, cond: e.cond, then: then, els: els}, nil
+	}
+	return nil, errorf(e.Span(), "expected array expression, got %s", KindOf(e))
+}
+
+func asStrings(es []Expression) ([]StringExpression, error) {
+	ses := make([]StringExpression, len(es))
+	for i, e := range es {
+		se, err := asString(e)
+		if err != nil {
+			return nil, err
+		}
+		ses[i] = se
+	}
+	return ses, nil
+}
+
+func asNumbers(es []Expression) ([]NumberExpression, error) {
+	nes := make([]NumberExpression, len(es))
+	for i, e := range es {
+		ne, err := asNumber(e)
+		if err != nil {
+			return nil, err
+		}
+		nes[i] = ne
+	}
+	return nes, nil
+}
+
+func asBooleans(es []Expression) ([]BooleanExpression, error) {
+	bes := make([]BooleanExpression, len(es))
+	for i, e := range es {
+		be, err := asBoolean(e)
+		if err != nil {
+			return nil, err
+		}
+		bes[i] = be
+	}
+	return bes, nil
 }
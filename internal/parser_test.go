@@ -0,0 +1,835 @@
+package internal_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func parse(t *testing.T, expression string) (internal.Expression, error) {
+	t.Helper()
+	tokens, err := internal.Lex(expression)
+	if err != nil {
+		t.Fatalf("got unexpected lex error: %s", err)
+	}
+	return internal.Parse(tokens)
+}
+
+func Test_Parse(t *testing.T) {
+	data := internal.NewJSONParser(map[string]interface{}{
+		"num":   float64(4),
+		"neg":   float64(-2),
+		"yes":   true,
+		"no":    false,
+		"name":  "dan",
+		"null":  nil,
+		"arr":   []interface{}{float64(1), float64(2), float64(3)},
+		"inner": map[string]interface{}{"key": "value", "list": []interface{}{"a", "b"}},
+		"items": []interface{}{
+			map[string]interface{}{"price": float64(5), "tags": []interface{}{"a"}},
+			map[string]interface{}{"price": float64(12), "tags": []interface{}{"b", "c"}},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		expression string
+		value      interface{}
+		errMsg     string
+	}{
+		{
+			name:       "number",
+			expression: "42.5",
+			value:      42.5,
+		},
+		{
+			name:       "boolean",
+			expression: "true",
+			value:      true,
+		},
+		{
+			name:       "string",
+			expression: "'hello'",
+			value:      "hello",
+		},
+		{
+			name:       "bare path",
+			expression: "$.inner['key']",
+			value:      "value",
+		},
+		{
+			name:       "bare path to array",
+			expression: "$.inner.list",
+			value:      []interface{}{"a", "b"},
+		},
+		{
+			name:       "missing bare path",
+			expression: "$.missing",
+			value:      nil,
+		},
+		{
+			name:       "path with default found",
+			expression: "$.num ? 10",
+			value:      float64(4),
+		},
+		{
+			name:       "path with default not found",
+			expression: "$.missing ? 10",
+			value:      float64(10),
+		},
+		{
+			name:       "path with path default",
+			expression: "$.missing ? $.name",
+			value:      "dan",
+		},
+		{
+			name:       "path with string default",
+			expression: "$.missing ? 'x'",
+			value:      "x",
+		},
+		{
+			name:       "invert",
+			expression: "-(2 + 3)",
+			value:      float64(-5),
+		},
+		{
+			name:       "invert path",
+			expression: "-$.num",
+			value:      float64(-4),
+		},
+		{
+			name:       "addition",
+			expression: "$.num + 1.5",
+			value:      5.5,
+		},
+		{
+			name:       "subtraction without spaces",
+			expression: "$.num-1",
+			value:      float64(3),
+		},
+		{
+			name:       "subtraction of negative number",
+			expression: "$.num - -1",
+			value:      float64(5),
+		},
+		{
+			name:       "multiplication",
+			expression: "$.num * $.neg",
+			value:      float64(-8),
+		},
+		{
+			name:       "division",
+			expression: "$.num / 8",
+			value:      0.5,
+		},
+		{
+			name:       "nested arithmetic",
+			expression: "(($.num + 2) * 3) / (1 - 3)",
+			value:      float64(-9),
+		},
+		{
+			name:       "sum and product",
+			expression: "sum(1, $.num, product(2, 3, $.neg))",
+			value:      float64(-7),
+		},
+		{
+			name:       "length",
+			expression: "length($.arr) + length($.inner.list)",
+			value:      float64(5),
+		},
+		{
+			name:       "not",
+			expression: "!$.no",
+			value:      true,
+		},
+		{
+			name:       "not with default",
+			expression: "!($.missing ? true)",
+			value:      false,
+		},
+		{
+			name:       "comparisons",
+			expression: "and(1 < 2, 2 <= 2, $.num > 3, $.num >= 5)",
+			value:      false,
+		},
+		{
+			name:       "string equality",
+			expression: "$.name == 'dan'",
+			value:      true,
+		},
+		{
+			name:       "number equality",
+			expression: "4 == $.num",
+			value:      true,
+		},
+		{
+			name:       "boolean equality",
+			expression: "$.yes == $.no",
+			value:      false,
+		},
+		{
+			name:       "and or",
+			expression: "($.yes && $.no) || or($.no, true)",
+			value:      true,
+		},
+		{
+			name:       "complicated expression",
+			expression: "!($.missing ? true) || ((length($.arr) + length($.inner.list)) < 3)",
+			value:      false,
+		},
+		{
+			name:       "empty",
+			expression: "",
+			errMsg:     "unexpected end of input",
+		},
+		{
+			name:       "unclosed paren",
+			expression: "(1 + 2",
+			errMsg:     "unexpected end of input",
+		},
+		{
+			name:       "precedence",
+			expression: "1 + 2 * 3 - $.num",
+			value:      float64(3),
+		},
+		{
+			name:       "left associative",
+			expression: "8 - 4 - 2",
+			value:      float64(2),
+		},
+		{
+			name:       "left associative division",
+			expression: "$.num / 2 / 2",
+			value:      float64(1),
+		},
+		{
+			name:       "unary binds tightest",
+			expression: "-$.num * 2 + 10",
+			value:      float64(2),
+		},
+		{
+			name:       "not binds tightest",
+			expression: "!$.no == false",
+			value:      false,
+		},
+		{
+			name:       "parentheses override precedence",
+			expression: "(1 + 2) * 3",
+			value:      float64(9),
+		},
+		{
+			name:       "comparisons before equality",
+			expression: "$.num > 1 == $.num < 1",
+			value:      false,
+		},
+		{
+			name:       "logic chain",
+			expression: "$.num > 1 && $.yes && $.name == 'dan' || $.no",
+			value:      true,
+		},
+		{
+			name:       "and before or",
+			expression: "$.yes || $.no && $.no",
+			value:      true,
+		},
+		{
+			name:       "default binds tightest",
+			expression: "$.missing ? 2 * 3",
+			value:      float64(6),
+		},
+		{
+			name:       "string functions",
+			expression: "concat(upper($.name), '-', lower('ABC'), trim('  x  '), substring($.name, 1), substring('hello', 1, 3))",
+			value:      "DAN-abcxanel",
+		},
+		{
+			name:       "substring out of range",
+			expression: "concat(substring('héllo', -2, 2), substring($.name, 2, 1), substring($.name, 5))",
+			value:      "hé",
+		},
+		{
+			name:       "string predicates",
+			expression: "startsWith($.name, 'd') && endsWith($.name, 'an') && contains($.inner.key, 'alu') && !contains($.name, 'x')",
+			value:      true,
+		},
+		{
+			name:       "replace",
+			expression: "replace('a-b-c', '-', $.name)",
+			value:      "adanbdanc",
+		},
+		{
+			name:       "split",
+			expression: "split($.inner.key, 'l')",
+			value:      []interface{}{"va", "ue"},
+		},
+		{
+			name:       "in split",
+			expression: "'b' in split('a,b', ',')",
+			value:      true,
+		},
+		{
+			name:       "join",
+			expression: "concat(join($.inner.list, ', '), ';', join($.arr, ''))",
+			value:      "a, b;123",
+		},
+		{
+			name:       "string length",
+			expression: "length('héllo') + length($.name) + length($.arr) + length(concat($.name, '!'))",
+			value:      float64(15),
+		},
+		{
+			name:       "any and all",
+			expression: "any($.items, @.price > 10) && all($.items, @.price > 1) && !all($.arr, @ > 1)",
+			value:      true,
+		},
+		{
+			name:       "none and count",
+			expression: "none($.items, @.price > 20) && count($.arr, @ >= 2) == 2 && count([], true) == 0",
+			value:      true,
+		},
+		{
+			name:       "filter and map",
+			expression: "map(filter($.items, @.price < $.num * 2), @.tags)",
+			value:      []interface{}{[]interface{}{"a"}},
+		},
+		{
+			name:       "map of computed array",
+			expression: "map(split('a-b', '-'), concat(@, $.name))",
+			value:      []interface{}{"adan", "bdan"},
+		},
+		{
+			name:       "nested array functions",
+			expression: "count($.items, any(@.tags, @ == 'c'))",
+			value:      float64(1),
+		},
+		{
+			name:       "lambda",
+			expression: "any($.items, @item => any(@item.tags, @tag => @tag == 'b' && @item.price > 10))",
+			value:      true,
+		},
+		{
+			name:       "element of filtered array",
+			expression: "any(filter($.items, @.price > 10), @.tags[1] == 'c')",
+			value:      true,
+		},
+		{
+			name:       "element path outside array function",
+			expression: "@.price > 1",
+			errMsg:     "@ can only be used in the predicate of an array function",
+		},
+		{
+			name:       "unbound lambda parameter",
+			expression: "any($.items, @x => any(@x.tags, @y == 'a'))",
+			errMsg:     "@y isn't the parameter of an enclosing lambda",
+		},
+		{
+			name:       "invalid lambda parameter",
+			expression: "any($.items, @x.a => true)",
+			errMsg:     "lambda parameter must be a name like @item, got @x.a",
+		},
+		{
+			name:       "array function predicate type",
+			expression: "any($.items, @.price)",
+			value:      false,
+		},
+		{
+			name:       "aggregates",
+			expression: "sum($.arr) == 6 && product($.arr) == 6 && min($.arr) == 1 && max(map($.items, @.price)) == 12 && avg($.num, 2) == 3",
+			value:      true,
+		},
+		{
+			name:       "aggregates of a number path",
+			expression: "sum($.num) + max($.num) + count($.arr)",
+			value:      float64(11),
+		},
+		{
+			name:       "empty aggregates",
+			expression: "[sum([]), product([]), count([])]",
+			value:      []interface{}{float64(0), float64(1), float64(0)},
+		},
+		{
+			name:       "aggregate skips non-numbers",
+			expression: "sum($.inner.list) + product($.inner.list)",
+			value:      float64(1),
+		},
+		{
+			name:       "wildcard path",
+			expression: "sum($.items[*].price) + length($.items[*].tags[*])",
+			value:      float64(20),
+		},
+		{
+			name:       "slice and negative index paths",
+			expression: "sum($.arr[1:]) == 5 && sum($.arr[:-1]) == 3 && $.arr[-1] == 3 && $.items[-1].tags[-2] == 'b'",
+			value:      true,
+		},
+		{
+			name:       "array function over wildcard path",
+			expression: "count($.items[*].tags, @x => any(@x, @ == 'c')) + count($.items[*].tags[*], @ != 'a')",
+			value:      float64(3),
+		},
+		{
+			name:       "filter path",
+			expression: "$.items[?(@.price > 10)].tags",
+			value:      []interface{}{[]interface{}{"b", "c"}},
+		},
+		{
+			name:       "filter path as a single value",
+			expression: "$.items[?(any(@.tags, @ == 'b'))].price + $.items[?(@.price < $.num)].price + $.items[?(false)].price",
+			value:      float64(12),
+		},
+		{
+			name:       "filter path in lambda",
+			expression: "map($.items, @item => length($.items[?(@.price >= @item.price)]))",
+			value:      []interface{}{float64(2), float64(1)},
+		},
+		{
+			name:       "null comparison",
+			expression: "$.null == null && null == $.null && $.missing != null && $.num != null && null == null",
+			value:      true,
+		},
+		{
+			name:       "null or missing comparison",
+			expression: "($.null ? null) == null && ($.missing ? null) == null && ($.num ? null) != null",
+			value:      true,
+		},
+		{
+			name:       "null in arithmetic",
+			expression: "$.null + ($.null ? 2) * 3",
+			value:      float64(6),
+		},
+		{
+			name:       "null takes default",
+			expression: "$.null ? $.name",
+			value:      "dan",
+		},
+		{
+			name:       "null literal",
+			expression: "null",
+			value:      nil,
+		},
+		{
+			name:       "null operand",
+			expression: "1 + null",
+			errMsg:     "expected number expression, got null",
+		},
+		{
+			name:       "null compared with number",
+			expression: "$.num * 2 == null",
+			errMsg:     "cannot compare number with null",
+		},
+		{
+			name:       "null array element",
+			expression: "$.name in ['dan', null]",
+			errMsg:     "array elements can't be null",
+		},
+		{
+			name:       "arithmetic operators",
+			expression: "[7 % 3, -7 % 3, 7 // 2, -7 // 2, 2 ** 3 ** 2, -2 ** 2, 2 * 3 ** 2, $.num ** 0.5 % 3, pow(2, -1)]",
+			value:      []interface{}{float64(1), float64(-1), float64(3), float64(-4), float64(512), float64(4), float64(18), float64(2), float64(0.5)},
+		},
+		{
+			name:       "math functions",
+			expression: "[abs($.neg), floor(-1.5), ceil(1.2), round(2.5), round(-2.5), round(2.345, 2), round(1.005, 2), round(-1.005, 2), round(1250, -2), sqrt(16), log(1), exp(0), clamp($.num, 0, 3), clamp($.neg, 0, 3)]",
+			value:      []interface{}{float64(2), float64(-2), float64(2), float64(3), float64(-3), float64(2.35), float64(1.01), float64(-1.01), float64(1300), float64(4), float64(0), float64(1), float64(3), float64(0)},
+		},
+		{
+			name:       "math domain errors",
+			expression: "sqrt(-1) != sqrt(-1) && log(0) < 0 && clamp(1, 2, 0) != clamp(1, 2, 0) && $.num % 0 != $.num % 0 && 1 // 0 > 0 && 0 ** -1 > 0 && (-8) ** 0.5 != (-8) ** 0.5",
+			value:      true,
+		},
+		{
+			name:       "math function arity",
+			expression: "round(1, 2, 3)",
+			errMsg:     "round takes 1 or 2 arguments, got 3",
+		},
+		{
+			name:       "math function argument type",
+			expression: "sqrt('x')",
+			errMsg:     "expected number expression",
+		},
+		{
+			name:       "arithmetic operand type",
+			expression: "'x' % 2",
+			errMsg:     "expected number expression",
+		},
+		{
+			name:       "recursive descent path",
+			expression: "sum($..price) + length($..tags[*]) + length($.inner..['list'][0])",
+			value:      float64(21),
+		},
+		{
+			name:       "recursive descent path used as string",
+			expression: "$..key == 'value'",
+			errMsg:     "cannot compare array with string",
+		},
+		{
+			name:       "filter predicate type",
+			expression: "$.items[?(@.price + 1)].tags",
+			errMsg:     "expected boolean expression",
+		},
+		{
+			name:       "filter predicate trailing tokens",
+			expression: "$.items[?(true 1)].tags",
+			errMsg:     "unexpected token number",
+		},
+		{
+			name:       "wildcard path used as number",
+			expression: "$.items[*].price > 1",
+			errMsg:     "expected number expression, got array",
+		},
+		{
+			name:       "wildcard path default",
+			expression: "$.items[*].price ? 1",
+			errMsg:     "expected array expression, got number",
+		},
+		{
+			name:       "aggregate element kind",
+			expression: "min(split($.name, ''))",
+			errMsg:     "expected array of number, got array of string",
+		},
+		{
+			name:       "aggregate literal element kind",
+			expression: "max([1, 'a'])",
+			errMsg:     "array elements must all be number, got string",
+		},
+		{
+			name:       "array function arguments",
+			expression: "any($.items)",
+			errMsg:     "any takes 2 arguments, got 1",
+		},
+		{
+			name:       "array function predicate kind",
+			expression: "all($.items, 1)",
+			errMsg:     "expected boolean expression, got number",
+		},
+		{
+			name:       "if",
+			expression: "if($.num > 3, $.num * 2, 0) + if($.no, 1, $.neg)",
+			value:      float64(6),
+		},
+		{
+			name:       "if branch takes type of other branch",
+			expression: "concat(if($.yes, $.name, 'x'), if(!$.yes, 'y', $.inner.key))",
+			value:      "danvalue",
+		},
+		{
+			name:       "if of booleans and arrays",
+			expression: "if($.yes, $.no, true) || 2 in if($.no, [], $.arr)",
+			value:      true,
+		},
+		{
+			name:       "if of untyped branches",
+			expression: "if($.yes, $.inner.list, $.num)",
+			value:      []interface{}{"a", "b"},
+		},
+		{
+			name:       "if branch types",
+			expression: "if($.yes, 1, 'a')",
+			errMsg:     "if branches must both be number, got string",
+		},
+		{
+			name:       "null if branch",
+			expression: "if($.yes, null, 1)",
+			errMsg:     "an if branch can't be null",
+		},
+		{
+			name:       "null untyped if branch",
+			expression: "if($.yes, $.name, null)",
+			errMsg:     "an if branch can't be null",
+		},
+		{
+			name:       "if condition type",
+			expression: "if(1, 2, 3)",
+			errMsg:     "expected boolean expression, got number",
+		},
+		{
+			name:       "if arguments",
+			expression: "if($.yes, 1)",
+			errMsg:     "if takes 3 arguments, got 2",
+		},
+		{
+			name:       "not equal",
+			expression: "$.name != 'x' && $.num != 4 == false && true != $.inner.bool",
+			value:      true,
+		},
+		{
+			name:       "string comparisons",
+			expression: "'2024-01-31' < '2024-02-01' && $.name >= 'dan' && $.name > 'Dan' && 'b' <= $.inner.key && !($.name < 'd')",
+			value:      true,
+		},
+		{
+			name:       "string comparison argument type",
+			expression: "'a' < 1",
+			errMsg:     "expected string expression, got number",
+		},
+		{
+			name:       "mismatched inequality",
+			expression: "1 != 'a'",
+			errMsg:     "cannot compare number with string",
+		},
+		{
+			name:       "match",
+			expression: "$.name =~ '^d.n$' && !($.inner.key =~ '^v[0-9]') && matches(concat($.name, '1'), 'n[0-9]')",
+			value:      true,
+		},
+		{
+			name:       "match dynamic pattern",
+			expression: "matches($.inner.key, concat($.name, '|', 'lu'))",
+			value:      true,
+		},
+		{
+			name:       "match invalid dynamic pattern",
+			expression: "$.name =~ concat('(', $.name)",
+			value:      false,
+		},
+		{
+			name:       "invalid pattern",
+			expression: "$.name =~ 'a(b'",
+			errMsg:     "invalid pattern: error parsing regexp: missing closing ): `a(b`",
+		},
+		{
+			name:       "match argument type",
+			expression: "$.name =~ 1",
+			errMsg:     "expected string expression, got number",
+		},
+		{
+			name:       "string function with untyped path",
+			expression: "upper($.num)",
+			value:      "",
+		},
+		{
+			name:       "string function arguments",
+			expression: "replace($.name, 'a')",
+			errMsg:     "replace takes 3 arguments, got 2",
+		},
+		{
+			name:       "substring arguments",
+			expression: "substring($.name)",
+			errMsg:     "substring takes 2 or 3 arguments, got 1",
+		},
+		{
+			name:       "string function argument type",
+			expression: "startsWith($.name, 1)",
+			errMsg:     "expected string expression, got number",
+		},
+		{
+			name:       "array literal",
+			expression: "[1, $.num, 2 + 3]",
+			value:      []interface{}{float64(1), float64(4), float64(5)},
+		},
+		{
+			name:       "empty array literal",
+			expression: "length([])",
+			value:      float64(0),
+		},
+		{
+			name:       "in",
+			expression: "$.name in ['bob', 'dan']",
+			value:      true,
+		},
+		{
+			name:       "not in",
+			expression: "$.num not in [1, 2, 3]",
+			value:      true,
+		},
+		{
+			name:       "in with untyped paths",
+			expression: "$.name in [$.inner.key, $.missing]",
+			value:      false,
+		},
+		{
+			name:       "in array path",
+			expression: "'b' in $.inner.list && 1 in $.arr",
+			value:      true,
+		},
+		{
+			name:       "in precedence",
+			expression: "$.num - 2 in [2] == !$.no",
+			value:      true,
+		},
+		{
+			name:       "array default",
+			expression: "'x' in ($.missing ? ['x'])",
+			value:      true,
+		},
+		{
+			name:       "array default used as array",
+			expression: "length($.arr ? [])",
+			value:      float64(3),
+		},
+		{
+			name:       "mixed array literal",
+			expression: "[1, 'a']",
+			errMsg:     "array elements must all be number, got string",
+		},
+		{
+			name:       "in mismatched literal",
+			expression: "$.num + 1 in ['a']",
+			errMsg:     "cannot compare number with string",
+		},
+		{
+			name:       "in array",
+			expression: "[1] in [[1]]",
+			errMsg:     "cannot compare arrays",
+		},
+		{
+			name:       "not without in",
+			expression: "$.a not [1]",
+			errMsg:     "unexpected token '[', expected 'in'",
+		},
+		{
+			name:       "in non array",
+			expression: "1 in 1",
+			errMsg:     "expected array expression, got number",
+		},
+		{
+			name:       "chained comparison",
+			expression: "1 < 2 < 3",
+			errMsg:     "expected number expression, got boolean",
+		},
+		{
+			name:       "trailing token",
+			expression: "1 + 2 )",
+			errMsg:     "unexpected token ')'",
+		},
+		{
+			name:       "trailing operand",
+			expression: "1 2",
+			errMsg:     "unexpected token number",
+		},
+		{
+			name:       "operator without operand",
+			expression: "1 +",
+			errMsg:     "unexpected end of input",
+		},
+		{
+			name:       "default on non path",
+			expression: "1 ? 2",
+			errMsg:     "unexpected token '?'",
+		},
+		{
+			name:       "string in arithmetic",
+			expression: "$.num + 'a'",
+			errMsg:     "expected number expression, got string",
+		},
+		{
+			name:       "number in logic",
+			expression: "true && 1",
+			errMsg:     "expected boolean expression, got number",
+		},
+		{
+			name:       "mismatched equality",
+			expression: "1 == 'a'",
+			errMsg:     "cannot compare number with string",
+		},
+		{
+			name:       "length of number",
+			expression: "length(1 + 2)",
+			errMsg:     "expected array or string expression, got number",
+		},
+		{
+			name:       "length arguments",
+			expression: "length($.a, $.b)",
+			errMsg:     "length takes 1 argument, got 2",
+		},
+		{
+			name:       "missing function arguments",
+			expression: "sum()",
+			errMsg:     "unexpected token ')'",
+		},
+		{
+			name:       "function without parens",
+			expression: "sum 1",
+			errMsg:     "unexpected token number, expected '('",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := parse(t, test.expression)
+
+			if test.errMsg == "" && err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			} else if test.errMsg != "" && err == nil {
+				t.Fatalf("didn't get error, expected %s", test.errMsg)
+			} else if test.errMsg != "" && !strings.Contains(err.Error(), test.errMsg) {
+				t.Fatalf("error didn't contain wanted string %s: got %s", test.errMsg, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if value := expr.Value(data); !reflect.DeepEqual(value, test.value) {
+				t.Errorf("value didn't match expected, got %v want %v", value, test.value)
+			}
+			if value := expr.Reduce().Value(data); !reflect.DeepEqual(value, test.value) {
+				t.Errorf("reduced value didn't match expected, got %v want %v", value, test.value)
+			}
+		})
+	}
+}
+
+func Test_Parse_Spans(t *testing.T) {
+	expr, err := parse(t, "  ($.a ? 1) + sum(2, 3)")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	want := internal.Span{
+		Start: internal.Position{Offset: 2, Line: 1, Column: 3},
+		End:   internal.Position{Offset: 23, Line: 1, Column: 24},
+	}
+	if expr.Span() != want {
+		t.Errorf("got span %v, want %v", expr.Span(), want)
+	}
+}
+
+func Test_Parse_ErrorPosition(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+		snippet    string
+	}{
+		{
+			expression: "1 + 'a'",
+			err:        "1:5: expected number expression, got string",
+			snippet:    "1 + 'a'\n    ^^^",
+		},
+		{
+			expression: "(1 + 2",
+			err:        "1:7: unexpected end of input",
+			snippet:    "(1 + 2\n      ^",
+		},
+		{
+			expression: "$.a == (1 == 'b')",
+			err:        "1:9: cannot compare number with string",
+			snippet:    "$.a == (1 == 'b')\n        ^^^^^^^^",
+		},
+		{
+			expression: "length($.a,\n  $.b)",
+			err:        "1:1: length takes 1 argument, got 2",
+			snippet:    "length($.a,\n^^^^^^^^^^^",
+		},
+		{
+			expression: "matches($.a, '[a-')",
+			err:        "1:14: invalid pattern: error parsing regexp: missing closing ]: `[a-`",
+			snippet:    "matches($.a, '[a-')\n             ^^^^^",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := parse(t, test.expression)
+			e, ok := err.(*internal.Error)
+			if !ok {
+				t.Fatalf("expected *internal.Error, got %v", err)
+			}
+			e.Source = test.expression
+			if e.Error() != test.err {
+				t.Errorf("got error %q, want %q", e.Error(), test.err)
+			}
+			if e.Snippet() != test.snippet {
+				t.Errorf("got snippet\n%s\nwant\n%s", e.Snippet(), test.snippet)
+			}
+		})
+	}
+}
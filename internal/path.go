@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Element is the first segment of a path relative to the element of an array
+// function, like @.price or @item.price. It's the name of the lambda parameter
+// the path refers to, or "" for the innermost element.
+type Element string
+
+// Wildcard is a path segment written [*], which selects every element of an
+// array.
+type Wildcard struct{}
+
+// Slice is a path segment written [start:end], which selects the elements of
+// an array from Start up to End, or the end of the array if HasEnd isn't set.
+// Negative bounds count back from the end of the array, and bounds out of
+// range are clamped, so the zero Slice, written [:], selects every element.
+type Slice struct {
+	Start  int
+	End    int
+	HasEnd bool
+}
+
+// Filter is a path segment written [?(pred)], which selects the elements of an
+// array for which pred is true, with the element as @. The predicate is
+// evaluated leniently, so an element it can't be evaluated against doesn't
+// match, even in strict mode.
+type Filter struct {
+	pred BooleanExpression
+}
+
+// Descendants is a path segment written .., which selects the value it's
+// applied to and every value nested in it, at any depth up to a limit, in
+// document order. It's followed by the segment to select from each of them,
+// so $..id is every id at any depth.
+type Descendants struct{}
+
+// filterTokens is a Filter segment as it's lexed, which the parser turns into
+// a Filter.
+type filterTokens []Token
+
+// String formats the path as it would be written in an expression.
+func (p Path) String() string {
+	sb := strings.Builder{}
+	if elem, ok := p.element(); ok {
+		sb.WriteRune('@')
+		sb.WriteString(string(elem))
+		p = p[1:]
+	} else {
+		sb.WriteRune('$')
+	}
+	for i, segment := range p {
+		switch segment := segment.(type) {
+		case string:
+			if isID(segment) {
+				if i == 0 || p[i-1] != (Descendants{}) {
+					sb.WriteRune('.')
+				}
+				sb.WriteString(segment)
+			} else {
+				sb.WriteRune('[')
+				sb.WriteString(quote(segment))
+				sb.WriteRune(']')
+			}
+		case int:
+			sb.WriteRune('[')
+			sb.WriteString(strconv.Itoa(segment))
+			sb.WriteRune(']')
+		case Wildcard:
+			sb.WriteString("[*]")
+		case Descendants:
+			sb.WriteString("..")
+		case Slice:
+			sb.WriteRune('[')
+			if segment.Start != 0 {
+				sb.WriteString(strconv.Itoa(segment.Start))
+			}
+			sb.WriteRune(':')
+			if segment.HasEnd {
+				sb.WriteString(strconv.Itoa(segment.End))
+			}
+			sb.WriteRune(']')
+		case Filter:
+			sb.WriteString("[?(")
+			sb.WriteString(Print(segment.pred))
+			sb.WriteString(")]")
+		}
+	}
+	return sb.String()
+}
+
+// element returns the Element the path starts with, if it's relative to an
+// array element.
+func (p Path) element() (Element, bool) {
+	if len(p) == 0 {
+		return "", false
+	}
+	elem, ok := p[0].(Element)
+	return elem, ok
+}
+
+// fansOut reports whether the path has a Wildcard, Slice, Filter or
+// Descendants segment, so that it refers to an array of every value it
+// selects.
+func (p Path) fansOut() bool {
+	return p.fanOut() != -1
+}
+
+// fanOut returns the index of the first segment of the path that fans out, or
+// -1 if it doesn't have one.
+func (p Path) fanOut() int {
+	for i, segment := range p {
+		switch segment.(type) {
+		case Wildcard, Slice, Filter, Descendants:
+			return i
+		}
+	}
+	return -1
+}
+
+// isArray reports whether the path has a Wildcard, Slice or Descendants
+// segment. A path that only fans out through Filters can also be used as a
+// single value, the first one it selects.
+func (p Path) isArray() bool {
+	for _, segment := range p {
+		switch segment.(type) {
+		case Wildcard, Slice, Descendants:
+			return true
+		}
+	}
+	return false
+}
+
+// arrayIndex returns the index of element i of an array of length n, counting
+// back from the end if i is negative, and whether it's in range.
+func arrayIndex(i, n int) (int, bool) {
+	if i < 0 {
+		i += n
+	}
+	return i, i >= 0 && i < n
+}
+
+// indexes returns the indexes of the elements that segment, a Wildcard or
+// Slice, selects from an array of length n.
+func indexes(segment interface{}, n int) []int {
+	start, end := 0, n
+	if s, ok := segment.(Slice); ok {
+		start = clampIndex(s.Start, n)
+		if s.HasEnd {
+			end = clampIndex(s.End, n)
+		}
+	}
+	var indexes []int
+	for i := start; i < end; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// clampIndex returns the bound i of a Slice of an array of length n, counting
+// back from the end if it's negative, clamped to the array.
+func clampIndex(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// expand returns the paths of the values found at a path that fans out, with
+// the Wildcard and Slice segments replaced by the indexes they select, and the
+// values themselves. The PathParsers in this package resolve such paths with
+// it, so they only have to find the arrays and values at paths without them.
+// It returns false if an array the path fans out from isn't found at the top
+// level; values that aren't found below that are skipped.
+func expand(pp PathParser, path Path) ([]Path, []interface{}, bool) {
+	i := path.fanOut()
+	if i == -1 {
+		value, ok := pp.GetValue(path)
+		if !ok {
+			return nil, nil, false
+		}
+		return []Path{path}, []interface{}{value}, true
+	}
+
+	selected, ok := selectPaths(pp, path[:i:i], path[i])
+	if !ok {
+		return nil, nil, false
+	}
+	paths, values := []Path{}, []interface{}{}
+	for _, s := range selected {
+		elemPaths, elemValues, _ := expand(pp, append(s, path[i+1:]...))
+		paths = append(paths, elemPaths...)
+		values = append(values, elemValues...)
+	}
+	return paths, values, true
+}
+
+// selectPaths returns the paths of the values that segment, which fans out,
+// selects from the value at path.
+func selectPaths(pp PathParser, path Path, segment interface{}) ([]Path, bool) {
+	if _, ok := segment.(Descendants); ok {
+		return descendants(pp, path, maxDepthOf(pp))
+	}
+
+	a, ok := pp.GetArray(path)
+	if !ok {
+		return nil, false
+	}
+	var selected []int
+	if f, ok := segment.(Filter); ok {
+		selected = f.selects(pp, path, len(a))
+	} else {
+		selected = indexes(segment, len(a))
+	}
+	paths := make([]Path, len(selected))
+	for i, index := range selected {
+		paths[i] = append(path[:len(path):len(path)], index)
+	}
+	return paths, true
+}
+
+// selects returns the indexes of the elements of the array of length n at
+// path for which the predicate is true.
+func (f Filter) selects(pp PathParser, path Path, n int) []int {
+	var selected []int
+	for i := 0; i < n; i++ {
+		elem := &scope{parent: pp, atPath: true, elem: append(path[:len(path):len(path)], i)}
+		if f.pred.Value(elem) {
+			selected = append(selected, i)
+		}
+	}
+	return selected
+}
+
+// collect returns the array of the values found at a path that fans out.
+func collect(pp PathParser, path Path) ([]interface{}, bool) {
+	_, values, ok := expand(pp, path)
+	return values, ok
+}
+
+// scalarPath returns the path to look up for a single value at path, which for
+// a path that only fans out through Filters is the first value it selects. Any
+// other path that fans out doesn't have a single value.
+func scalarPath(pp PathParser, path Path) (Path, bool) {
+	if !path.fansOut() {
+		return path, true
+	}
+	if path.isArray() {
+		return nil, false
+	}
+	paths, _, _ := expand(pp, path)
+	if len(paths) == 0 {
+		return nil, false
+	}
+	return paths[0], true
+}
+
+// isID reports whether s can be written as an object key after a '.' in a
+// path.
+func isID(s string) bool {
+	for i, r := range s {
+		if i == 0 && !unicode.Is(idStart, r) || !unicode.Is(idRune, r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// quote formats s as a string literal, escaping quotes and backslashes.
+func quote(s string) string {
+	sb := strings.Builder{}
+	sb.WriteRune('\'')
+	for _, r := range s {
+		if r == '\'' || r == '\\' {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteRune('\'')
+	return sb.String()
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Position is a location in an expression's source. Offset is in bytes,
+	// Line and Column start at 1 and Column counts runes.
+	Position struct {
+		Offset int
+		Line   int
+		Column int
+	}
+
+	// Span is the part of an expression's source between Start and End.
+	Span struct {
+		Start Position
+		End   Position
+	}
+
+	// Error is an error located in an expression's source.
+	Error struct {
+		Msg  string
+		Span Span
+		// Source is the full expression, used by Snippet.
+		Source string
+	}
+)
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+func errorf(span Span, format string, args ...interface{}) *Error {
+	return &Error{Msg: fmt.Sprintf(format, args...), Span: span}
+}
+
+// Error returns the error's message prefixed with its position. Errors about
+// expressions that weren't parsed from source, like those decoded by
+// UnmarshalJSON, have no position.
+func (e *Error) Error() string {
+	if e.Span.Start.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.Span.Start, e.Msg)
+}
+
+// Snippet returns the source line the error starts on with carets under the
+// span of the error, i.e.
+//
+//	$.a + * 2
+//	      ^
+//
+// It returns an empty string if the error's Source isn't set.
+func (e *Error) Snippet() string {
+	if e.Source == "" || e.Span.Start.Line == 0 {
+		return ""
+	}
+	lines := strings.Split(e.Source, "\n")
+	if e.Span.Start.Line > len(lines) {
+		return ""
+	}
+	line := []rune(lines[e.Span.Start.Line-1])
+
+	start := e.Span.Start.Column - 1
+	if start > len(line) {
+		start = len(line)
+	}
+	end := start + 1
+	switch {
+	case e.Span.End.Line > e.Span.Start.Line:
+		// the span continues past this line
+		end = len(line)
+	case e.Span.End.Column-1 > end:
+		end = e.Span.End.Column - 1
+	}
+	if end > len(line) {
+		end = len(line)
+	}
+	if end <= start {
+		end = start + 1
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(string(line))
+	sb.WriteRune('\n')
+	for _, r := range line[:start] {
+		// keep tabs so the carets line up with the source
+		if r == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteRune(' ')
+		}
+	}
+	sb.WriteString(strings.Repeat("^", end-start))
+	return sb.String()
+}
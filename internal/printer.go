@@ -0,0 +1,352 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// unaryPrecedence is the precedence of '-' and '!', which bind tighter
+	// than any binary operator.
+	unaryPrecedence = 8
+	// operandPrecedence is the precedence of literals, paths and function
+	// calls, which never need parentheses.
+	operandPrecedence = 9
+)
+
+type printer struct {
+	sb strings.Builder
+}
+
+// Print formats e, which is an Expression or a typed expression, as source
+// that parses back to an equivalent expression. Parentheses are only written
+// where precedence requires them, and two argument sums, products, ands and
+// ors are written with their binary operators. Numbers that can't be written
+// as literals, like the result of folding an overflowing product, are written
+// as divisions by zero.
+func Print(e interface{}) string {
+	p := &printer{}
+	p.print(e, 0)
+	return p.sb.String()
+}
+
+// print writes e, wrapping it in parentheses if its precedence is lower than
+// min.
+func (p *printer) print(e interface{}, min int) {
+	if precedenceOf(e) < min {
+		p.sb.WriteRune('(')
+		p.write(e)
+		p.sb.WriteRune(')')
+		return
+	}
+	p.write(e)
+}
+
+func (p *printer) write(e interface{}) {
+	switch e := e.(type) {
+	case *generic:
+		p.write(e.typed())
+	case *genericPath:
+		p.sb.WriteString(Path(e.path).String())
+	case *genericPathWithDefault:
+		p.withDefault(e.path, e.defaultValue)
+	case *number:
+		if text := e.exactText(); text != "" {
+			p.sb.WriteString(text)
+			return
+		}
+		p.number(e.n)
+	case *numberPath:
+		p.sb.WriteString(Path(e.path).String())
+	case *numberPathWithDefault:
+		p.withDefault(e.path, e.defaultValue)
+	case *inverseExpression:
+		p.sb.WriteRune('-')
+		p.print(e.subExpression, unaryPrecedence)
+	case *sumExpression:
+		if len(e.subExpressions) == 2 {
+			p.binary(PLUS_OP, e.subExpressions[0], e.subExpressions[1])
+		} else {
+			p.call(SUM_WORD, e.subExpressions)
+		}
+	case *subtractExpression:
+		p.binary(MINUS, e.e1, e.e2)
+	case *timesExpression:
+		if len(e.subExpressions) == 2 {
+			p.binary(TIMES_OP, e.subExpressions[0], e.subExpressions[1])
+		} else {
+			p.call(PRODUCT_WORD, e.subExpressions)
+		}
+	case *divideExpression:
+		p.binary(DIVIDE_OP, e.e1, e.e2)
+	case *arithmeticExpression:
+		p.binary(e.op, e.e1, e.e2)
+	case *mathExpression:
+		p.call(e.fn, e.subExpressions)
+	case *lengthExpression:
+		p.call(LENGTH_WORD, []interface{}{e.ae})
+	case *aggregateExpression:
+		p.call(e.fn, e.subExpressions)
+	case *arrayAggregateExpression:
+		p.call(e.fn, []interface{}{e.ae})
+	case *pathAggregateExpression:
+		p.call(e.fn, []interface{}{&genericPath{path: e.path}})
+	case *boolean:
+		p.sb.WriteString(strconv.FormatBool(e.b))
+	case *booleanPath:
+		p.sb.WriteString(Path(e.path).String())
+	case *booleanPathWithDefault:
+		p.withDefault(e.path, e.defaultValue)
+	case *notExpression:
+		if ie, ok := e.subExpression.(*inExpression); ok {
+			prec := precedence(NOT_WORD)
+			p.print(ie.e, prec)
+			p.sb.WriteString(" not in ")
+			p.print(ie.ae, prec+1)
+			return
+		}
+		if ee, ok := e.subExpression.(*equalExpression); ok {
+			p.binary(NOT_EQUAL_OP, ee.e1, ee.e2)
+			return
+		}
+		p.sb.WriteRune('!')
+		p.print(e.subExpression, unaryPrecedence)
+	case *lessThanExpression:
+		p.binary(LESS_THAN_OP, e.e1, e.e2)
+	case *lessThanOrEqualExpression:
+		p.binary(LESS_THAN_OR_EQUAL_OP, e.e1, e.e2)
+	case *greaterThanExpression:
+		p.binary(GREATER_THAN_OP, e.e1, e.e2)
+	case *greaterThanOrEqualExpression:
+		p.binary(GREATER_THAN_OR_EQUAL_OP, e.e1, e.e2)
+	case *strCompareExpression:
+		p.binary(e.op, e.e1, e.e2)
+	case *equalExpression:
+		p.binary(EQUAL_OP, e.e1, e.e2)
+	case *andExpression:
+		if len(e.subExpressions) == 2 {
+			p.binary(AND_OP, e.subExpressions[0], e.subExpressions[1])
+		} else {
+			p.call(AND_WORD, e.subExpressions)
+		}
+	case *orExpression:
+		if len(e.subExpressions) == 2 {
+			p.binary(OR_OP, e.subExpressions[0], e.subExpressions[1])
+		} else {
+			p.call(OR_WORD, e.subExpressions)
+		}
+	case *str:
+		p.sb.WriteString(quote(e.s))
+	case *null:
+		p.sb.WriteString("null")
+	case *strPath:
+		p.sb.WriteString(Path(e.path).String())
+	case *strPathWithDefault:
+		p.withDefault(e.path, e.defaultValue)
+	case *arrayPath:
+		p.sb.WriteString(Path(e.path).String())
+	case *arrayPathWithDefault:
+		p.withDefault(e.path, e.defaultValue)
+	case *array:
+		p.sb.WriteRune('[')
+		for i, elem := range e.elems {
+			p.arg(i, elem)
+		}
+		p.sb.WriteRune(']')
+	case *inExpression:
+		p.binary(IN_WORD, e.e, e.ae)
+	case *concatExpression:
+		p.call(CONCAT_WORD, e.subExpressions)
+	case *upperExpression:
+		p.call(UPPER_WORD, []interface{}{e.se})
+	case *lowerExpression:
+		p.call(LOWER_WORD, []interface{}{e.se})
+	case *trimExpression:
+		p.call(TRIM_WORD, []interface{}{e.se})
+	case *substringExpression:
+		if e.end == nil {
+			p.call(SUBSTRING_WORD, []interface{}{e.se, e.start})
+		} else {
+			p.call(SUBSTRING_WORD, []interface{}{e.se, e.start, e.end})
+		}
+	case *startsWithExpression:
+		p.call(STARTS_WITH_WORD, []interface{}{e.se, e.prefix})
+	case *endsWithExpression:
+		p.call(ENDS_WITH_WORD, []interface{}{e.se, e.suffix})
+	case *containsExpression:
+		p.call(CONTAINS_WORD, []interface{}{e.se, e.substr})
+	case *replaceExpression:
+		p.call(REPLACE_WORD, []interface{}{e.se, e.old, e.new})
+	case *splitExpression:
+		p.call(SPLIT_WORD, []interface{}{e.se, e.sep})
+	case *joinExpression:
+		p.call(JOIN_WORD, []interface{}{e.ae, e.sep})
+	case *numberIfExpression:
+		p.call(IF_WORD, []interface{}{e.cond, e.then, e.els})
+	case *booleanIfExpression:
+		p.call(IF_WORD, []interface{}{e.cond, e.then, e.els})
+	case *strIfExpression:
+		p.call(IF_WORD, []interface{}{e.cond, e.then, e.els})
+	case *arrayIfExpression:
+		p.call(IF_WORD, []interface{}{e.cond, e.then, e.els})
+	case *genericIfExpression:
+		p.call(IF_WORD, []interface{}{e.cond, e.then, e.els})
+	case *anyExpression:
+		p.arrayFunction(ANY_WORD, e.ae, e.param, e.pred)
+	case *allExpression:
+		p.arrayFunction(ALL_WORD, e.ae, e.param, e.pred)
+	case *noneExpression:
+		p.arrayFunction(NONE_WORD, e.ae, e.param, e.pred)
+	case *countExpression:
+		p.arrayFunction(COUNT_WORD, e.ae, e.param, e.pred)
+	case *filterExpression:
+		p.arrayFunction(FILTER_WORD, e.ae, e.param, e.pred)
+	case *mapExpression:
+		p.arrayFunction(MAP_WORD, e.ae, e.param, e.body)
+	case *matchExpression:
+		p.binary(MATCH_OP, e.se, e.pattern)
+	case *strLengthExpression:
+		p.call(LENGTH_WORD, []interface{}{e.se})
+	case *pathLengthExpression:
+		p.call(LENGTH_WORD, []interface{}{&genericPath{path: e.path}})
+	default:
+		panic(fmt.Sprintf("print: unexpected expression %T", e))
+	}
+}
+
+// binary writes a binary operation, which is left associative unless it's
+// '**'.
+func (p *printer) binary(op TokenType, e1, e2 interface{}) {
+	prec := precedence(op)
+	if op == POWER_OP {
+		p.print(e1, prec+1)
+		p.sb.WriteString(" " + source(op) + " ")
+		p.print(e2, prec)
+		return
+	}
+	p.print(e1, prec)
+	p.sb.WriteString(" " + source(op) + " ")
+	p.print(e2, prec+1)
+}
+
+// call writes a function call.
+func (p *printer) call(fn TokenType, args interface{}) {
+	p.sb.WriteString(source(fn))
+	p.sb.WriteRune('(')
+	switch args := args.(type) {
+	case []NumberExpression:
+		for i, arg := range args {
+			p.arg(i, arg)
+		}
+	case []BooleanExpression:
+		for i, arg := range args {
+			p.arg(i, arg)
+		}
+	case []StringExpression:
+		for i, arg := range args {
+			p.arg(i, arg)
+		}
+	case []interface{}:
+		for i, arg := range args {
+			p.arg(i, arg)
+		}
+	}
+	p.sb.WriteRune(')')
+}
+
+// arrayFunction writes a call of an array function, writing its predicate as
+// a lambda if it names its parameter.
+func (p *printer) arrayFunction(fn TokenType, ae ArrayExpression, param string, pred interface{}) {
+	p.sb.WriteString(source(fn))
+	p.sb.WriteRune('(')
+	p.print(ae, 0)
+	p.sb.WriteString(", ")
+	if param != "" {
+		p.sb.WriteString("@" + param + " " + source(ARROW) + " ")
+	}
+	p.print(pred, 0)
+	p.sb.WriteRune(')')
+}
+
+func (p *printer) arg(i int, arg interface{}) {
+	if i > 0 {
+		p.sb.WriteString(", ")
+	}
+	p.print(arg, 0)
+}
+
+// withDefault writes a path with a default value. The default is parsed as a
+// single operand, so anything looser than a unary expression is parenthesized.
+func (p *printer) withDefault(path []interface{}, defaultValue interface{}) {
+	p.sb.WriteString(Path(path).String())
+	p.sb.WriteString(" ? ")
+	p.print(defaultValue, unaryPrecedence)
+}
+
+// number writes a number literal, which is always finite, since Reduce
+// doesn't fold anything that isn't.
+func (p *printer) number(n float64) {
+	p.sb.WriteString(strconv.FormatFloat(n, 'f', -1, 64))
+}
+
+// precedenceOf returns the precedence of the operator at the root of e, as
+// returned by precedence for binary operators.
+func precedenceOf(e interface{}) int {
+	switch e := e.(type) {
+	case *generic:
+		return precedenceOf(e.typed())
+	case *number:
+		if e.n < 0 {
+			return unaryPrecedence
+		}
+	case *inverseExpression:
+		return unaryPrecedence
+	case *notExpression:
+		switch e.subExpression.(type) {
+		case *inExpression:
+			return precedence(NOT_WORD)
+		case *equalExpression:
+			return precedence(NOT_EQUAL_OP)
+		}
+		return unaryPrecedence
+	case *sumExpression:
+		if len(e.subExpressions) == 2 {
+			return precedence(PLUS_OP)
+		}
+	case *subtractExpression:
+		return precedence(MINUS)
+	case *timesExpression:
+		if len(e.subExpressions) == 2 {
+			return precedence(TIMES_OP)
+		}
+	case *divideExpression:
+		return precedence(DIVIDE_OP)
+	case *arithmeticExpression:
+		return precedence(e.op)
+	case *lessThanExpression, *lessThanOrEqualExpression, *greaterThanExpression, *greaterThanOrEqualExpression:
+		return precedence(LESS_THAN_OP)
+	case *strCompareExpression:
+		return precedence(e.op)
+	case *inExpression:
+		return precedence(IN_WORD)
+	case *matchExpression:
+		return precedence(MATCH_OP)
+	case *equalExpression:
+		return precedence(EQUAL_OP)
+	case *andExpression:
+		if len(e.subExpressions) == 2 {
+			return precedence(AND_OP)
+		}
+	case *orExpression:
+		if len(e.subExpressions) == 2 {
+			return precedence(OR_OP)
+		}
+	}
+	return operandPrecedence
+}
+
+// source returns the source text of an operator or function name token.
+func source(t TokenType) string {
+	return strings.Trim(t.String(), "'")
+}
@@ -0,0 +1,156 @@
+package internal_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+func Test_Print(t *testing.T) {
+	data := internal.NewJSONParser(map[string]interface{}{
+		"num":  float64(4),
+		"yes":  true,
+		"name": "dan",
+		"arr":  []interface{}{float64(1), float64(2)},
+	})
+
+	tests := []struct {
+		name       string
+		expression string
+		printed    string
+		reduced    string
+	}{
+		{name: "number", expression: "42.5", printed: "42.5"},
+		{name: "negative number", expression: "-3", printed: "-3"},
+		{name: "number beyond float64", expression: "12345678901234567.89 > $.num", printed: "12345678901234567.89 > $.num"},
+		{name: "string", expression: `'it\'s a \\ test'`, printed: `'it\'s a \\ test'`},
+		{name: "path", expression: `$.a[0]['b c']`, printed: `$.a[0]['b c']`},
+		{name: "redundant parens", expression: "((1 + $.num) * 2)", printed: "(1 + $.num) * 2", reduced: "($.num + 1) * 2"},
+		{name: "precedence", expression: "1 + (2 * $.num)", printed: "1 + 2 * $.num", reduced: "$.num * 2 + 1"},
+		{name: "left associative", expression: "(8 - 4) - $.num", printed: "8 - 4 - $.num", reduced: "4 - $.num"},
+		{name: "right operand", expression: "8 - (4 - $.num)", printed: "8 - (4 - $.num)", reduced: "8 - (4 - $.num)"},
+		{name: "unary", expression: "-($.num + 1) * 2", printed: "-($.num + 1) * 2"},
+		{name: "double negative", expression: "-(-$.num)", printed: "--$.num"},
+		{name: "not", expression: "!($.yes && true)", printed: "!($.yes && true)", reduced: "!$.yes"},
+		{name: "logic", expression: "($.num > 1 && $.yes) || ($.name == 'dan')", printed: "$.num > 1 && $.yes || $.name == 'dan'"},
+		{name: "or in and", expression: "$.yes && ($.yes || false)", printed: "$.yes && ($.yes || false)", reduced: "$.yes && $.yes"},
+		{name: "equality of comparisons", expression: "($.num > 1) == ($.num < 1)", printed: "$.num > 1 == $.num < 1"},
+		{name: "functions", expression: "sum($.num, 1, 2) + product($.num)", printed: "sum($.num, 1, 2) + product($.num)", reduced: "sum($.num, product($.num), 3)"},
+		{name: "single operand left", expression: "$.a + 1 > 1 && $.c || false", printed: "$.a + 1 > 1 && $.c || false", reduced: "$.a + 1 > 1 && $.c"},
+		{name: "two argument function", expression: "and($.yes, or(true, $.yes))", printed: "$.yes && (true || $.yes)", reduced: "$.yes"},
+		{name: "length", expression: "length($.arr) > 1", printed: "length($.arr) > 1"},
+		{name: "default", expression: "($.missing ? (1 + 2)) * 2", printed: "$.missing ? (1 + 2) * 2", reduced: "$.missing ? 3 * 2"},
+		{name: "bare default", expression: "$.missing ? $.name", printed: "$.missing ? $.name"},
+		{name: "folded", expression: "(1 + 2) * 3 > $.num", printed: "(1 + 2) * 3 > $.num", reduced: "9 > $.num"},
+		{name: "division by zero", expression: "1 / 0", printed: "1 / 0"},
+		{name: "array", expression: "length([1, $.num + 1, 3])", printed: "length([1, $.num + 1, 3])"},
+		{name: "in", expression: "($.num + 1) in [1, 2]", printed: "$.num + 1 in [1, 2]"},
+		{name: "not in", expression: "!($.name in ['a', 'b'])", printed: "$.name not in ['a', 'b']"},
+		{name: "in as operand", expression: "($.name in $.arr) == ($.num not in [])", printed: "$.name in $.arr == $.num not in []"},
+		{name: "folded in", expression: "$.yes && ('b' not in ['a'])", printed: "$.yes && 'b' not in ['a']", reduced: "$.yes"},
+		{name: "folded length", expression: "length([1, 2]) + length([$.num])", printed: "length([1, 2]) + length([$.num])", reduced: "length([$.num]) + 2"},
+		{name: "array default", expression: "length($.missing ? [1])", printed: "length($.missing ? [1])"},
+		{name: "folded string functions", expression: "concat(upper('a'), trim(' b '), substring('xcd', 1))", printed: "concat(upper('a'), trim(' b '), substring('xcd', 1))", reduced: "'Abcd'"},
+		{name: "partly folded string functions", expression: "concat($.name, lower('B'))", printed: "concat($.name, lower('B'))", reduced: "concat($.name, 'b')"},
+		{name: "folded string predicates", expression: "startsWith('abc', 'a') && contains($.name, replace('xa', 'x', ''))", printed: "startsWith('abc', 'a') && contains($.name, replace('xa', 'x', ''))", reduced: "contains($.name, 'a')"},
+		{name: "folded split and join", expression: "join(split('a,b', ','), '-') == $.name", printed: "join(split('a,b', ','), '-') == $.name", reduced: "'a-b' == $.name"},
+		{name: "folded split", expression: "$.name in split('a,b', ',')", printed: "$.name in split('a,b', ',')", reduced: "$.name in ['a', 'b']"},
+		{name: "array functions", expression: "any($.arr, @ > 1 + 2) || count($.arr, @ == $.num) > 1", printed: "any($.arr, @ > 1 + 2) || count($.arr, @ == $.num) > 1", reduced: "any($.arr, @ > 3) || count($.arr, @ == $.num) > 1"},
+		{name: "lambda", expression: "all($.arr, @n => none($.arr, @n < @))", printed: "all($.arr, @n => none($.arr, @n < @))"},
+		{name: "folded array functions", expression: "count([1, 2], true) + length(filter($.arr, true)) + length(map($.arr, @['x']))", printed: "count([1, 2], true) + length(filter($.arr, true)) + length(map($.arr, @.x))", reduced: "sum(length($.arr), length(map($.arr, @.x)), 2)"},
+		{name: "aggregates", expression: "min($.arr) + max($.num, 1 + 1) + avg([1, $.num]) + count($.arr)", printed: "min($.arr) + max($.num, 1 + 1) + avg([1, $.num]) + count($.arr)", reduced: "sum(min($.arr), max($.num, 2), avg([1, $.num]), count($.arr))"},
+		{name: "folded aggregates", expression: "avg([1, 2, 3]) + sum([]) + min(2, 3) + count(['a'])", printed: "avg([1, 2, 3]) + sum([]) + min(2, 3) + count(['a'])", reduced: "5"},
+		{name: "empty aggregate not folded", expression: "max([]) > 0", printed: "max([]) > 0"},
+		{name: "path segments", expression: "sum($.a[*]['b c']) + length($.arr[1:-1][:2][-3:][:]) + $.arr[-1]", printed: "sum($.a[*]['b c']) + length($.arr[1:-1][:2][-3:][:]) + $.arr[-1]", reduced: "sum(sum($.a[*]['b c']), length($.arr[1:-1][:2][-3:][:]), $.arr[-1])"},
+		{name: "null", expression: "null == ($.a ? null) || $.b != null", printed: "null == $.a ? null || $.b != null"},
+		{name: "recursive descent path", expression: "length($..a[*]..['b c'] ? [])", printed: "length($..a[*]..['b c'] ? [])"},
+		{name: "filter path", expression: "$.a[?(@.b == 'x' && @.c > 1 + 1)].d + 1", printed: "$.a[?(@.b == 'x' && @.c > 1 + 1)].d + 1"},
+		{name: "arithmetic operators", expression: "(2 ** 3) ** $.num + 2 ** (3 ** $.num) - (-$.a) ** 2 % (7 // 2)", printed: "(2 ** 3) ** $.num + 2 ** 3 ** $.num - -$.a ** 2 % (7 // 2)", reduced: "8 ** $.num + 2 ** 3 ** $.num - -$.a ** 2 % 3"},
+		{name: "pow", expression: "pow($.a, 2) * pow(2, 3)", printed: "$.a ** 2 * 2 ** 3", reduced: "$.a ** 2 * 8"},
+		{name: "math functions", expression: "round($.a, 1 + 1) + clamp(sqrt(16), abs(-1), exp(log(1))) + floor(ceil($.a))", printed: "round($.a, 1 + 1) + clamp(sqrt(16), abs(-1), exp(log(1))) + floor(ceil($.a))", reduced: "sum(round($.a, 2), floor(ceil($.a)), 1)"},
+		{name: "math domain errors not folded", expression: "sqrt(-1) < 1 % 0 || 0 ** -1 > 1", printed: "sqrt(-1) < 1 % 0 || 0 ** -1 > 1"},
+		{name: "if", expression: "if($.yes, 1 + 2, $.num) * 2", printed: "if($.yes, 1 + 2, $.num) * 2", reduced: "if($.yes, 3, $.num) * 2"},
+		{name: "folded if", expression: "concat(if(1 > 2, $.name, 'x'), if(true, $.name, 'y'))", printed: "concat(if(1 > 2, $.name, 'x'), if(true, $.name, 'y'))", reduced: "concat('x', $.name)"},
+		{name: "untyped if", expression: "if($.yes, $.name, $.missing)", printed: "if($.yes, $.name, $.missing)"},
+		{name: "not equal", expression: "!($.name == 'x') && $.num != 1 + 2", printed: "$.name != 'x' && $.num != 1 + 2", reduced: "$.name != 'x' && $.num != 3"},
+		{name: "folded not equal", expression: "$.yes && 'a' != 'b'", printed: "$.yes && 'a' != 'b'", reduced: "$.yes"},
+		{name: "string comparison", expression: "($.name < 'e') == ('b' >= concat('a', 'b'))", printed: "$.name < 'e' == 'b' >= concat('a', 'b')", reduced: "$.name < 'e' == true"},
+		{name: "match", expression: "matches($.name, concat('d', $.name)) || ($.name =~ 'x') == $.yes", printed: "$.name =~ concat('d', $.name) || $.name =~ 'x' == $.yes"},
+		{name: "folded match", expression: "'abc' =~ concat('^a', 'b') && $.name =~ lower('D')", printed: "'abc' =~ concat('^a', 'b') && $.name =~ lower('D')", reduced: "$.name =~ 'd'"},
+		{name: "folded length", expression: "length(upper('héllo')) + length($.name)", printed: "length(upper('héllo')) + length($.name)", reduced: "length($.name) + 5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := parse(t, test.expression)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			want := expr.Value(data)
+
+			printed := internal.Print(expr)
+			if printed != test.printed {
+				t.Errorf("got %s, want %s", printed, test.printed)
+			}
+			reduced := internal.Print(expr.Reduce())
+			if test.reduced == "" {
+				test.reduced = test.printed
+			}
+			if reduced != test.reduced {
+				t.Errorf("got reduced %s, want %s", reduced, test.reduced)
+			}
+
+			for _, src := range []string{printed, reduced} {
+				reparsed, err := parse(t, src)
+				if err != nil {
+					t.Fatalf("printed %s doesn't parse: %s", src, err)
+				}
+				if again := internal.Print(reparsed); again != src {
+					t.Errorf("printed %s reprinted as %s", src, again)
+				}
+				if value := reparsed.Value(data); !reflect.DeepEqual(value, want) {
+					t.Errorf("printed %s evaluated to %v, want %v", src, value, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_Print_NotFinite(t *testing.T) {
+	data := internal.NewJSONParser(map[string]interface{}{"num": float64(1)})
+	expressions := []string{
+		"10 ** 400",
+		"$.num + 10 ** 400",
+		"-(2 ** 1024) < $.num",
+		"exp(1000) * $.num",
+		"round(10 ** 400, 2)",
+		strings.Repeat("9", 308) + " * 10 + $.num",
+		"$.num + 10 ** 308 + 10 ** 308",
+		"-(10 ** 308) - 10 ** 308 < $.num",
+		"10 ** 308 / 0.1 * $.num",
+		"sum(10 ** 308, 10 ** 308) + sum([10 ** 308, 10 ** 308]) > $.num",
+		"log(0) + 10 ** 400",
+		"0 ** -1 * $.num",
+	}
+	for _, src := range expressions {
+		t.Run(src, func(t *testing.T) {
+			expr, err := parse(t, src)
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			want, wantErr := internal.EvalStrict(expr, data)
+
+			printed := internal.Print(expr.Reduce())
+			reparsed, err := parse(t, printed)
+			if err != nil {
+				t.Fatalf("printed %s doesn't parse: %s", printed, err)
+			}
+			value, err := internal.EvalStrict(reparsed, data)
+			if !reflect.DeepEqual(value, want) || fmt.Sprint(err) != fmt.Sprint(wantErr) {
+				t.Errorf("printed %s evaluated to %v, %v, want %v, %v", printed, value, err, want, wantErr)
+			}
+		})
+	}
+}
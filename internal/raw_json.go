@@ -0,0 +1,406 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strconv"
+)
+
+// rawJSONParser finds values by scanning the raw bytes of a JSON document,
+// skipping over everything that isn't on the way to the requested path.
+type rawJSONParser struct {
+	data []byte
+	// offsets caches the offset of the value at every path, and path prefix,
+	// that has been looked up, keyed by Path.String().
+	offsets map[string]int
+}
+
+// NewRawJSONParser returns a PathParser for the JSON document in data that
+// doesn't decode the document up front. Each lookup scans straight to the value
+// at the path and only decodes that value, and the offsets of paths that have
+// been seen are cached, so a parser should be created for each document that
+// is evaluated.
+//
+// data isn't validated, so a malformed document behaves as if paths through
+// the malformed part don't exist. If an object has duplicate keys, the first is
+// used.
+func NewRawJSONParser(data []byte) PathParser {
+	return &rawJSONParser{data: data, offsets: map[string]int{}}
+}
+
+func (p *rawJSONParser) GetValue(path Path) (interface{}, bool) {
+	if path.fansOut() {
+		return collect(p, path)
+	}
+	raw, ok := p.raw(path)
+	if !ok {
+		return nil, false
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (p *rawJSONParser) GetNumber(path Path) (float64, bool) {
+	raw, ok := p.rawNumber(path)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(raw), 64)
+	return f, err == nil
+}
+
+// getDecimal reads the number at path as the decimal it's written as.
+func (p *rawJSONParser) getDecimal(path Path) (*big.Rat, bool) {
+	raw, ok := p.rawNumber(path)
+	if !ok {
+		return nil, false
+	}
+	return new(big.Rat).SetString(string(raw))
+}
+
+func (p *rawJSONParser) GetBoolean(path Path) (bool, bool) {
+	raw, ok := p.raw(path)
+	if !ok {
+		return false, false
+	}
+	switch string(raw) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+func (p *rawJSONParser) GetString(path Path) (string, bool) {
+	raw, ok := p.raw(path)
+	if !ok || len(raw) < 2 || raw[0] != '"' {
+		return "", false
+	}
+	if bytes.IndexByte(raw, '\\') == -1 {
+		return string(raw[1 : len(raw)-1]), true
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+func (p *rawJSONParser) GetArray(path Path) ([]interface{}, bool) {
+	if path.fansOut() {
+		return collect(p, path)
+	}
+	raw, ok := p.raw(path)
+	if !ok || raw[0] != '[' {
+		return nil, false
+	}
+	var a []interface{}
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return nil, false
+	}
+	return a, true
+}
+
+// children lists the keys of an object in the order they're in the document,
+// without duplicates.
+func (p *rawJSONParser) children(path Path) ([]interface{}, bool) {
+	start, ok := p.offset(path)
+	if !ok {
+		return nil, false
+	}
+	switch p.data[start] {
+	case '{':
+		return objectKeys(p.data, start)
+	case '[':
+		n, ok := countElements(p.data, start)
+		if !ok {
+			return nil, false
+		}
+		children := make([]interface{}, n)
+		for i := range children {
+			children[i] = i
+		}
+		return children, true
+	}
+	return nil, true
+}
+
+// raw returns the bytes of the value at path, or of the first value selected
+// by a path that fans out.
+func (p *rawJSONParser) raw(path Path) ([]byte, bool) {
+	path, ok := scalarPath(p, path)
+	if !ok {
+		return nil, false
+	}
+	start, ok := p.offset(path)
+	if !ok {
+		return nil, false
+	}
+	end, ok := skipValue(p.data, start)
+	if !ok {
+		return nil, false
+	}
+	return p.data[start:end], true
+}
+
+// rawNumber returns the bytes of the number at path.
+func (p *rawJSONParser) rawNumber(path Path) ([]byte, bool) {
+	raw, ok := p.raw(path)
+	if !ok || len(raw) == 0 || raw[0] != '-' && (raw[0] < '0' || raw[0] > '9') {
+		return nil, false
+	}
+	return raw, true
+}
+
+// offset returns the offset of the value at path, starting from the longest
+// prefix of path whose offset is cached.
+func (p *rawJSONParser) offset(path Path) (int, bool) {
+	i, offset := len(path), -1
+	for ; i >= 0; i-- {
+		if cached, ok := p.offsets[path[:i].String()]; ok {
+			offset = cached
+			break
+		}
+	}
+	if offset == -1 {
+		i, offset = 0, skipJSONSpace(p.data, 0)
+		if offset == len(p.data) {
+			return 0, false
+		}
+		p.offsets[Path{}.String()] = offset
+	}
+
+	for ; i < len(path); i++ {
+		var ok bool
+		switch segment := path[i].(type) {
+		case string:
+			offset, ok = findKey(p.data, offset, segment)
+		case int:
+			offset, ok = findIndex(p.data, offset, segment)
+		}
+		if !ok {
+			return 0, false
+		}
+		p.offsets[path[:i+1].String()] = offset
+	}
+	return offset, true
+}
+
+// findKey returns the offset of the value of key in the object at offset i.
+func findKey(data []byte, i int, key string) (int, bool) {
+	if i >= len(data) || data[i] != '{' {
+		return 0, false
+	}
+	i = skipJSONSpace(data, i+1)
+	if i < len(data) && data[i] == '}' {
+		return 0, false
+	}
+	for i < len(data) {
+		end, ok := skipString(data, i)
+		if !ok {
+			return 0, false
+		}
+		match := keyEquals(data[i:end], key)
+
+		i = skipJSONSpace(data, end)
+		if i >= len(data) || data[i] != ':' {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i+1)
+		if match {
+			return i, true
+		}
+
+		if i, ok = skipValue(data, i); !ok {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i)
+		if i >= len(data) || data[i] != ',' {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i+1)
+	}
+	return 0, false
+}
+
+// findIndex returns the offset of element index in the array at offset i,
+// counting back from the end if index is negative.
+func findIndex(data []byte, i int, index int) (int, bool) {
+	if i >= len(data) || data[i] != '[' {
+		return 0, false
+	}
+	if index < 0 {
+		n, ok := countElements(data, i)
+		if !ok || index+n < 0 {
+			return 0, false
+		}
+		index += n
+	}
+	i = skipJSONSpace(data, i+1)
+	if i < len(data) && data[i] == ']' {
+		return 0, false
+	}
+	for n := 0; i < len(data); n++ {
+		if n == index {
+			return i, true
+		}
+		var ok bool
+		if i, ok = skipValue(data, i); !ok {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i)
+		if i >= len(data) || data[i] != ',' {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i+1)
+	}
+	return 0, false
+}
+
+// objectKeys returns the keys of the object at offset i.
+func objectKeys(data []byte, i int) ([]interface{}, bool) {
+	var keys []interface{}
+	seen := map[string]bool{}
+	i = skipJSONSpace(data, i+1)
+	if i < len(data) && data[i] == '}' {
+		return keys, true
+	}
+	for i < len(data) {
+		end, ok := skipString(data, i)
+		if !ok {
+			return nil, false
+		}
+		var key string
+		if err := json.Unmarshal(data[i:end], &key); err != nil {
+			return nil, false
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+
+		i = skipJSONSpace(data, end)
+		if i >= len(data) || data[i] != ':' {
+			return nil, false
+		}
+		if i, ok = skipValue(data, skipJSONSpace(data, i+1)); !ok {
+			return nil, false
+		}
+		i = skipJSONSpace(data, i)
+		if i < len(data) && data[i] == '}' {
+			return keys, true
+		}
+		if i >= len(data) || data[i] != ',' {
+			return nil, false
+		}
+		i = skipJSONSpace(data, i+1)
+	}
+	return nil, false
+}
+
+// countElements returns the number of elements in the array at offset i.
+func countElements(data []byte, i int) (int, bool) {
+	i = skipJSONSpace(data, i+1)
+	if i < len(data) && data[i] == ']' {
+		return 0, true
+	}
+	for n := 1; i < len(data); n++ {
+		var ok bool
+		if i, ok = skipValue(data, i); !ok {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i)
+		if i < len(data) && data[i] == ']' {
+			return n, true
+		}
+		if i >= len(data) || data[i] != ',' {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i+1)
+	}
+	return 0, false
+}
+
+// keyEquals reports whether the raw JSON string raw is equal to key.
+func keyEquals(raw []byte, key string) bool {
+	if bytes.IndexByte(raw, '\\') == -1 {
+		return string(raw[1:len(raw)-1]) == key
+	}
+	var s string
+	return json.Unmarshal(raw, &s) == nil && s == key
+}
+
+// skipValue returns the offset just after the value at offset i.
+func skipValue(data []byte, i int) (int, bool) {
+	if i >= len(data) {
+		return 0, false
+	}
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				end, ok := skipString(data, i)
+				if !ok {
+					return 0, false
+				}
+				i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, true
+				}
+			}
+			i++
+		}
+		return 0, false
+	}
+	// a number, true, false or null
+	start := i
+	for i < len(data) && !isJSONDelimiter(data[i]) {
+		i++
+	}
+	return i, i > start
+}
+
+// skipString returns the offset just after the string starting at offset i.
+func skipString(data []byte, i int) (int, bool) {
+	if i >= len(data) || data[i] != '"' {
+		return 0, false
+	}
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) && isJSONSpace(data[i]) {
+		i++
+	}
+	return i
+}
+
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+func isJSONDelimiter(b byte) bool {
+	return isJSONSpace(b) || b == ',' || b == ']' || b == '}'
+}
@@ -0,0 +1,146 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+const rawDocument = `{
+	"header": {"type": "x", "id": 7},
+	"skip": {"a": [1, {"b": "]}\"{["}], "c": "\"quoted\""},
+	"esc\"key": "escaped é\n",
+	"items": [
+		{"price": 1.5, "tags": []},
+		{"price": -2e3, "tags": ["a", "b"]},
+		{}
+	],
+	"flag": false,
+	"nothing": null,
+	"dup": 1,
+	"dup": 2
+}`
+
+func Test_RawJSONParser(t *testing.T) {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(rawDocument), &decoded); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	want := internal.NewJSONParser(decoded)
+
+	paths := []internal.Path{
+		{},
+		{"header"},
+		{"header", "type"},
+		{"header", "id"},
+		{"skip", "a", 1, "b"},
+		{"skip", "c"},
+		{"esc\"key"},
+		{"items"},
+		{"items", 0, "price"},
+		{"items", 1, "price"},
+		{"items", 1, "tags", 1},
+		{"items", 0, "tags"},
+		{"items", 0, "tags", 0},
+		{"items", 2},
+		{"items", 2, "price"},
+		{"items", 3},
+		{"items", -1},
+		{"items", -3, "price"},
+		{"items", -4},
+		{"items", internal.Wildcard{}, "price"},
+		{"items", internal.Slice{Start: 1}, "tags", internal.Wildcard{}},
+		{"items", internal.Slice{End: -1, HasEnd: true}, "tags"},
+		{"header", internal.Wildcard{}},
+		{internal.Descendants{}, "price"},
+		{"items", internal.Descendants{}, 1},
+		{"items", "price"},
+		{"header", 0},
+		{"flag"},
+		{"nothing"},
+		{"missing"},
+		{"header", "type", "x"},
+	}
+	getters := map[string]func(internal.PathParser, internal.Path) (interface{}, bool){
+		"GetValue":   getValue,
+		"GetNumber":  getNumber,
+		"GetBoolean": getBoolean,
+		"GetString":  getString,
+		"GetArray":   getArray,
+	}
+	for _, path := range paths {
+		for name, get := range getters {
+			t.Run(fmt.Sprintf("%s %s", name, path), func(t *testing.T) {
+				// a new parser for each lookup and a shared one, to test the cache
+				for _, p := range []internal.PathParser{internal.NewRawJSONParser([]byte(rawDocument)), shared} {
+					value, ok := get(p, path)
+					wantValue, wantOK := get(want, path)
+					if ok != wantOK || !reflect.DeepEqual(value, wantValue) {
+						t.Errorf("got %#v, %v, want %#v, %v", value, ok, wantValue, wantOK)
+					}
+				}
+			})
+		}
+	}
+}
+
+var shared = internal.NewRawJSONParser([]byte(rawDocument))
+
+func Test_RawJSONParser_DuplicateKeys(t *testing.T) {
+	p := internal.NewRawJSONParser([]byte(rawDocument))
+	if n, ok := p.GetNumber(internal.Path{"dup"}); !ok || n != 1 {
+		t.Errorf("got %v, %v, want the first duplicate key", n, ok)
+	}
+}
+
+func Test_RawJSONParser_Descendants(t *testing.T) {
+	p := internal.NewRawJSONParser([]byte(`{"z": {"id": 1, "a": {"id": 2}}, "id": 3, "b": [{"id": 4}, {"id": 5, "id": 6}]}`))
+	values, ok := p.GetArray(internal.Path{internal.Descendants{}, "id"})
+	if want := []interface{}{float64(3), float64(1), float64(2), float64(4), float64(5)}; !ok || !reflect.DeepEqual(values, want) {
+		t.Errorf("got %v, %v, want %v in document order", values, ok, want)
+	}
+}
+
+func Test_RawJSONParser_Malformed(t *testing.T) {
+	for _, doc := range []string{``, `{`, `{"a" 1}`, `{"a": [1}`, `{"b": "unterminated}`, `[1 2]`} {
+		p := internal.NewRawJSONParser([]byte(doc))
+		if value, ok := p.GetValue(internal.Path{"a", 1}); ok {
+			t.Errorf("%s: got %v, expected path not to be found", doc, value)
+		}
+	}
+}
+
+func Benchmark_RawJSONParser(b *testing.B) {
+	doc := []byte(`{"padding": [` + strings.Repeat(`{"key": "value", "n": [1, 2, 3]},`, 3000) + `{}], "header": {"type": "x"}}`)
+	tokens, err := internal.Lex("$.header.type == 'x'")
+	if err != nil {
+		b.Fatal(err)
+	}
+	p, err := internal.Parse(tokens)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("raw", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !p.Value(internal.NewRawJSONParser(doc)).(bool) {
+				b.Fatal("expected true")
+			}
+		}
+	})
+	b.Run("decoded", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var decoded interface{}
+			if err := json.Unmarshal(doc, &decoded); err != nil {
+				b.Fatal(err)
+			}
+			if !p.Value(internal.NewJSONParser(decoded)).(bool) {
+				b.Fatal("expected true")
+			}
+		}
+	})
+}
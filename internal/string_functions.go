@@ -0,0 +1,350 @@
+package internal
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	concatExpression struct {
+		node
+		subExpressions []StringExpression
+	}
+
+	upperExpression struct {
+		node
+		se StringExpression
+	}
+
+	lowerExpression struct {
+		node
+		se StringExpression
+	}
+
+	trimExpression struct {
+		node
+		se StringExpression
+	}
+
+	// substringExpression is the runes of se from start up to end, or to the
+	// end of se if end is nil.
+	substringExpression struct {
+		node
+		se    StringExpression
+		start NumberExpression
+		end   NumberExpression
+	}
+
+	startsWithExpression struct {
+		node
+		se     StringExpression
+		prefix StringExpression
+	}
+
+	endsWithExpression struct {
+		node
+		se     StringExpression
+		suffix StringExpression
+	}
+
+	containsExpression struct {
+		node
+		se     StringExpression
+		substr StringExpression
+	}
+
+	replaceExpression struct {
+		node
+		se  StringExpression
+		old StringExpression
+		new StringExpression
+	}
+
+	splitExpression struct {
+		node
+		se  StringExpression
+		sep StringExpression
+	}
+
+	joinExpression struct {
+		node
+		ae  ArrayExpression
+		sep StringExpression
+	}
+
+	// strLengthExpression is the number of runes in se.
+	strLengthExpression struct {
+		node
+		se StringExpression
+	}
+
+	// matchExpression reports whether se contains a match of the RE2 regular
+	// expression pattern. re is the compiled pattern if it's a literal.
+	matchExpression struct {
+		node
+		se      StringExpression
+		pattern StringExpression
+		re      *regexp.Regexp
+	}
+
+	// pathLengthExpression is the length of the array or string at an untyped
+	// path, which is only known once it's evaluated.
+	pathLengthExpression struct {
+		node
+		path     []interface{}
+		pathSpan Span
+	}
+)
+
+func (e *concatExpression) Value(pp PathParser) string {
+	sb := strings.Builder{}
+	for _, subExpression := range e.subExpressions {
+		sb.WriteString(subExpression.Value(pp))
+	}
+	return sb.String()
+}
+
+func (e *concatExpression) Reduce() StringExpression {
+	args := make([]interface{}, len(e.subExpressions))
+	for i, subExpression := range e.subExpressions {
+		e.subExpressions[i] = subExpression.Reduce()
+		args[i] = e.subExpressions[i]
+	}
+	return foldString(e, args...)
+}
+
+func (e *upperExpression) Value(pp PathParser) string {
+	return strings.ToUpper(e.se.Value(pp))
+}
+
+func (e *upperExpression) Reduce() StringExpression {
+	e.se = e.se.Reduce()
+	return foldString(e, e.se)
+}
+
+func (e *lowerExpression) Value(pp PathParser) string {
+	return strings.ToLower(e.se.Value(pp))
+}
+
+func (e *lowerExpression) Reduce() StringExpression {
+	e.se = e.se.Reduce()
+	return foldString(e, e.se)
+}
+
+func (e *trimExpression) Value(pp PathParser) string {
+	return strings.TrimSpace(e.se.Value(pp))
+}
+
+func (e *trimExpression) Reduce() StringExpression {
+	e.se = e.se.Reduce()
+	return foldString(e, e.se)
+}
+
+// Value clamps start and end to the string, so it never fails.
+func (e *substringExpression) Value(pp PathParser) string {
+	runes := []rune(e.se.Value(pp))
+	start, end := clamp(e.start.Value(pp), len(runes)), len(runes)
+	if e.end != nil {
+		end = clamp(e.end.Value(pp), len(runes))
+	}
+	if end < start {
+		return ""
+	}
+	return string(runes[start:end])
+}
+
+func (e *substringExpression) Reduce() StringExpression {
+	e.se = e.se.Reduce()
+	e.start = e.start.Reduce()
+	if e.end == nil {
+		return foldString(e, e.se, e.start)
+	}
+	e.end = e.end.Reduce()
+	return foldString(e, e.se, e.start, e.end)
+}
+
+// clamp truncates n to an index between 0 and length.
+func clamp(n float64, length int) int {
+	switch {
+	case math.IsNaN(n) || n < 0:
+		return 0
+	case n > float64(length):
+		return length
+	}
+	return int(n)
+}
+
+func (e *startsWithExpression) Value(pp PathParser) bool {
+	return strings.HasPrefix(e.se.Value(pp), e.prefix.Value(pp))
+}
+
+func (e *startsWithExpression) Reduce() BooleanExpression {
+	e.se = e.se.Reduce()
+	e.prefix = e.prefix.Reduce()
+	return foldBoolean(e, e.se, e.prefix)
+}
+
+func (e *endsWithExpression) Value(pp PathParser) bool {
+	return strings.HasSuffix(e.se.Value(pp), e.suffix.Value(pp))
+}
+
+func (e *endsWithExpression) Reduce() BooleanExpression {
+	e.se = e.se.Reduce()
+	e.suffix = e.suffix.Reduce()
+	return foldBoolean(e, e.se, e.suffix)
+}
+
+func (e *containsExpression) Value(pp PathParser) bool {
+	return strings.Contains(e.se.Value(pp), e.substr.Value(pp))
+}
+
+func (e *containsExpression) Reduce() BooleanExpression {
+	e.se = e.se.Reduce()
+	e.substr = e.substr.Reduce()
+	return foldBoolean(e, e.se, e.substr)
+}
+
+func (e *replaceExpression) Value(pp PathParser) string {
+	return strings.ReplaceAll(e.se.Value(pp), e.old.Value(pp), e.new.Value(pp))
+}
+
+func (e *replaceExpression) Reduce() StringExpression {
+	e.se = e.se.Reduce()
+	e.old = e.old.Reduce()
+	e.new = e.new.Reduce()
+	return foldString(e, e.se, e.old, e.new)
+}
+
+func (e *splitExpression) Value(pp PathParser) []interface{} {
+	parts := strings.Split(e.se.Value(pp), e.sep.Value(pp))
+	values := make([]interface{}, len(parts))
+	for i, part := range parts {
+		values[i] = part
+	}
+	return values
+}
+
+func (e *splitExpression) Reduce() ArrayExpression {
+	e.se = e.se.Reduce()
+	e.sep = e.sep.Reduce()
+	if !allConstant(e.se, e.sep) {
+		return e
+	}
+	values := e.Value(nil)
+	elems := make([]Expression, len(values))
+	for i, value := range values {
+		elems[i] = &generic{s: &str{node: e.node, s: value.(string)}}
+	}
+	return &array{node: e.node, elems: elems}
+}
+
+// Value joins the elements of the array, writing numbers and booleans like
+// literals and skipping nulls, arrays and objects.
+func (e *joinExpression) Value(pp PathParser) string {
+	var parts []string
+	for _, elem := range e.ae.Value(pp) {
+		switch elem := elem.(type) {
+		case string:
+			parts = append(parts, elem)
+		case float64:
+			parts = append(parts, strconv.FormatFloat(elem, 'f', -1, 64))
+		case bool:
+			parts = append(parts, strconv.FormatBool(elem))
+		}
+	}
+	return strings.Join(parts, e.sep.Value(pp))
+}
+
+func (e *joinExpression) Reduce() StringExpression {
+	e.ae = e.ae.Reduce()
+	e.sep = e.sep.Reduce()
+	return foldString(e, e.ae, e.sep)
+}
+
+// Value compiles the pattern if it isn't a literal. An invalid pattern
+// doesn't match anything, or fails strict evaluation.
+func (e *matchExpression) Value(pp PathParser) bool {
+	s := e.se.Value(pp)
+	re := e.re
+	if re == nil {
+		var err error
+		if re, err = regexp.Compile(e.pattern.Value(pp)); err != nil {
+			fail(pp, errorf(e.pattern.Span(), "invalid pattern: %s", err))
+			return false
+		}
+	}
+	return re.MatchString(s)
+}
+
+func (e *matchExpression) Reduce() BooleanExpression {
+	e.se = e.se.Reduce()
+	e.pattern = e.pattern.Reduce()
+	if lit, ok := e.pattern.(*str); ok && e.re == nil {
+		// a pattern folded from a call, which isn't checked when it's parsed
+		if re, err := regexp.Compile(lit.s); err == nil {
+			e.re = re
+		}
+	}
+	if e.re == nil {
+		return e
+	}
+	return foldBoolean(e, e.se, e.pattern)
+}
+
+func (e *strLengthExpression) Value(pp PathParser) float64 {
+	return float64(utf8.RuneCountInString(e.se.Value(pp)))
+}
+
+func (e *strLengthExpression) Reduce() NumberExpression {
+	e.se = e.se.Reduce()
+	if allConstant(e.se) {
+		return &number{node: e.node, n: e.Value(nil)}
+	}
+	return e
+}
+
+func (e *pathLengthExpression) Value(pp PathParser) float64 {
+	if a, ok := pp.GetArray(e.path); ok {
+		return float64(len(a))
+	}
+	if s, ok := pp.GetString(e.path); ok {
+		return float64(utf8.RuneCountInString(s))
+	}
+	pathNotFound(pp, e.path, ArrayKind, e.pathSpan)
+	return 0
+}
+
+func (e *pathLengthExpression) Reduce() NumberExpression {
+	return e
+}
+
+// allConstant reports whether every argument is a literal or a literal array.
+func allConstant(args ...interface{}) bool {
+	for _, arg := range args {
+		if _, ok := constant(arg); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// foldString returns a string literal of the value of e if its arguments are
+// all constant, or else e.
+func foldString(e StringExpression, args ...interface{}) StringExpression {
+	if !allConstant(args...) {
+		return e
+	}
+	return &str{node: node{span: e.Span()}, s: e.Value(nil)}
+}
+
+// foldBoolean returns a boolean literal of the value of e if its arguments
+// are all constant, or else e.
+func foldBoolean(e BooleanExpression, args ...interface{}) BooleanExpression {
+	if !allConstant(args...) {
+		return e
+	}
+	return &boolean{node: node{span: e.Span()}, b: e.Value(nil)}
+}
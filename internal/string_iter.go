@@ -1,8 +1,16 @@
 package internal
 
+import "unicode/utf8"
+
 type stringIterator struct {
 	runes []rune
 	i     int
+	pos   Position
+	prev  Position
+}
+
+func newStringIterator(s string) *stringIterator {
+	return &stringIterator{runes: []rune(s), pos: Position{Line: 1, Column: 1}}
 }
 
 func (iter *stringIterator) next() (rune, bool) {
@@ -11,6 +19,14 @@ func (iter *stringIterator) next() (rune, bool) {
 	}
 	next := iter.runes[iter.i]
 	iter.i++
+	iter.prev = iter.pos
+	iter.pos.Offset += utf8.RuneLen(next)
+	if next == '\n' {
+		iter.pos.Line++
+		iter.pos.Column = 1
+	} else {
+		iter.pos.Column++
+	}
 	return next, true
 }
 
@@ -24,3 +40,13 @@ func (iter *stringIterator) peek() (rune, bool) {
 func (iter *stringIterator) done() bool {
 	return iter.i == len(iter.runes)
 }
+
+// errorf returns an error located at the last rune read.
+func (iter *stringIterator) errorf(format string, args ...interface{}) *Error {
+	return errorf(Span{Start: iter.prev, End: iter.pos}, format, args...)
+}
+
+// errEOF returns an error located at the end of the input.
+func (iter *stringIterator) errEOF() *Error {
+	return errorf(Span{Start: iter.pos, End: iter.pos}, "unexpected end of input")
+}
@@ -0,0 +1,281 @@
+package internal
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type structParser struct {
+	value reflect.Value
+}
+
+// fieldPlans caches the fields of each struct type that has been looked up,
+// as a map[string][]int from path key to field index.
+var fieldPlans sync.Map
+
+// NewStructParser returns a PathParser for v, a Go value made of structs,
+// maps, slices, arrays, pointers and primitives. Object keys select exported
+// struct fields by their json tag name, or by their Go name if they don't have
+// one, and fields tagged `json:"-"` are skipped. Fields of embedded structs
+// are promoted like they are by encoding/json. Maps with string keys can also
+// be indexed by key, and slices and arrays by index, counting back from the
+// end if it's negative.
+//
+// Pointers and interfaces are followed, and a nil one is found as nil at the
+// end of a path but isn't found in the middle of one. Numbers of any Go int,
+// uint or float type are returned as float64.
+func NewStructParser(v interface{}) PathParser {
+	return &structParser{value: reflect.ValueOf(v)}
+}
+
+func (p *structParser) GetValue(path Path) (interface{}, bool) {
+	if path.fansOut() {
+		return collect(p, path)
+	}
+	v, ok := p.get(path)
+	if !ok {
+		return nil, false
+	}
+	if !v.IsValid() {
+		return nil, true
+	}
+	return reflectInterface(v)
+}
+
+func (p *structParser) GetNumber(path Path) (float64, bool) {
+	v, ok := p.get(path)
+	if !ok || !v.IsValid() {
+		return 0, false
+	}
+	return reflectFloat(v)
+}
+
+func (p *structParser) GetBoolean(path Path) (bool, bool) {
+	v, ok := p.get(path)
+	if !ok || !v.IsValid() || v.Kind() != reflect.Bool {
+		return false, false
+	}
+	return v.Bool(), true
+}
+
+func (p *structParser) GetString(path Path) (string, bool) {
+	v, ok := p.get(path)
+	if !ok || !v.IsValid() || v.Kind() != reflect.String {
+		return "", false
+	}
+	return v.String(), true
+}
+
+func (p *structParser) GetArray(path Path) ([]interface{}, bool) {
+	if path.fansOut() {
+		return collect(p, path)
+	}
+	v, ok := p.get(path)
+	if !ok || !v.IsValid() || v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	a := make([]interface{}, v.Len())
+	for i := range a {
+		elem, ok := reflectInterface(v.Index(i))
+		if !ok {
+			return nil, false
+		}
+		a[i] = elem
+	}
+	return a, true
+}
+
+// children lists struct fields in the order they're declared in.
+func (p *structParser) children(path Path) ([]interface{}, bool) {
+	v, ok := p.get(path)
+	if !ok {
+		return nil, false
+	}
+	if v.Kind() != reflect.Struct {
+		return childrenOfValue(v), true
+	}
+	fields := fieldsOf(v.Type())
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := fields[names[i]], fields[names[j]]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	children := make([]interface{}, len(names))
+	for i, name := range names {
+		children[i] = name
+	}
+	return children, true
+}
+
+// get returns the value at path, or the first value selected by a path that
+// fans out, with pointers and interfaces followed. The returned value is
+// invalid if the path ends at a nil pointer or interface.
+func (p *structParser) get(path Path) (reflect.Value, bool) {
+	path, ok := scalarPath(p, path)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	v := indirect(p.value)
+	for _, segment := range path {
+		if !v.IsValid() {
+			return reflect.Value{}, false
+		}
+		switch segment := segment.(type) {
+		case string:
+			switch v.Kind() {
+			case reflect.Struct:
+				index, ok := fieldsOf(v.Type())[segment]
+				if !ok {
+					return reflect.Value{}, false
+				}
+				field, err := v.FieldByIndexErr(index)
+				if err != nil {
+					// a nil embedded struct pointer
+					return reflect.Value{}, false
+				}
+				v = field
+			case reflect.Map:
+				if v.Type().Key().Kind() != reflect.String {
+					return reflect.Value{}, false
+				}
+				v = v.MapIndex(reflect.ValueOf(segment).Convert(v.Type().Key()))
+				if !v.IsValid() {
+					return reflect.Value{}, false
+				}
+			default:
+				return reflect.Value{}, false
+			}
+		case int:
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return reflect.Value{}, false
+			}
+			i, ok := arrayIndex(segment, v.Len())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			v = v.Index(i)
+		default:
+			return reflect.Value{}, false
+		}
+		v = indirect(v)
+	}
+	return v, true
+}
+
+// indirect follows pointers and interfaces, returning an invalid value if it
+// finds a nil one.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// reflectInterface returns v as an interface{}, with numbers converted to
+// float64. Values reached through unexported embedded structs can only be
+// returned if they're primitives.
+func reflectInterface(v reflect.Value) (interface{}, bool) {
+	if f, ok := reflectFloat(v); ok {
+		return f, true
+	}
+	switch {
+	case v.Kind() == reflect.Bool:
+		return v.Bool(), true
+	case v.Kind() == reflect.String:
+		return v.String(), true
+	case v.CanInterface():
+		return v.Interface(), true
+	}
+	return nil, false
+}
+
+func reflectFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// fieldsOf returns the field indexes of struct type t by path key, building
+// and caching them the first time t is seen.
+func fieldsOf(t reflect.Type) map[string][]int {
+	if fields, ok := fieldPlans.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+
+	type candidate struct {
+		index  []int
+		tagged bool
+		// ambiguous is set if another field is as dominant
+		ambiguous bool
+	}
+	candidates := map[string]candidate{}
+	for _, field := range reflect.VisibleFields(t) {
+		tag, tagged := field.Tag.Lookup("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && indirectType(field.Type).Kind() == reflect.Struct {
+			// its fields are promoted
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name, tagged = field.Name, false
+		}
+
+		// like encoding/json, the shallowest field wins, then a tagged one,
+		// and the name is dropped if that leaves more than one
+		c := candidate{index: field.Index, tagged: tagged}
+		if existing, ok := candidates[name]; ok {
+			deeper := len(c.index) > len(existing.index)
+			sameDepth := len(c.index) == len(existing.index)
+			if sameDepth && existing.tagged == c.tagged {
+				existing.ambiguous = true
+				candidates[name] = existing
+				continue
+			}
+			if deeper || sameDepth && existing.tagged {
+				continue
+			}
+		}
+		candidates[name] = c
+	}
+
+	fields := make(map[string][]int, len(candidates))
+	for name, c := range candidates {
+		if !c.ambiguous {
+			fields[name] = c.index
+		}
+	}
+	actual, _ := fieldPlans.LoadOrStore(t, fields)
+	return actual.(map[string][]int)
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
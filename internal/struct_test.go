@@ -0,0 +1,200 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/yoyowazzap/expression/internal"
+)
+
+type (
+	testAddress struct {
+		Zip   string `json:"zip,omitempty"`
+		Lines [2]string
+	}
+
+	testBase struct {
+		ID     int64 `json:"id"`
+		Shadow string
+	}
+
+	testInner struct {
+		Hidden bool `json:"hidden"`
+	}
+
+	testTaggedA struct {
+		X string `json:"n"`
+	}
+
+	testTaggedB struct {
+		Y string `json:"n"`
+	}
+
+	testUntaggedN struct {
+		N string
+	}
+
+	// testUnambiguous has a tagged and an untagged n at the same depth, and
+	// a shallower N than the two deeper ones its embedded structs promote
+	testUnambiguous struct {
+		testTaggedA
+		testUntaggedN
+		Inner struct {
+			testUntaggedN
+			N string
+		} `json:"inner"`
+	}
+
+	testUser struct {
+		*testBase
+		testInner
+		Name     string `json:"name"`
+		Shadow   string
+		Age      uint8
+		Score    float32 `json:"score"`
+		Address  *testAddress
+		Previous []*testAddress `json:"previous"`
+		Attrs    map[string]interface{}
+		Ignored  string `json:"-"`
+		Dash     string `json:"-,"`
+		Nothing  *testAddress
+		private  string
+	}
+)
+
+func Test_StructParser(t *testing.T) {
+	user := &testUser{
+		testBase:  &testBase{ID: 42, Shadow: "base"},
+		testInner: testInner{Hidden: true},
+		Name:      "dan",
+		Shadow:    "user",
+		Age:       30,
+		Score:     1.5,
+		Address:   &testAddress{Zip: "12345", Lines: [2]string{"1 Road", "Town"}},
+		Previous:  []*testAddress{{Zip: "1"}, nil},
+		Attrs:     map[string]interface{}{"tags": []string{"a"}, "n": 3},
+		Ignored:   "ignored",
+		Dash:      "dash",
+		private:   "private",
+	}
+	p := internal.NewStructParser(user)
+
+	tests := []struct {
+		name  string
+		path  internal.Path
+		get   func(internal.PathParser, internal.Path) (interface{}, bool)
+		value interface{}
+		ok    bool
+	}{
+		{name: "tagged field", path: internal.Path{"name"}, get: getString, value: "dan", ok: true},
+		{name: "untagged field", path: internal.Path{"Age"}, get: getNumber, value: float64(30), ok: true},
+		{name: "float32 field", path: internal.Path{"score"}, get: getValue, value: 1.5, ok: true},
+		{name: "pointer to struct", path: internal.Path{"Address", "zip"}, get: getString, value: "12345", ok: true},
+		{name: "array", path: internal.Path{"Address", "Lines", 1}, get: getString, value: "Town", ok: true},
+		{name: "slice of pointers", path: internal.Path{"previous", 0, "zip"}, get: getString, value: "1", ok: true},
+		{name: "array as array", path: internal.Path{"Address", "Lines"}, get: getArray, value: []interface{}{"1 Road", "Town"}, ok: true},
+		{name: "map", path: internal.Path{"Attrs", "tags", 0}, get: getString, value: "a", ok: true},
+		{name: "int in map", path: internal.Path{"Attrs", "n"}, get: getNumber, value: float64(3), ok: true},
+		{name: "promoted from embedded pointer", path: internal.Path{"id"}, get: getNumber, value: float64(42), ok: true},
+		{name: "promoted from unexported embedded struct", path: internal.Path{"hidden"}, get: getValue, value: true, ok: true},
+		{name: "shallower field wins", path: internal.Path{"Shadow"}, get: getString, value: "user", ok: true},
+		{name: "dash name", path: internal.Path{"-"}, get: getString, value: "dash", ok: true},
+		{name: "nil pointer at end", path: internal.Path{"Nothing"}, get: getValue, value: nil, ok: true},
+		{name: "nil pointer in middle", path: internal.Path{"Nothing", "zip"}, get: getValue, value: nil, ok: false},
+		{name: "nil element", path: internal.Path{"previous", 1, "zip"}, get: getValue, value: nil, ok: false},
+		{name: "go name of tagged field", path: internal.Path{"Name"}, get: getValue, value: nil, ok: false},
+		{name: "ignored field", path: internal.Path{"Ignored"}, get: getValue, value: nil, ok: false},
+		{name: "unexported field", path: internal.Path{"private"}, get: getValue, value: nil, ok: false},
+		{name: "index out of range", path: internal.Path{"previous", 2}, get: getValue, value: nil, ok: false},
+		{name: "negative index", path: internal.Path{"Address", "Lines", -2}, get: getString, value: "1 Road", ok: true},
+		{name: "wildcard", path: internal.Path{"previous", internal.Wildcard{}, "zip"}, get: getArray, value: []interface{}{"1"}, ok: true},
+		{name: "slice", path: internal.Path{"Address", "Lines", internal.Slice{Start: 1}}, get: getValue, value: []interface{}{"Town"}, ok: true},
+		{name: "descendants in field order", path: internal.Path{internal.Descendants{}, "zip"}, get: getArray, value: []interface{}{"12345", "1"}, ok: true},
+		{name: "key into slice", path: internal.Path{"previous", "zip"}, get: getValue, value: nil, ok: false},
+		{name: "index into struct", path: internal.Path{"Address", 0}, get: getValue, value: nil, ok: false},
+		{name: "wrong type", path: internal.Path{"name"}, get: getNumber, value: float64(0), ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := test.get(p, test.path)
+			if ok != test.ok || !reflect.DeepEqual(value, test.value) {
+				t.Errorf("got %#v, %v, want %#v, %v", value, ok, test.value, test.ok)
+			}
+		})
+	}
+}
+
+func Test_StructParser_Ambiguous(t *testing.T) {
+	// built with reflect, since vet rejects a struct type promoting two
+	// fields with the same tag
+	ambiguous := reflect.StructOf([]reflect.StructField{
+		{Name: "A", Type: reflect.TypeOf(testTaggedA{}), Anonymous: true},
+		{Name: "B", Type: reflect.TypeOf(testTaggedB{}), Anonymous: true},
+		{Name: "Outer", Type: reflect.TypeOf(testUnambiguous{}), Tag: `json:"outer"`},
+	})
+	outer := testUnambiguous{testTaggedA: testTaggedA{X: "tagged"}, testUntaggedN: testUntaggedN{N: "untagged"}}
+	outer.Inner.N = "shallow"
+	outer.Inner.testUntaggedN.N = "deep"
+	rv := reflect.New(ambiguous).Elem()
+	rv.Field(0).Set(reflect.ValueOf(testTaggedA{X: "x"}))
+	rv.Field(1).Set(reflect.ValueOf(testTaggedB{Y: "y"}))
+	rv.Field(2).Set(reflect.ValueOf(outer))
+	v := rv.Interface()
+	p := internal.NewStructParser(v)
+
+	// encoding/json drops n, as both fields are as dominant
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		path  internal.Path
+		value interface{}
+		ok    bool
+	}{
+		{name: "same depth and both tagged", path: internal.Path{"n"}, value: nil, ok: false},
+		{name: "tagged beats untagged", path: internal.Path{"outer", "n"}, value: "tagged", ok: true},
+		{name: "untagged go name", path: internal.Path{"outer", "N"}, value: "untagged", ok: true},
+		{name: "shallower beats deeper", path: internal.Path{"outer", "inner", "N"}, value: "shallow", ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := p.GetValue(test.path)
+			if ok != test.ok || !reflect.DeepEqual(value, test.value) {
+				t.Errorf("got %#v, %v, want %#v, %v", value, ok, test.value, test.ok)
+			}
+			want, wantOK := internal.NewJSONParser(decoded).GetValue(test.path)
+			if ok != wantOK || !reflect.DeepEqual(value, want) {
+				t.Errorf("got %#v, %v, but encoding/json gives %#v, %v", value, ok, want, wantOK)
+			}
+		})
+	}
+}
+
+func Test_StructParser_Expression(t *testing.T) {
+	expr, err := parse(t, "($.Address.zip == '12345') && (length($.previous) == 2)")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	user := testUser{Address: &testAddress{Zip: "12345"}, Previous: make([]*testAddress, 2)}
+	if !expr.Value(internal.NewStructParser(user)).(bool) {
+		t.Errorf("expected expression to be true")
+	}
+
+	// elements of a filtered array are found in the structs themselves
+	expr, err = parse(t, "any($.previous, @.zip == '2') && count(filter($.previous, @.zip != '1'), @.zip == '2') == 1")
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	user = testUser{Previous: []*testAddress{{Zip: "1"}, {Zip: "2"}}}
+	if !expr.Value(internal.NewStructParser(user)).(bool) {
+		t.Errorf("expected array function expression to be true")
+	}
+}
@@ -21,6 +21,15 @@ func (iter *tokenIterator) peek() (Token, bool) {
 	return iter.tokens[iter.i], true
 }
 
+// lookahead returns the token n tokens after the next one, so lookahead(0) is
+// the same as peek.
+func (iter *tokenIterator) lookahead(n int) (Token, bool) {
+	if iter.i+n >= len(iter.tokens) {
+		return Token{}, false
+	}
+	return iter.tokens[iter.i+n], true
+}
+
 func (iter *tokenIterator) done() bool {
 	return iter.i == len(iter.tokens)
 }
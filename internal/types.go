@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of value an expression evaluates to or a Type describes.
+// AnyKind is used for bare paths, whose type is only known once they are
+// evaluated, and in a Type for values of unknown type. ObjectKind is only used
+// in a Type, and NullKind is the kind of the null literal.
+type Kind int
+
+const (
+	AnyKind Kind = iota
+	NumberKind
+	BooleanKind
+	StringKind
+	ArrayKind
+	ObjectKind
+	NullKind
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NumberKind:
+		return "number"
+	case BooleanKind:
+		return "boolean"
+	case StringKind:
+		return "string"
+	case ArrayKind:
+		return "array"
+	case ObjectKind:
+		return "object"
+	case NullKind:
+		return "null"
+	}
+	return "any"
+}
+
+// Type describes the shape of the data expressions are evaluated against, i.e.
+// a schema for the values a PathParser returns.
+type Type struct {
+	Kind Kind
+	// Elem is the type of the elements of an array.
+	Elem *Type
+	// Fields are the types of the fields of an object.
+	Fields map[string]*Type
+	// Optional is set if the value may be null or, for an object field,
+	// missing.
+	Optional bool
+}
+
+// Lookup returns the type of the value at path in a value of type t. A nil or
+// AnyKind type is treated as allowing any path, with a result of AnyKind. A
+// path with a Wildcard, Slice or Filter segment is an array of the type of the
+// values it selects, and a path with a Descendants segment is an array of
+// AnyKind.
+func (t *Type) Lookup(path Path) (*Type, error) {
+	current := t
+	for i, segment := range path {
+		if current == nil || current.Kind == AnyKind {
+			break
+		}
+		switch segment := segment.(type) {
+		case string:
+			if current.Kind != ObjectKind {
+				return nil, fmt.Errorf("%s is %s, not object", path[:i], current.Kind)
+			}
+			field, ok := current.Fields[segment]
+			if !ok {
+				return nil, fmt.Errorf("%s not found in schema", path[:i+1])
+			}
+			current = field
+		case int:
+			if current.Kind != ArrayKind {
+				return nil, fmt.Errorf("%s is %s, not array", path[:i], current.Kind)
+			}
+			current = current.Elem
+		case Descendants:
+			// the values it selects can be of any type
+			current = &Type{Kind: AnyKind}
+		case Wildcard, Slice, Filter:
+			if current.Kind != ArrayKind {
+				return nil, fmt.Errorf("%s is %s, not array", path[:i], current.Kind)
+			}
+			current = current.Elem
+		case Element:
+			// the caller passes the type of the element the path is relative to
+		}
+	}
+	if current == nil {
+		current = &Type{Kind: AnyKind}
+	}
+	if path.fansOut() {
+		return &Type{Kind: ArrayKind, Elem: current}, nil
+	}
+	return current, nil
+}
+
+// String formats t in JSTN notation.
+func (t *Type) String() string {
+	if t == nil {
+		return AnyKind.String()
+	}
+	var str string
+	switch t.Kind {
+	case ArrayKind:
+		str = fmt.Sprintf("[%s]", t.Elem)
+	case ObjectKind:
+		keys := make([]string, 0, len(t.Fields))
+		for key := range t.Fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fields := make([]string, len(keys))
+		for i, key := range keys {
+			name := key
+			if !isID(name) {
+				name = strconv.Quote(name)
+			}
+			fields[i] = fmt.Sprintf("%s: %s", name, t.Fields[key])
+		}
+		str = fmt.Sprintf("{%s}", strings.Join(fields, "; "))
+	default:
+		str = t.Kind.String()
+	}
+	if t.Optional {
+		str += "?"
+	}
+	return str
+}
+
+// matches reports whether v, a value decoded by encoding/json, is of type t.
+// Object fields that aren't in t are allowed.
+func (t *Type) matches(v interface{}) bool {
+	if t == nil || t.Kind == AnyKind {
+		return true
+	}
+	if v == nil {
+		return t.Optional || t.Kind == NullKind
+	}
+	switch t.Kind {
+	case NumberKind:
+		_, ok := toFloat(v)
+		return ok
+	case BooleanKind:
+		_, ok := v.(bool)
+		return ok
+	case StringKind:
+		_, ok := v.(string)
+		return ok
+	case ArrayKind:
+		a, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, elem := range a {
+			if !t.Elem.matches(elem) {
+				return false
+			}
+		}
+		return true
+	case ObjectKind:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, field := range t.Fields {
+			value, ok := m[key]
+			if !ok && !field.Optional || ok && !field.matches(value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}